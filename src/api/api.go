@@ -0,0 +1,72 @@
+package api
+
+import (
+	_ "embed"
+	"keepup/src/handler"
+	"net/http"
+)
+
+// Prefix is the base path of version 1 of the HTTP API. The same handlers
+// stay reachable on the unprefixed legacy paths so that deployed agents keep
+// working.
+const Prefix = "/api/v1"
+
+// OpenAPI is the OpenAPI 3 description of the version 1 API.
+//
+//go:embed openapi.json
+var OpenAPI []byte
+
+type Handlers struct {
+	Packages     *handler.PackageVersionsHandler
+	Clusters     *handler.KubernetesClusterMiddleware
+	Images       *handler.ContainerImagesMiddleware
+	Dependencies *handler.ApplicationDependenciesMiddleware
+	Certificates *handler.CertificatesMiddleware
+	Missing      *handler.MissingEntitiesHandler
+	// Custom serves the domains declared in CUSTOM_DOMAINS, which the
+	// OpenAPI document doesn't describe.
+	Custom []*handler.CustomDomainMiddleware
+}
+
+type Route struct {
+	Path    string
+	Handler http.HandlerFunc
+}
+
+// Routes lists the API routes relative to Prefix.
+func (h Handlers) Routes() []Route {
+	routes := []Route{
+		{"/package-version", h.Packages.Handler()},
+		{"/package-versions/batch", h.Packages.BatchHandler()},
+		{"/sbom", h.Packages.SBOMHandler()},
+		{"/helm-cluster", h.Clusters.Handler()},
+		{"/container-images", h.Images.Handler()},
+		{"/application-dependencies", h.Dependencies.Handler()},
+		{"/certificates", h.Certificates.Handler()},
+		{"/missing-entities", h.Missing.Handler()},
+	}
+	for _, custom := range h.Custom {
+		routes = append(routes, Route{custom.Records.Domain.Path, custom.Handler()})
+	}
+	return routes
+}
+
+// Register mounts every route both under Prefix and on its legacy path, and
+// serves the OpenAPI document at Prefix/openapi.json.
+func (h Handlers) Register(mux *http.ServeMux) {
+	for _, route := range h.Routes() {
+		mux.HandleFunc(route.Path, route.Handler)
+		mux.HandleFunc(Prefix+route.Path, route.Handler)
+	}
+	mux.HandleFunc(Prefix+"/openapi.json", serveOpenAPI)
+}
+
+func serveOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(OpenAPI)
+}
@@ -0,0 +1,378 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"keepup/src/handler"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+type spec struct {
+	Paths      map[string]map[string]operation `json:"paths"`
+	Components struct {
+		Responses map[string]response       `json:"responses"`
+		Schemas   map[string]map[string]any `json:"schemas"`
+	} `json:"components"`
+}
+
+type operation struct {
+	OperationID string              `json:"operationId"`
+	Responses   map[string]response `json:"responses"`
+}
+
+type response struct {
+	Ref     string                     `json:"$ref"`
+	Headers map[string]json.RawMessage `json:"headers"`
+	Content map[string]struct {
+		Schema map[string]any `json:"schema"`
+	} `json:"content"`
+}
+
+func loadSpec(t *testing.T) spec {
+	t.Helper()
+	var s spec
+	if err := json.Unmarshal(OpenAPI, &s); err != nil {
+		t.Fatalf("openapi.json is not valid JSON: %v", err)
+	}
+	return s
+}
+
+func newTestHandlers(t *testing.T) Handlers {
+	t.Helper()
+	ctx := context.Background()
+	con := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	eol := `{"package":{"redis":[{"cycle":"7.2","eol":false,"latest":"7.2.4"}],"kernel":[{"cycle":"6.8","eol":false,"latest":"6.8.12"}],"go":[{"cycle":"1.23","eol":false,"latest":"1.23.2"}]}}`
+	if err := con.Set(ctx, "eol_cache:all_packages", eol, 0).Err(); err != nil {
+		t.Fatalf("failed to seed eol cache: %v", err)
+	}
+	return Handlers{
+		Packages: &handler.PackageVersionsHandler{
+			PackageVersions: &handler.PackageVersionss{Items: make(map[uuid.UUID]handler.PackageVersions)},
+			Client:          con,
+			Context:         ctx,
+			ApiToken:        "secret",
+			TTL:             300,
+		},
+		Clusters: &handler.KubernetesClusterMiddleware{
+			Clusters: &handler.KubernetesClusters{Items: make(map[uuid.UUID]handler.KubernetesCluster)},
+			Client:   con,
+			Context:  ctx,
+			ApiToken: "secret",
+			TTL:      300,
+		},
+		Images: &handler.ContainerImagesMiddleware{
+			Images:   &handler.ContainerImageInventories{Items: make(map[uuid.UUID]handler.ContainerImages)},
+			Client:   con,
+			Context:  ctx,
+			ApiToken: "secret",
+			TTL:      300,
+		},
+		Dependencies: &handler.ApplicationDependenciesMiddleware{
+			Dependencies: &handler.ApplicationDependencyInventories{Items: make(map[uuid.UUID]handler.ApplicationDependencies)},
+			Client:       con,
+			Context:      ctx,
+			ApiToken:     "secret",
+			TTL:          300,
+		},
+		Certificates: &handler.CertificatesMiddleware{
+			Certificates: &handler.CertificateInventories{Items: make(map[uuid.UUID]handler.Certificates)},
+			Client:       con,
+			Context:      ctx,
+			ApiToken:     "secret",
+			TTL:          300,
+		},
+		Missing: &handler.MissingEntitiesHandler{
+			Client:   con,
+			Context:  ctx,
+			ApiToken: "secret",
+			Grace:    map[string]time.Duration{handler.EntityKindHost: time.Hour},
+		},
+	}
+}
+
+// exchange is one request of the contract test and the operation of the
+// specification it is expected to exercise. In header values, $etag stands
+// for the last ETag returned for the same path.
+type exchange struct {
+	operation string
+	method    string
+	path      string
+	body      string
+	header    [2]string
+	noToken   bool
+	status    int
+}
+
+func TestHandlersMatchOpenAPI(t *testing.T) {
+	s := loadSpec(t)
+	mux := http.NewServeMux()
+	newTestHandlers(t).Register(mux)
+
+	hostID := handler.UUIDFromDcAndIPPackage("dc1", "10.0.0.1")
+	clusterID := handler.UUIDFromClusterName("minikube")
+	artifactID := handler.UUIDFromArtifact("registry.example.com/api:1.4.2")
+	serviceID := handler.UUIDFromService("checkout", "")
+	exchanges := []exchange{
+		{"putPackageVersions", "PUT", "/package-version", `{"os":{"id":"debian","version":"12","kernel":"6.1.0-18-amd64"},"packages":{"redis":"7.2.1","data_center":"dc1","host_ip":"10.0.0.1","team":"core"}}`, [2]string{}, false, 200},
+		{"putPackageVersions", "PUT", "/package-version", `{"packages":{"redis":"7.2.1","data_center":"dc1"}}`, [2]string{}, false, 422},
+		{"putPackageVersions", "PUT", "/package-version", `{"packages":`, [2]string{}, false, 400},
+		{"putPackageVersions", "PUT", "/package-version", `{}`, [2]string{}, true, 403},
+		{"putPackageVersions", "PUT", "/package-version", `{"packages":{"redis":"7.2.1","data_center":"dc1","host_ip":"10.0.0.1"}}`, [2]string{"If-Match", `"stale"`}, false, 412},
+		{"patchPackageVersions", "PATCH", "/package-version", `{"packages":{"kernel":"6.8","redis":null,"data_center":"dc1","host_ip":"10.0.0.1"}}`, [2]string{}, false, 200},
+		{"patchPackageVersions", "PATCH", "/package-version", `{"packages":{"kernel":"6.8","data_center":"dc1","host_ip":"10.0.0.9"}}`, [2]string{}, false, 404},
+		{"patchPackageVersions", "PATCH", "/package-version", `{"packages":{"kernel":"6.8","data_center":"dc1"}}`, [2]string{}, false, 422},
+		{"getPackageVersions", "GET", "/package-version?id=" + hostID.String(), "", [2]string{}, false, 200},
+		{"getPackageVersions", "GET", "/package-version?id=" + hostID.String(), "", [2]string{"If-None-Match", "$etag"}, false, 304},
+		{"getPackageVersions", "GET", "/package-version?id=" + uuid.NewString(), "", [2]string{}, false, 404},
+		{"getPackageVersions", "GET", "/package-version?id=not-a-uuid", "", [2]string{}, false, 400},
+		{"deletePackageVersions", "DELETE", "/package-version?id=" + hostID.String(), "", [2]string{"If-Match", `"stale"`}, false, 412},
+		{"deletePackageVersions", "DELETE", "/package-version?id=" + hostID.String(), "", [2]string{"If-Match", "$etag"}, false, 204},
+		{"deletePackageVersions", "DELETE", "/package-version?id=" + hostID.String(), "", [2]string{}, false, 404},
+		{"putPackageVersionsBatch", "PUT", "/package-versions/batch", `[{"packages":{"redis":"7.2.1","data_center":"dc1","host_ip":"10.0.0.2"}},{"packages":{}}]`, [2]string{}, false, 200},
+		{"putSBOM", "PUT", "/sbom?artifact=registry.example.com/api:1.4.2", `{"bomFormat":"CycloneDX","specVersion":"1.5","components":[{"type":"library","name":"redis","version":"7.2.4","purl":"pkg:generic/redis@7.2.4"},{"type":"library","group":"@babel","name":"core","version":"7.24.0","purl":"pkg:npm/%40babel/core@7.24.0"}]}`, [2]string{}, false, 200},
+		{"putSBOM", "PUT", "/sbom?data_center=dc1", `{"spdxVersion":"SPDX-2.3","packages":[{"name":"openssl","versionInfo":"3.0.13"}]}`, [2]string{}, false, 422},
+		{"putSBOM", "PUT", "/sbom?artifact=api", `{"packages":[]}`, [2]string{}, false, 400},
+		{"getPackageVersions", "GET", "/package-version?id=" + artifactID.String(), "", [2]string{}, false, 200},
+		{"putCluster", "PUT", "/helm-cluster", `{"cluster_name":"minikube","kube_version":"1.30","helm_charts":[{"chart_name":"redis","version":"18.1.0","namespace":"cache"}]}`, [2]string{}, false, 201},
+		{"putCluster", "PUT", "/helm-cluster", `{"cluster_name":"minikube","helm_charts":[{}]}`, [2]string{}, false, 422},
+		{"putCluster", "POST", "/helm-cluster", `{}`, [2]string{}, false, 405},
+		{"patchCluster", "PATCH", "/helm-cluster", `{"cluster_name":"minikube","helm_charts":{"cache/redis":{"version":"18.2.0"},"db/postgresql":null}}`, [2]string{"If-Match", "$etag"}, false, 200},
+		{"patchCluster", "PATCH", "/helm-cluster", `{"cluster_name":"minikube","helm_charts":{"cache/redis":{"version":"18.3.0"}}}`, [2]string{"If-Match", `"stale"`}, false, 412},
+		{"getCluster", "GET", "/helm-cluster?id=" + clusterID.String(), "", [2]string{}, false, 200},
+		{"getCluster", "GET", "/helm-cluster?id=" + uuid.NewString(), "", [2]string{}, false, 404},
+		{"putContainerImages", "PUT", "/container-images", `{"cluster_name":"minikube","images":[{"repository":"redis","tag":"7.2-alpine","namespace":"cache","workload":"redis"}]}`, [2]string{}, false, 200},
+		{"putContainerImages", "PUT", "/container-images", `{"cluster_name":"minikube","data_center":"dc1","host_ip":"10.0.0.1","images":[]}`, [2]string{}, false, 422},
+		{"putContainerImages", "PUT", "/container-images", `{"cluster_name":"minikube","images":[]}`, [2]string{"If-Match", `"stale"`}, false, 412},
+		{"getContainerImages", "GET", "/container-images?id=" + clusterID.String(), "", [2]string{}, false, 200},
+		{"getContainerImages", "GET", "/container-images?id=" + clusterID.String(), "", [2]string{"If-None-Match", "$etag"}, false, 304},
+		{"deleteContainerImages", "DELETE", "/container-images?id=" + clusterID.String(), "", [2]string{"If-Match", "$etag"}, false, 204},
+		{"getContainerImages", "GET", "/container-images?id=" + clusterID.String(), "", [2]string{}, false, 404},
+		{"putCertificates", "PUT", "/certificates", `{"data_center":"dc1","host_ip":"10.0.0.1","certificates":[{"subject":"CN=api.example.com","sans":["api.example.com"],"issuer":"CN=R3,O=Let's Encrypt","not_after":"2030-01-01T00:00:00Z","serial":"04:a3","location":"/etc/ssl/api.pem"}]}`, [2]string{}, false, 200},
+		{"putCertificates", "PUT", "/certificates", `{"data_center":"dc1","host_ip":"10.0.0.1","certificates":[{"subject":"CN=api.example.com","not_after":"2030-01-01","location":"/etc/ssl/api.pem"}]}`, [2]string{}, false, 422},
+		{"getCertificates", "GET", "/certificates?id=" + hostID.String(), "", [2]string{}, false, 200},
+		{"getCertificates", "GET", "/certificates?id=" + hostID.String(), "", [2]string{"If-None-Match", "$etag"}, false, 304},
+		{"deleteCertificates", "DELETE", "/certificates?id=" + hostID.String(), "", [2]string{"If-Match", "$etag"}, false, 204},
+		{"getCertificates", "GET", "/certificates?id=" + hostID.String(), "", [2]string{}, false, 404},
+		{"putApplicationDependencies", "PUT", "/application-dependencies?service=checkout&manifest=go.mod", "module example.com/checkout\n\ngo 1.22.1\n", [2]string{}, false, 200},
+		{"putApplicationDependencies", "PUT", "/application-dependencies?service=checkout&manifest=Gemfile.lock", "", [2]string{}, false, 422},
+		{"putApplicationDependencies", "PUT", "/application-dependencies?service=checkout&manifest=web/package-lock.json", `{"packages":`, [2]string{}, false, 400},
+		{"getApplicationDependencies", "GET", "/application-dependencies?id=" + serviceID.String(), "", [2]string{}, false, 200},
+		{"getApplicationDependencies", "GET", "/application-dependencies?id=" + serviceID.String(), "", [2]string{"If-None-Match", "$etag"}, false, 304},
+		{"deleteApplicationDependencies", "DELETE", "/application-dependencies?id=" + serviceID.String(), "", [2]string{"If-Match", "$etag"}, false, 204},
+		{"getApplicationDependencies", "GET", "/application-dependencies?id=" + serviceID.String(), "", [2]string{}, false, 404},
+		{"listMissingEntities", "GET", "/missing-entities", "", [2]string{}, false, 200},
+		{"listMissingEntities", "GET", "/missing-entities", "", [2]string{}, true, 403},
+		{"deleteCluster", "DELETE", "/helm-cluster?id=" + clusterID.String(), "", [2]string{}, false, 204},
+		{"getOpenAPI", "GET", "/openapi.json", "", [2]string{}, true, 200},
+	}
+
+	exercised := make(map[string]bool)
+	etags := make(map[string]string)
+	for _, ex := range exchanges {
+		name := fmt.Sprintf("%s %s -> %d", ex.method, ex.path, ex.status)
+		path, _, _ := strings.Cut(ex.path, "?")
+		method := strings.ToLower(ex.method)
+		if ex.status == http.StatusMethodNotAllowed {
+			method = "put"
+		}
+		op, ok := s.Paths[path][method]
+		if !ok || op.OperationID != ex.operation {
+			t.Fatalf("%s: operation %s is not documented at %s %s", name, ex.operation, method, path)
+		}
+		exercised[op.OperationID] = true
+
+		req := httptest.NewRequest(ex.method, Prefix+ex.path, strings.NewReader(ex.body))
+		if !ex.noToken {
+			req.Header.Set("x-api-token", "secret")
+		}
+		if ex.header[0] != "" {
+			req.Header.Set(ex.header[0], strings.ReplaceAll(ex.header[1], "$etag", etags[path]))
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if etag := rec.Header().Get("ETag"); etag != "" {
+			etags[path] = etag
+		}
+
+		if rec.Code != ex.status {
+			t.Fatalf("%s: expected status %d, got %d: %s", name, ex.status, rec.Code, rec.Body.String())
+		}
+		res, ok := op.Responses[strconv.Itoa(rec.Code)]
+		if !ok {
+			t.Errorf("%s: status %d is not documented", name, rec.Code)
+			continue
+		}
+		if res.Ref != "" {
+			res = s.Components.Responses[strings.TrimPrefix(res.Ref, "#/components/responses/")]
+		}
+		for header := range res.Headers {
+			if rec.Header().Get(header) == "" {
+				t.Errorf("%s: documented header %s is missing", name, header)
+			}
+		}
+		if len(res.Content) == 0 {
+			continue
+		}
+		contentType, _, _ := strings.Cut(rec.Header().Get("Content-Type"), ";")
+		media, ok := res.Content[contentType]
+		if !ok {
+			t.Errorf("%s: content type %q is not documented", name, contentType)
+			continue
+		}
+		var body any
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Errorf("%s: response is not JSON: %v", name, err)
+			continue
+		}
+		for _, problem := range s.check(media.Schema, body, "$") {
+			t.Errorf("%s: %s", name, problem)
+		}
+	}
+
+	for path, methods := range s.Paths {
+		for method, op := range methods {
+			if !exercised[op.OperationID] {
+				t.Errorf("%s %s (%s) is not covered by the contract test", method, path, op.OperationID)
+			}
+		}
+	}
+}
+
+func TestRegister_ServesLegacyAndVersionedPaths(t *testing.T) {
+	s := loadSpec(t)
+	h := newTestHandlers(t)
+	mux := http.NewServeMux()
+	h.Register(mux)
+
+	for _, route := range h.Routes() {
+		if _, ok := s.Paths[route.Path]; !ok {
+			t.Errorf("route %s is not documented in openapi.json", route.Path)
+		}
+		for _, path := range []string{route.Path, Prefix + route.Path} {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+			if rec.Code != http.StatusForbidden {
+				t.Errorf("%s: expected the authenticated handler to answer 403, got %d", path, rec.Code)
+			}
+		}
+	}
+}
+
+// check validates v against the subset of JSON Schema used by openapi.json
+// and returns a description of every mismatch.
+func (s spec) check(schema map[string]any, v any, path string) []string {
+	if ref, ok := schema["$ref"].(string); ok {
+		return s.check(s.Components.Schemas[strings.TrimPrefix(ref, "#/components/schemas/")], v, path)
+	}
+
+	var problems []string
+	if !matchesType(schema["type"], v) {
+		return append(problems, fmt.Sprintf("%s: %v does not match type %v", path, v, schema["type"]))
+	}
+	if enum, ok := schema["enum"].([]any); ok {
+		found := false
+		for _, allowed := range enum {
+			found = found || allowed == v
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("%s: %v is not one of %v", path, v, enum))
+		}
+	}
+
+	switch value := v.(type) {
+	case map[string]any:
+		if required, ok := schema["required"].([]any); ok {
+			for _, name := range required {
+				if _, ok := value[name.(string)]; !ok {
+					problems = append(problems, fmt.Sprintf("%s: missing required property %q", path, name))
+				}
+			}
+		}
+		properties, _ := schema["properties"].(map[string]any)
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if property, ok := properties[key].(map[string]any); ok {
+				problems = append(problems, s.check(property, value[key], path+"."+key)...)
+				continue
+			}
+			switch additional := schema["additionalProperties"].(type) {
+			case map[string]any:
+				problems = append(problems, s.check(additional, value[key], path+"."+key)...)
+			case bool:
+				if !additional {
+					problems = append(problems, fmt.Sprintf("%s: unexpected property %q", path, key))
+				}
+			default:
+				if properties != nil {
+					problems = append(problems, fmt.Sprintf("%s: undocumented property %q", path, key))
+				}
+			}
+		}
+	case []any:
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range value {
+				problems = append(problems, s.check(items, item, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	case string:
+		if schema["format"] == "uuid" {
+			if _, err := uuid.Parse(value); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %q is not a uuid", path, value))
+			}
+		}
+	}
+	return problems
+}
+
+func matchesType(want any, v any) bool {
+	switch want := want.(type) {
+	case nil:
+		return true
+	case []any:
+		for _, alternative := range want {
+			if matchesType(alternative, v) {
+				return true
+			}
+		}
+		return false
+	case string:
+		switch want {
+		case "object":
+			_, ok := v.(map[string]any)
+			return ok
+		case "array":
+			_, ok := v.([]any)
+			return ok
+		case "string":
+			_, ok := v.(string)
+			return ok
+		case "boolean":
+			_, ok := v.(bool)
+			return ok
+		case "number":
+			_, ok := v.(float64)
+			return ok
+		case "integer":
+			n, ok := v.(float64)
+			return ok && n == float64(int64(n))
+		case "null":
+			return v == nil
+		}
+	}
+	return false
+}
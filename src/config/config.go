@@ -16,6 +16,27 @@ type Config struct {
 	REDIS_PORT  string `env:"REDIS_PORT"`
 	REDIS_DBNO  string `env:"REDIS_DBNO"`
 	TTL_SECONDS string `env:"TTL_SECONDS"`
+
+	// Optional settings fall back to their `default` tag when unset.
+	WEBHOOK_TARGETS         string `env:"WEBHOOK_TARGETS" default:"[]"`
+	WEBHOOK_DEDUP_SECONDS   string `env:"WEBHOOK_DEDUP_SECONDS" default:"86400"`
+	EOL_WARNING_DAYS        string `env:"EOL_WARNING_DAYS" default:"30"`
+	MISSING_GRACE_SECONDS   string `env:"MISSING_GRACE_SECONDS" default:""`
+	PACKAGE_TTL_SECONDS     string `env:"PACKAGE_TTL_SECONDS" default:""`
+	HELM_TTL_SECONDS        string `env:"HELM_TTL_SECONDS" default:""`
+	IMAGE_TTL_SECONDS       string `env:"IMAGE_TTL_SECONDS" default:""`
+	DEPENDENCY_TTL_SECONDS  string `env:"DEPENDENCY_TTL_SECONDS" default:""`
+	CERTIFICATE_TTL_SECONDS string `env:"CERTIFICATE_TTL_SECONDS" default:""`
+	MAX_TTL_SECONDS         string `env:"MAX_TTL_SECONDS" default:"604800"`
+
+	MAX_BODY_BYTES              string `env:"MAX_BODY_BYTES" default:"1048576"`
+	MAX_DECOMPRESSED_BODY_BYTES string `env:"MAX_DECOMPRESSED_BODY_BYTES" default:"8388608"`
+	MAX_BATCH_BODY_BYTES        string `env:"MAX_BATCH_BODY_BYTES" default:"33554432"`
+	STRICT_VALIDATION           string `env:"STRICT_VALIDATION" default:"false"`
+	HOST_IDENTITY_FIELDS        string `env:"HOST_IDENTITY_FIELDS" default:"data_center,host_ip"`
+	METRIC_LABELS               string `env:"METRIC_LABELS" default:""`
+	CUSTOM_DOMAINS              string `env:"CUSTOM_DOMAINS" default:"[]"`
+	CHART_REPOSITORIES          string `env:"CHART_REPOSITORIES" default:""`
 }
 
 var config *Config
@@ -42,10 +63,15 @@ func init() {
 	config = &Config{}
 	refl := reflect.ValueOf(config).Elem()
 	for i := 0; i < refl.NumField(); i++ {
-		envName := refl.Type().Field(i).Tag.Get("env")
+		field := refl.Type().Field(i)
+		envName := field.Tag.Get("env")
 		envVal, found := os.LookupEnv(envName)
 		if !found {
-			panic("Environment [" + envName + "] not found.")
+			defaultVal, hasDefault := field.Tag.Lookup("default")
+			if !hasDefault {
+				panic("Environment [" + envName + "] not found.")
+			}
+			envVal = defaultVal
 		}
 		refl.Field(i).SetString(envVal)
 	}
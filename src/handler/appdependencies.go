@@ -0,0 +1,214 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// The runtime and framework versions of a service are stored as one record
+// per service and environment, with a section per uploaded manifest. An
+// upload replaces only its own manifest's section, so a service built from
+// a Go backend and an npm frontend keeps both. A manifest that is not
+// uploaded again within the TTL of a later upload is dropped.
+
+const (
+	serviceUUIDSuffix                = "SERVICE_UUID"
+	applicationDependenciesKeyPrefix = "keepup:application_dependencies:"
+)
+
+type ApplicationDependencies struct {
+	ID          uuid.UUID                      `json:"id"`
+	Service     string                         `json:"service"`
+	Environment string                         `json:"environment,omitempty"`
+	Team        string                         `json:"team,omitempty"`
+	Manifests   map[string]ApplicationManifest `json:"manifests"`
+	UpdatedAt   string                         `json:"updated_at"`
+}
+
+// ApplicationManifest is what was taken from one manifest, keyed by its
+// path in the service's repository.
+type ApplicationManifest struct {
+	Format       string                  `json:"format"`
+	Ecosystem    string                  `json:"ecosystem"`
+	Dependencies []ApplicationDependency `json:"dependencies"`
+	UpdatedAt    string                  `json:"updated_at"`
+}
+
+// ApplicationDependency is a runtime, named go, node, python or php, or a
+// framework, named like its package. Version is the one the manifest pins,
+// or the lowest its constraint allows.
+type ApplicationDependency struct {
+	Name       string `json:"name"`
+	Kind       string `json:"kind"`
+	Version    string `json:"version"`
+	EOLProduct string `json:"eol_product"`
+
+	// Set by keepup from endoflife.date like the fields of PackageDetail.
+	CurrentVersion    string `json:"current_version"`
+	CurrentVersionEoF string `json:"current_version_eof"`
+	NewestVersion     string `json:"newest_version"`
+	Expired           bool   `json:"expired"`
+}
+
+// ManifestTarget names the service a manifest belongs to, from the query
+// string.
+type ManifestTarget struct {
+	Service     string `json:"service" validate:"required,max=128,label"`
+	Environment string `json:"environment" validate:"max=64,label"`
+	Team        string `json:"team" validate:"max=64,label"`
+	Manifest    string `json:"manifest" validate:"required,max=255,label"`
+}
+
+type ApplicationDependencyInventories struct {
+	Items map[uuid.UUID]ApplicationDependencies
+}
+
+var (
+	ErrDependenciesInsertFailed  = errors.New("Application dependencies insert failed")
+	ErrDependenciesMarshalFailed = errors.New("Application dependencies marshal failed")
+	ErrDependenciesNotFound      = errors.New("Application dependencies ID not found")
+	ErrDependenciesDeleteFailed  = errors.New("Application dependencies delete failed")
+)
+
+var dependencyRepository = Repository[ApplicationDependencies]{
+	Name:   "application dependencies",
+	Prefix: applicationDependenciesKeyPrefix,
+	Identity: func(app ApplicationDependencies) uuid.UUID {
+		return UUIDFromService(app.Service, app.Environment)
+	},
+	Errors: RepositoryErrors{
+		NotFound:      ErrDependenciesNotFound,
+		InsertFailed:  ErrDependenciesInsertFailed,
+		MarshalFailed: ErrDependenciesMarshalFailed,
+		DeleteFailed:  ErrDependenciesDeleteFailed,
+	},
+}
+
+func UUIDFromService(service string, environment string) uuid.UUID {
+	return uuid.NewSHA1(uuid.NameSpaceDNS, []byte(fmt.Sprintf("%s-%s-%s", service, environment, serviceUUIDSuffix)))
+}
+
+// violations reports a target without a service or naming an unsupported
+// manifest.
+func (target ManifestTarget) violations() []Violation {
+	violations := validate(target)
+	if _, _, ok := manifestFormat(target.Manifest); target.Manifest != "" && !ok {
+		violations = append(violations, Violation{
+			Field:   "manifest",
+			Rule:    "manifest",
+			Message: "must name a go.mod, package-lock.json, requirements.txt, poetry.lock or composer.lock file",
+		})
+	}
+	return violations
+}
+
+// sortDependencies orders the runtime first, then the frameworks by name.
+func sortDependencies(dependencies []ApplicationDependency) {
+	sort.Slice(dependencies, func(i, j int) bool {
+		if dependencies[i].Kind != dependencies[j].Kind {
+			return dependencies[i].Kind == DependencyRuntime
+		}
+		return dependencies[i].Name < dependencies[j].Name
+	})
+}
+
+// InsertIfMatch enriches the dependencies of a manifest and records them as
+// its section of the service's record, guarded by an If-Match header value
+// ("" for an unconditional write). It returns the ID and ETag of the stored
+// record.
+func (c *ApplicationDependencyInventories) InsertIfMatch(
+	target ManifestTarget,
+	dependencies []ApplicationDependency,
+	ctx context.Context,
+	con *redis.Client,
+	queryFunc func(string) (string, string, error),
+	ttl int,
+	ifMatch string,
+) (uuid.UUID, string, error) {
+	id := UUIDFromService(target.Service, target.Environment)
+	format, ecosystem, _ := manifestFormat(target.Manifest)
+	if dependencies == nil {
+		dependencies = []ApplicationDependency{}
+	}
+	for i := range dependencies {
+		dependency := &dependencies[i]
+		detail := enrichPackage(dependency.EOLProduct, dependency.Version, queryFunc)
+		dependency.CurrentVersion = detail.CurrentVersion
+		dependency.CurrentVersionEoF = detail.CurrentVersionEoF
+		dependency.NewestVersion = detail.NewestVersion
+		dependency.Expired = detail.Expired
+	}
+
+	now := time.Now()
+	updatedAt := fmt.Sprint(now.Unix())
+	etag, err := dependencyRepository.Update(id, ctx, con, ttl, ifMatch, true, func(current []byte) ([]byte, error) {
+		var app ApplicationDependencies
+		if current != nil {
+			if err := json.Unmarshal(current, &app); err != nil {
+				log.Printf("Replacing corrupt application dependencies %s: %v", id, err)
+				app = ApplicationDependencies{}
+			}
+		}
+		if app.Manifests == nil {
+			app.Manifests = make(map[string]ApplicationManifest)
+		}
+		for manifest, other := range app.Manifests {
+			if seen, err := strconv.ParseInt(other.UpdatedAt, 10, 64); err != nil || seen+int64(ttl) < now.Unix() {
+				delete(app.Manifests, manifest)
+			}
+		}
+		app.Manifests[target.Manifest] = ApplicationManifest{
+			Format:       format,
+			Ecosystem:    ecosystem,
+			Dependencies: dependencies,
+			UpdatedAt:    updatedAt,
+		}
+
+		app.ID = id
+		app.Service = target.Service
+		app.Environment = target.Environment
+		if target.Team != "" {
+			app.Team = target.Team
+		}
+		app.UpdatedAt = updatedAt
+		data, err := json.Marshal(app)
+		if err != nil {
+			return nil, ErrDependenciesMarshalFailed
+		}
+		return data, nil
+	})
+	if err != nil {
+		return id, "", err
+	}
+
+	log.Printf("Application dependencies of %s (%s) stored with ID: %s", target.Service, target.Manifest, id)
+	return id, etag, nil
+}
+
+func (c *ApplicationDependencyInventories) RetrieveWithETag(id uuid.UUID, ctx context.Context, con *redis.Client) (ApplicationDependencies, string, error) {
+	return dependencyRepository.Get(id, ctx, con)
+}
+
+// Delete removes the record of a service with all its manifests, guarded by
+// an If-Match header value ("" for an unconditional delete).
+func (c *ApplicationDependencyInventories) Delete(id uuid.UUID, ctx context.Context, con *redis.Client, ifMatch string) error {
+	if err := dependencyRepository.Delete(id, ctx, con, ifMatch); err != nil {
+		return err
+	}
+	log.Printf("Application dependencies %s deleted", id)
+	return nil
+}
+
+func (c *ApplicationDependencyInventories) Scan(ctx context.Context, con *redis.Client) (ApplicationDependencyInventories, error) {
+	items, err := dependencyRepository.Scan(ctx, con)
+	return ApplicationDependencyInventories{Items: items}, err
+}
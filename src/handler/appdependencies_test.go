@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestApplicationDependenciesInsert_KeepsOtherManifests(t *testing.T) {
+	ctx := context.Background()
+	con := newTestClient(t)
+	seedEOLCache(t, con, `{
+		"go": [{"cycle": "1.23", "eol": false, "latest": "1.23.2"}],
+		"nodejs": [{"cycle": "22", "eol": "2027-04-30", "latest": "22.9.0"}],
+		"react": [{"cycle": "18", "eol": false, "latest": "18.3.1"}]
+	}`)
+	c := &ApplicationDependencyInventories{Items: make(map[uuid.UUID]ApplicationDependencies)}
+	query := func(product string) (string, string, error) {
+		return queryEndOfLifeAPI(product, ctx, con)
+	}
+	insert := func(manifest string, data string) {
+		t.Helper()
+		format, _, _ := manifestFormat(manifest)
+		dependencies, err := parseManifest(format, []byte(data))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, _, err := c.InsertIfMatch(ManifestTarget{Service: "checkout", Team: "payments", Manifest: manifest}, dependencies, ctx, con, query, 60, ""); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	insert("go.mod", "module example.com/checkout\n\ngo 1.21\n")
+	insert("web/package-lock.json", `{"packages": {"": {"engines": {"node": "^20.11"}}, "node_modules/react": {"version": "18.2.0"}}}`)
+
+	app, _, err := c.RetrieveWithETag(UUIDFromService("checkout", ""), ctx, con)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(app.Manifests) != 2 || app.Team != "payments" {
+		t.Fatalf("expected both manifests of the team's service, got %+v", app)
+	}
+	goRuntime := app.Manifests["go.mod"].Dependencies[0]
+	if goRuntime.Kind != DependencyRuntime || goRuntime.EOLProduct != "go" || goRuntime.CurrentVersion != "1.21" || goRuntime.NewestVersion != "1.23" || !goRuntime.Expired {
+		t.Errorf("unexpected go runtime: %+v", goRuntime)
+	}
+	web := app.Manifests["web/package-lock.json"]
+	if web.Ecosystem != "npm" || len(web.Dependencies) != 2 {
+		t.Fatalf("unexpected npm manifest: %+v", web)
+	}
+	if node := web.Dependencies[0]; node.Name != "node" || node.EOLProduct != "nodejs" || node.CurrentVersionEoF != "2027-04-30" {
+		t.Errorf("unexpected node runtime: %+v", node)
+	}
+	if react := web.Dependencies[1]; react.Name != "react" || react.Kind != DependencyFramework || react.NewestVersion != "18.3" || !react.Expired {
+		t.Errorf("unexpected react framework: %+v", react)
+	}
+
+	inventories, err := c.Scan(ctx, con)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(inventories.Items) != 1 {
+		t.Errorf("expected one service, got %+v", inventories.Items)
+	}
+}
+
+func TestManifestTargetViolations(t *testing.T) {
+	cases := []struct {
+		target ManifestTarget
+		fields []string
+	}{
+		{ManifestTarget{Service: "checkout", Manifest: "backend/go.mod"}, nil},
+		{ManifestTarget{Manifest: "go.mod"}, []string{"service"}},
+		{ManifestTarget{Service: "checkout", Manifest: "Gemfile.lock"}, []string{"manifest"}},
+	}
+	for i, tc := range cases {
+		violations := tc.target.violations()
+		if len(violations) != len(tc.fields) {
+			t.Errorf("case %d: expected %v, got %+v", i, tc.fields, violations)
+			continue
+		}
+		for j, field := range tc.fields {
+			if violations[j].Field != field {
+				t.Errorf("case %d: expected violation at %s, got %s", i, field, violations[j].Field)
+			}
+		}
+	}
+}
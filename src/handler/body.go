@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// BodyLimits bounds a request body. Max applies to the bytes on the wire,
+// MaxDecompressed to what a gzip or zstd body expands to.
+type BodyLimits struct {
+	Max             int64
+	MaxDecompressed int64
+}
+
+var (
+	DefaultBodyLimits      = BodyLimits{Max: 1 << 20, MaxDecompressed: 8 << 20}
+	DefaultBatchBodyLimits = BodyLimits{Max: 32 << 20, MaxDecompressed: 32 << 20}
+)
+
+var (
+	ErrUnsupportedEncoding = errors.New("Unsupported content encoding")
+	ErrBodyTooLarge        = errors.New("Request body too large")
+)
+
+// or fills unset limits from def.
+func (l BodyLimits) or(def BodyLimits) BodyLimits {
+	if l.Max <= 0 {
+		l.Max = def.Max
+	}
+	if l.MaxDecompressed <= 0 {
+		l.MaxDecompressed = def.MaxDecompressed
+	}
+	return l
+}
+
+// requestBody returns the request body decoded according to its
+// Content-Encoding header (identity, gzip or zstd) and bounded by limits.
+func requestBody(w http.ResponseWriter, r *http.Request, limits BodyLimits) (io.ReadCloser, error) {
+	wire := http.MaxBytesReader(w, r.Body, limits.Max)
+
+	switch strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))) {
+	case "", "identity":
+		return wire, nil
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(wire)
+		if err != nil {
+			wire.Close()
+			return nil, err
+		}
+		return &decompressedBody{Reader: io.LimitReader(gz, limits.MaxDecompressed+1), limit: limits.MaxDecompressed, closers: []io.Closer{gz, wire}}, nil
+	case "zstd":
+		zr, err := zstd.NewReader(wire, zstd.WithDecoderMaxMemory(uint64(limits.MaxDecompressed)))
+		if err != nil {
+			wire.Close()
+			return nil, err
+		}
+		return &decompressedBody{Reader: io.LimitReader(zr, limits.MaxDecompressed+1), limit: limits.MaxDecompressed, closers: []io.Closer{zr.IOReadCloser(), wire}}, nil
+	default:
+		wire.Close()
+		return nil, ErrUnsupportedEncoding
+	}
+}
+
+// decompressedBody fails with ErrBodyTooLarge once more than limit bytes
+// have been produced, instead of silently truncating the document.
+type decompressedBody struct {
+	io.Reader
+	limit   int64
+	read    int64
+	closers []io.Closer
+}
+
+func (b *decompressedBody) Read(p []byte) (int, error) {
+	n, err := b.Reader.Read(p)
+	b.read += int64(n)
+	if b.read > b.limit {
+		return n, ErrBodyTooLarge
+	}
+	return n, err
+}
+
+func (b *decompressedBody) Close() error {
+	var err error
+	for _, c := range b.closers {
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// bodyError answers a failed body read: 415 for unknown encodings, 413 when
+// a limit was hit and 400 with msg otherwise.
+func bodyError(w http.ResponseWriter, r *http.Request, err error, msg string) {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, ErrUnsupportedEncoding):
+		writeError(w, r, err, "Unsupported Content-Encoding")
+	case errors.Is(err, ErrBodyTooLarge), errors.As(err, &maxBytesErr),
+		errors.Is(err, zstd.ErrDecoderSizeExceeded), errors.Is(err, zstd.ErrWindowSizeExceeded):
+		writeError(w, r, ErrBodyTooLarge, "Request body too large")
+	default:
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidPayload, msg)
+	}
+}
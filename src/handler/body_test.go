@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/klauspost/compress/zstd"
+)
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(data); err != nil {
+		t.Fatalf("failed to gzip body: %v", err)
+	}
+	gz.Close()
+	return buf.Bytes()
+}
+
+func zstdBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatalf("failed to create zstd encoder: %v", err)
+	}
+	defer enc.Close()
+	return enc.EncodeAll(data, nil)
+}
+
+func newTestClusterMiddleware(t *testing.T, limits BodyLimits) *KubernetesClusterMiddleware {
+	t.Helper()
+	return &KubernetesClusterMiddleware{
+		Clusters: &KubernetesClusters{Items: make(map[uuid.UUID]KubernetesCluster)},
+		Client:   newTestClient(t),
+		Context:  context.Background(),
+		ApiToken: "secret",
+		TTL:      60,
+		Limits:   limits,
+	}
+}
+
+func putCluster(s *KubernetesClusterMiddleware, encoding string, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPut, "/helm-cluster", bytes.NewReader(body))
+	req.Header.Set("x-api-token", "secret")
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	rec := httptest.NewRecorder()
+	s.Handler()(rec, req)
+	return rec
+}
+
+func TestRequestBody_DecodesCompressedPayloads(t *testing.T) {
+	payload := []byte(`{"cluster_name":"minikube","kube_version":"1.30"}`)
+	cases := map[string][]byte{
+		"":     payload,
+		"gzip": gzipBytes(t, payload),
+		"zstd": zstdBytes(t, payload),
+	}
+	for encoding, body := range cases {
+		s := newTestClusterMiddleware(t, BodyLimits{})
+		rec := putCluster(s, encoding, body)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("encoding %q: expected status 201, got %d: %s", encoding, rec.Code, rec.Body.String())
+		}
+		stored, err := s.Clusters.RetrieveCluster(UUIDFromClusterName("minikube"), s.Context, s.Client)
+		if err != nil || stored.KubeVersion != "1.30" {
+			t.Errorf("encoding %q: expected the decoded cluster to be stored, got %+v (err %v)", encoding, stored, err)
+		}
+	}
+}
+
+func TestRequestBody_RejectsOversizedDecompressedPayload(t *testing.T) {
+	payload := []byte(`{"cluster_name":"minikube","team":"` + strings.Repeat("a", 64<<10) + `"}`)
+	limits := BodyLimits{Max: 1 << 20, MaxDecompressed: 16 << 10}
+
+	for encoding, body := range map[string][]byte{"gzip": gzipBytes(t, payload), "zstd": zstdBytes(t, payload)} {
+		rec := putCluster(newTestClusterMiddleware(t, limits), encoding, body)
+		if rec.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("encoding %q: expected status 413, got %d", encoding, rec.Code)
+		}
+	}
+}
+
+func TestRequestBody_RejectsOversizedWirePayload(t *testing.T) {
+	payload := []byte(`{"cluster_name":"` + strings.Repeat("a", 4<<10) + `"}`)
+	rec := putCluster(newTestClusterMiddleware(t, BodyLimits{Max: 1 << 10}), "", payload)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected status 413, got %d", rec.Code)
+	}
+}
+
+func TestRequestBody_RejectsUnknownEncoding(t *testing.T) {
+	rec := putCluster(newTestClusterMiddleware(t, BodyLimits{}), "br", []byte(`{}`))
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("expected status 415, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,162 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// The TLS certificates found on a host, or in the secrets of a Kubernetes
+// cluster, are stored as one inventory per owner and replaced by every push,
+// like container images. The inventory shares its ID with the owner's
+// record under its own key prefix.
+
+const certificatesKeyPrefix = "keepup:certificates:"
+
+type Certificates struct {
+	ID           uuid.UUID         `json:"id"`
+	OwnerKind    string            `json:"owner_kind"`
+	ClusterName  string            `json:"cluster_name,omitempty" validate:"max=253,label"`
+	Project      string            `json:"project,omitempty" validate:"max=64,label"`
+	Environment  string            `json:"environment,omitempty" validate:"max=64,label"`
+	Region       string            `json:"region,omitempty" validate:"max=64,label"`
+	Provider     string            `json:"provider,omitempty" validate:"max=64,label"`
+	DataCenter   string            `json:"data_center,omitempty" validate:"max=64,label"`
+	HostIP       string            `json:"host_ip,omitempty" validate:"ip"`
+	Hostname     string            `json:"hostname,omitempty" validate:"max=253,label"`
+	MachineID    string            `json:"machine_id,omitempty" validate:"max=64,label"`
+	Identity     map[string]string `json:"identity,omitempty" validate_keys:"max=64,label"`
+	Team         string            `json:"team,omitempty" validate:"max=64,label"`
+	Labels       map[string]string `json:"labels,omitempty" validate_keys:"max=64"`
+	Certificates []Certificate     `json:"certificates"`
+	UpdatedAt    string            `json:"updated_at"`
+}
+
+// Certificate is one certificate in use. Location is where it was found: a
+// file path on a host, or <namespace>/<secret> in a cluster.
+type Certificate struct {
+	Subject  string   `json:"subject" validate:"required,max=1024"`
+	SANs     []string `json:"sans,omitempty"`
+	Issuer   string   `json:"issuer,omitempty" validate:"max=1024"`
+	NotAfter string   `json:"not_after" validate:"required,rfc3339"`
+	Serial   string   `json:"serial,omitempty" validate:"max=128"`
+	Location string   `json:"location" validate:"required,max=1024"`
+}
+
+type CertificateInventories struct {
+	Items        map[uuid.UUID]Certificates
+	HostIdentity []string // HOST_IDENTITY_FIELDS, DefaultHostIdentity when empty
+}
+
+var (
+	ErrCertificatesInsertFailed  = errors.New("Certificates insert failed")
+	ErrCertificatesMarshalFailed = errors.New("Certificates marshal failed")
+	ErrCertificatesNotFound      = errors.New("Certificates ID not found")
+	ErrCertificatesDeleteFailed  = errors.New("Certificates delete failed")
+)
+
+var certificateRepository = Repository[Certificates]{
+	Name:     "certificates",
+	Prefix:   certificatesKeyPrefix,
+	Identity: func(certs Certificates) uuid.UUID { return certs.ID },
+	Errors: RepositoryErrors{
+		NotFound:      ErrCertificatesNotFound,
+		InsertFailed:  ErrCertificatesInsertFailed,
+		MarshalFailed: ErrCertificatesMarshalFailed,
+		DeleteFailed:  ErrCertificatesDeleteFailed,
+	},
+}
+
+// OwnerID returns the ID of an inventory: the ID of its cluster, or of its
+// host record when no cluster is named.
+func (c *CertificateInventories) OwnerID(certs Certificates) uuid.UUID {
+	if certs.ClusterName == "" {
+		return ownerHostID(c.HostIdentity, certs.host())
+	}
+	return UUIDFromCluster(KubernetesCluster{
+		ClusterName: certs.ClusterName,
+		Project:     certs.Project,
+		Environment: certs.Environment,
+		Region:      certs.Region,
+		Provider:    certs.Provider,
+	})
+}
+
+// InsertIfMatch stores certs, guarded by an If-Match header value ("" for
+// an unconditional write). It returns the ID and ETag of the stored record.
+func (c *CertificateInventories) InsertIfMatch(certs Certificates, ctx context.Context, con *redis.Client, ttl int, ifMatch string) (uuid.UUID, string, error) {
+	certs.ID = c.OwnerID(certs)
+	certs.OwnerKind = OwnerKindHost
+	if certs.ClusterName != "" {
+		certs.OwnerKind = OwnerKindCluster
+	}
+	certs.UpdatedAt = fmt.Sprint(time.Now().Unix())
+
+	id, etag, err := certificateRepository.Put(certs, ctx, con, ttl, ifMatch)
+	if err != nil {
+		return id, "", err
+	}
+
+	log.Printf("Certificates of %s %s stored with ID: %s", certs.OwnerKind, ownerName(certs.ClusterName, certs.HostIP), id)
+	return id, etag, nil
+}
+
+func (c *CertificateInventories) RetrieveWithETag(id uuid.UUID, ctx context.Context, con *redis.Client) (Certificates, string, error) {
+	return certificateRepository.Get(id, ctx, con)
+}
+
+// Delete removes an inventory, guarded by an If-Match header value ("" for
+// an unconditional delete).
+func (c *CertificateInventories) Delete(id uuid.UUID, ctx context.Context, con *redis.Client, ifMatch string) error {
+	if err := certificateRepository.Delete(id, ctx, con, ifMatch); err != nil {
+		return err
+	}
+	log.Printf("Certificates %s deleted", id)
+	return nil
+}
+
+func (c *CertificateInventories) Scan(ctx context.Context, con *redis.Client) (CertificateInventories, error) {
+	items, err := certificateRepository.Scan(ctx, con)
+	return CertificateInventories{Items: items}, err
+}
+
+// host returns the owning host as its host record names it.
+func (certs Certificates) host() PackageVersions {
+	return PackageVersions{
+		DataCenterPkg: certs.DataCenter,
+		HostIPPkg:     certs.HostIP,
+		Hostname:      certs.Hostname,
+		MachineID:     certs.MachineID,
+		Identity:      certs.Identity,
+	}
+}
+
+// certificateViolations reports an inventory without exactly one owner, a
+// host owner lacking one of the hostIdentity fields, and certificates listed
+// more than once at the same location, which would collapse into the same
+// metric series.
+func certificateViolations(certs Certificates, hostIdentity []string) []Violation {
+	violations := ownerViolations(certs.ClusterName, certs.DataCenter, certs.HostIP)
+	if len(violations) == 0 && certs.ClusterName == "" {
+		violations = ownerIdentityViolations(hostIdentity, certs.host())
+	}
+
+	seen := make(map[string]bool)
+	for i, cert := range certs.Certificates {
+		key := cert.Location + "|" + cert.Serial + "|" + cert.Subject
+		if seen[key] {
+			violations = append(violations, Violation{
+				Field:   fmt.Sprintf("certificates[%d]", i),
+				Rule:    "duplicate",
+				Message: fmt.Sprintf("certificate %s is listed more than once at %s", cert.Subject, cert.Location),
+			})
+		}
+		seen[key] = true
+	}
+	return violations
+}
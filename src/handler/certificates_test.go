@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestHandleInsertCertificates(t *testing.T) {
+	ctx := context.Background()
+	con := newTestClient(t)
+	s := &CertificatesMiddleware{
+		Certificates: &CertificateInventories{Items: make(map[uuid.UUID]Certificates)},
+		Client:       con,
+		Context:      ctx,
+		ApiToken:     "secret",
+		TTL:          300,
+	}
+	put := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/certificates", strings.NewReader(body))
+		req.Header.Set("x-api-token", "secret")
+		rec := httptest.NewRecorder()
+		s.Handler()(rec, req)
+		return rec
+	}
+
+	rec := put(`{"cluster_name": "minikube", "certificates": [
+		{"subject": "CN=api.example.com", "sans": ["api.example.com", "www.example.com"], "issuer": "CN=R3,O=Let's Encrypt",
+		 "not_after": "2030-01-01T00:00:00Z", "serial": "04:a3:5c", "location": "ingress/api-tls"}
+	]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	inventories, err := s.Certificates.Scan(ctx, con)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stored, ok := inventories.Items[UUIDFromClusterName("minikube")]
+	if !ok || stored.OwnerKind != OwnerKindCluster || len(stored.Certificates) != 1 || len(stored.Certificates[0].SANs) != 2 {
+		t.Fatalf("expected the cluster's certificate to share the cluster's ID, got %+v", inventories.Items)
+	}
+
+	clusters, err := (&KubernetesClusters{}).ScanClusters(ctx, con)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(clusters.Items) != 0 {
+		t.Errorf("expected no clusters, got %+v", clusters.Items)
+	}
+}
+
+func TestCertificatesInsert_JoinsConfiguredHostID(t *testing.T) {
+	ctx := context.Background()
+	con := newTestClient(t)
+	fields := []string{"machine_id"}
+	c := &CertificateInventories{Items: make(map[uuid.UUID]Certificates), HostIdentity: fields}
+
+	certs := Certificates{
+		DataCenter:   "dc1",
+		HostIP:       "10.0.0.1",
+		MachineID:    "4c4c4544",
+		Certificates: []Certificate{{Subject: "CN=api.example.com", NotAfter: "2030-01-01T00:00:00Z", Location: "/etc/ssl/api.pem"}},
+	}
+	if violations := certificateViolations(certs, fields); len(violations) != 0 {
+		t.Fatalf("unexpected violations: %+v", violations)
+	}
+	id, _, err := c.InsertIfMatch(certs, ctx, con, 60, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	hosts := &PackageVersionss{Identity: fields}
+	if want := hosts.HostID(PackageVersions{DataCenterPkg: "dc2", HostIPPkg: "10.0.0.2", MachineID: "4c4c4544"}); id != want {
+		t.Errorf("expected the inventory to share the host record's ID %s, got %s", want, id)
+	}
+
+	certs.MachineID = ""
+	violations := certificateViolations(certs, fields)
+	if len(violations) != 1 || violations[0].Field != "machine_id" {
+		t.Errorf("expected a violation for the missing machine_id, got %+v", violations)
+	}
+}
+
+func TestCertificateViolations(t *testing.T) {
+	valid := Certificate{Subject: "CN=api.example.com", NotAfter: "2030-01-01T00:00:00Z", Location: "/etc/ssl/api.pem"}
+	cases := []struct {
+		certs  Certificates
+		fields []string
+	}{
+		{Certificates{DataCenter: "dc1", HostIP: "10.0.0.1", Certificates: []Certificate{valid}}, nil},
+		{Certificates{DataCenter: "dc1", Certificates: []Certificate{valid}}, []string{"cluster_name"}},
+		{Certificates{ClusterName: "minikube", Certificates: []Certificate{
+			{Subject: "CN=api.example.com", NotAfter: "Jan  1 00:00:00 2030 GMT", Location: "/etc/ssl/api.pem"},
+			{Subject: "CN=www.example.com", Location: "/etc/ssl/www.pem"},
+		}}, []string{"certificates[0].not_after", "certificates[1].not_after"}},
+		{Certificates{ClusterName: "minikube", Certificates: []Certificate{valid, valid}}, []string{"certificates[1]"}},
+	}
+	for i, tc := range cases {
+		violations := append(validate(tc.certs), certificateViolations(tc.certs, nil)...)
+		if len(violations) != len(tc.fields) {
+			t.Errorf("case %d: expected %v, got %+v", i, tc.fields, violations)
+			continue
+		}
+		for j, field := range tc.fields {
+			if violations[j].Field != field {
+				t.Errorf("case %d: expected violation at %s, got %s", i, field, violations[j].Field)
+			}
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"gopkg.in/yaml.v3"
+)
+
+// Charts that name their repository are enriched with the newest version
+// the repository's index.yaml offers, the Helm counterpart of the package
+// EOL lookup. Only the versions of every chart are cached, not the index.
+// Repository URLs come from the pushing agents, so only the repositories an
+// operator allows in CHART_REPOSITORIES are ever fetched.
+
+const (
+	chartIndexKeyPrefix = "keepup:chart_index:"
+	chartIndexTTL       = time.Hour
+	// A repository whose index can't be read isn't asked again for a while.
+	chartIndexFailureTTL = 5 * time.Minute
+	// chartLookupTimeout bounds the lookups of one write, however many
+	// repositories its charts name.
+	chartLookupTimeout = 10 * time.Second
+	maxChartIndexBytes = 64 << 20
+)
+
+var (
+	ErrInvalidChartRepository    = errors.New("Invalid chart repository")
+	errChartRepositoryNotAllowed = errors.New("Chart repository not allowed")
+)
+
+type chartRepositoryIndex struct {
+	Entries map[string][]struct {
+		Version string `yaml:"version"`
+	} `yaml:"entries"`
+}
+
+// ChartRepositories are the Helm repositories whose index may be read, by
+// URL without a trailing slash. A file URL lets a local stand-in replace a
+// remote repository.
+type ChartRepositories map[string]bool
+
+// ParseChartRepositories decodes the comma separated CHART_REPOSITORIES.
+func ParseChartRepositories(raw string) (ChartRepositories, error) {
+	repositories := make(ChartRepositories)
+	for _, repository := range strings.Split(raw, ",") {
+		repository = strings.TrimSuffix(strings.TrimSpace(repository), "/")
+		if repository == "" {
+			continue
+		}
+		location, err := url.Parse(repository)
+		switch {
+		case err != nil:
+			return nil, fmt.Errorf("%w: %q is not a URL", ErrInvalidChartRepository, repository)
+		case location.Scheme == "file" && location.Path != "":
+		case (location.Scheme == "http" || location.Scheme == "https") && location.Host != "":
+		default:
+			return nil, fmt.Errorf("%w: %q is not an http, https or file URL", ErrInvalidChartRepository, repository)
+		}
+		repositories[repository] = true
+	}
+	return repositories, nil
+}
+
+// Query returns the versions of chart in repository, newest first. Other
+// than allowed repositories are not fetched.
+func (r ChartRepositories) Query(ctx context.Context, con *redis.Client, repository string, chart string) ([]string, error) {
+	repository = strings.TrimSuffix(repository, "/")
+	if !r[repository] {
+		return nil, errChartRepositoryNotAllowed
+	}
+	key := chartIndexKey(repository)
+	var index map[string][]string
+
+	// A failed fetch is cached as null, which has no versions.
+	cached, err := con.Get(ctx, key).Bytes()
+	if err == nil && json.Unmarshal(cached, &index) == nil {
+		return index[chart], nil
+	}
+
+	index, err = fetchChartIndex(ctx, repository)
+	if err != nil {
+		// Running out of time says nothing about the repository.
+		if ctx.Err() == nil {
+			if err := con.Set(ctx, key, "null", chartIndexFailureTTL).Err(); err != nil {
+				log.Printf("Can't cache failed index of %s: %v", repository, err)
+			}
+		}
+		return nil, fmt.Errorf("failed to fetch index of %s: %w", repository, err)
+	}
+	data, err := json.Marshal(index)
+	if err != nil {
+		return nil, err
+	}
+	if err := con.Set(ctx, key, data, chartIndexTTL).Err(); err != nil {
+		log.Printf("Can't cache index of %s: %v", repository, err)
+	}
+	return index[chart], nil
+}
+
+func chartIndexKey(repository string) string {
+	sum := sha1.Sum([]byte(repository))
+	return chartIndexKeyPrefix + hex.EncodeToString(sum[:])
+}
+
+// fetchChartIndex reads <repository>/index.yaml (http, https or file URL)
+// and returns the versions of every chart, newest first.
+func fetchChartIndex(ctx context.Context, repository string) (map[string][]string, error) {
+	location, err := url.Parse(repository + "/index.yaml")
+	if err != nil {
+		return nil, err
+	}
+	switch location.Scheme {
+	case "file":
+		file, err := os.Open(location.Path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return parseChartIndex(file)
+	case "http", "https":
+	default:
+		return nil, fmt.Errorf("unsupported scheme %q", location.Scheme)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return parseChartIndex(resp.Body)
+}
+
+// parseChartIndex returns the versions of every chart in an index.yaml,
+// newest first.
+func parseChartIndex(body io.Reader) (map[string][]string, error) {
+	var raw chartRepositoryIndex
+	if err := yaml.NewDecoder(io.LimitReader(body, maxChartIndexBytes)).Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	index := make(map[string][]string, len(raw.Entries))
+	for chart, entries := range raw.Entries {
+		versions := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			versions = append(versions, entry.Version)
+		}
+		sort.Slice(versions, func(i, j int) bool {
+			return compareChartVersions(versions[i], versions[j]) > 0
+		})
+		index[chart] = versions
+	}
+	return index, nil
+}
+
+// enrichChart sets the latest version of chart from versions (newest
+// first) and how many releases the deployed version is behind. Pre-releases
+// are ignored.
+func enrichChart(chart HelmChartData, versions []string) HelmChartData {
+	chart.LatestVersion = ""
+	chart.VersionsBehind = 0
+	for _, version := range versions {
+		if strings.Contains(version, "-") {
+			continue
+		}
+		if chart.LatestVersion == "" {
+			chart.LatestVersion = version
+		}
+		if compareChartVersions(version, chart.Version) <= 0 {
+			break
+		}
+		chart.VersionsBehind++
+	}
+	chart.Outdated = chart.VersionsBehind > 0
+	return chart
+}
+
+// enrichCharts looks up the charts that name a repository. Charts whose
+// lookup fails keep no latest version.
+func (c *KubernetesClusters) enrichCharts(ctx context.Context, con *redis.Client, charts []HelmChartData) {
+	applyChartVersions(charts, c.chartVersions(ctx, con, charts))
+}
+
+// chartVersions looks up the versions of the charts that name a repository,
+// by repository and chart name; nil when charts are not enriched. A failed
+// lookup is recorded without versions; charts left once chartLookupTimeout
+// has passed are not recorded at all.
+func (c *KubernetesClusters) chartVersions(ctx context.Context, con *redis.Client, charts []HelmChartData) map[[2]string][]string {
+	if c.ChartVersions == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, chartLookupTimeout)
+	defer cancel()
+
+	lookups := make(map[[2]string][]string)
+	for _, chart := range charts {
+		ref := [2]string{chart.Repository, chart.ChartName}
+		if _, ok := lookups[ref]; ok || chart.Repository == "" {
+			continue
+		}
+		versions, err := c.ChartVersions(ctx, con, chart.Repository, chart.ChartName)
+		switch {
+		case err == nil, err == errChartRepositoryNotAllowed:
+		case ctx.Err() != nil:
+			log.Printf("Chart lookups timed out, %s is not enriched", chart.ChartName)
+			continue
+		default:
+			log.Printf("Can't look up chart %s: %v", chart.ChartName, err)
+		}
+		lookups[ref] = versions
+	}
+	return lookups
+}
+
+// applyChartVersions enriches the charts looked up by chartVersions and
+// leaves the others as they are.
+func applyChartVersions(charts []HelmChartData, lookups map[[2]string][]string) {
+	for i, chart := range charts {
+		if versions, ok := lookups[[2]string{chart.Repository, chart.ChartName}]; ok {
+			charts[i] = enrichChart(chart, versions)
+		}
+	}
+}
+
+// compareChartVersions compares two semantic versions by their numeric
+// segments, then orders a pre-release before its release.
+func compareChartVersions(a string, b string) int {
+	coreA, preA, _ := strings.Cut(strings.TrimPrefix(a, "v"), "-")
+	coreB, preB, _ := strings.Cut(strings.TrimPrefix(b, "v"), "-")
+	coreA, _, _ = strings.Cut(coreA, "+")
+	coreB, _, _ = strings.Cut(coreB, "+")
+
+	segmentsA := strings.Split(coreA, ".")
+	segmentsB := strings.Split(coreB, ".")
+	for i := 0; i < len(segmentsA) || i < len(segmentsB); i++ {
+		var x, y int
+		if i < len(segmentsA) {
+			x, _ = strconv.Atoi(segmentsA[i])
+		}
+		if i < len(segmentsB) {
+			y, _ = strconv.Atoi(segmentsB[i])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	switch {
+	case preA == preB:
+		return 0
+	case preA == "":
+		return 1
+	case preB == "":
+		return -1
+	default:
+		return strings.Compare(preA, preB)
+	}
+}
@@ -0,0 +1,173 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+const testChartIndex = `apiVersion: v1
+entries:
+  redis:
+    - version: 18.2.0
+    - version: 18.10.0-rc.1
+    - version: 18.1.5
+    - version: 18.10.0
+    - version: 17.0.0
+  keepup:
+    - version: 0.5.0
+`
+
+func newTestChartRepository(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "index.yaml"), []byte(testChartIndex), 0o644); err != nil {
+		t.Fatalf("can't write index: %v", err)
+	}
+	return "file://" + dir
+}
+
+func TestParseChartRepositories(t *testing.T) {
+	repositories, err := ParseChartRepositories(" https://charts.bitnami.com/bitnami/, http://charts.internal ")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(repositories) != 2 || !repositories["https://charts.bitnami.com/bitnami"] || !repositories["http://charts.internal"] {
+		t.Errorf("expected both repositories without a trailing slash, got %v", repositories)
+	}
+	for _, raw := range []string{"file:", "charts.internal", "https://", "ftp://charts.internal"} {
+		if _, err := ParseChartRepositories(raw); !errors.Is(err, ErrInvalidChartRepository) {
+			t.Errorf("%s: expected ErrInvalidChartRepository, got %v", raw, err)
+		}
+	}
+}
+
+func TestChartRepositoriesQuery_FetchesAllowedIndexesAndCaches(t *testing.T) {
+	ctx := context.Background()
+	con := newTestClient(t)
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		if r.URL.Path != "/stable/index.yaml" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(testChartIndex))
+	}))
+	repositories, err := ParseChartRepositories(srv.URL + "/stable," + srv.URL + "/gone")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	versions, err := repositories.Query(ctx, con, srv.URL+"/stable/", "redis")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"18.10.0", "18.10.0-rc.1", "18.2.0", "18.1.5", "17.0.0"}
+	if len(versions) != len(want) {
+		t.Fatalf("expected %v, got %v", want, versions)
+	}
+	for i := range want {
+		if versions[i] != want[i] {
+			t.Errorf("version %d: expected %s, got %s", i, want[i], versions[i])
+		}
+	}
+
+	if _, err := repositories.Query(ctx, con, srv.URL+"/other", "redis"); err != errChartRepositoryNotAllowed {
+		t.Errorf("expected a repository outside the allow-list to be refused, got %v", err)
+	}
+	if _, err := repositories.Query(ctx, con, srv.URL+"/gone", "redis"); err == nil {
+		t.Error("expected a failed fetch to be reported")
+	}
+	if versions, err := repositories.Query(ctx, con, srv.URL+"/gone", "redis"); err != nil || versions != nil {
+		t.Errorf("expected the failure to be cached, got %v (err %v)", versions, err)
+	}
+	if got := fetches.Load(); got != 2 {
+		t.Errorf("expected one fetch per allowed repository, got %d", got)
+	}
+
+	// The cached index answers once the repository is gone.
+	srv.Close()
+	if versions, err := repositories.Query(ctx, con, srv.URL+"/stable", "keepup"); err != nil || len(versions) != 1 {
+		t.Errorf("expected keepup from the cache, got %v (err %v)", versions, err)
+	}
+}
+
+func TestChartRepositoriesQuery_ReadsOnlyListedFileIndexes(t *testing.T) {
+	ctx := context.Background()
+	con := newTestClient(t)
+	repository := newTestChartRepository(t)
+	repositories, err := ParseChartRepositories(repository)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	versions, err := repositories.Query(ctx, con, repository, "redis")
+	if err != nil || len(versions) != 5 || versions[0] != "18.10.0" {
+		t.Errorf("expected the versions from the file index, got %v (err %v)", versions, err)
+	}
+	if _, err := repositories.Query(ctx, con, "file:///etc", "redis"); err != errChartRepositoryNotAllowed {
+		t.Errorf("expected an unlisted path to be refused, got %v", err)
+	}
+}
+
+func TestClusterInsert_EnrichesChartsWithRepository(t *testing.T) {
+	ctx := context.Background()
+	con := newTestClient(t)
+	repository := newTestChartRepository(t)
+	repositories, err := ParseChartRepositories(repository)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c := &KubernetesClusters{Items: make(map[uuid.UUID]KubernetesCluster), ChartVersions: repositories.Query}
+
+	id, err := c.InsertClusterData(KubernetesCluster{
+		ClusterName: "minikube",
+		KubeVersion: "1.30",
+		HelmCharts: []HelmChartData{
+			{ChartName: "redis", Version: "18.1.5", Namespace: "cache", Repository: repository},
+			{ChartName: "keepup", Version: "0.5.0", Namespace: "monitoring", Repository: repository},
+			{ChartName: "ingress-nginx", Version: "4.9.0", Namespace: "ingress"},
+		},
+	}, ctx, con, 60)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	stored, _ := c.RetrieveCluster(id, ctx, con)
+	want := []HelmChartData{
+		{ChartName: "redis", Version: "18.1.5", Namespace: "cache", Repository: repository, LatestVersion: "18.10.0", Outdated: true, VersionsBehind: 2},
+		{ChartName: "keepup", Version: "0.5.0", Namespace: "monitoring", Repository: repository, LatestVersion: "0.5.0"},
+		{ChartName: "ingress-nginx", Version: "4.9.0", Namespace: "ingress"},
+	}
+	for i := range want {
+		if stored.HelmCharts[i] != want[i] {
+			t.Errorf("chart %d: expected %+v, got %+v", i, want[i], stored.HelmCharts[i])
+		}
+	}
+}
+
+func TestCompareChartVersions(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"18.10.0", "18.2.0", 1},
+		{"v1.2.3", "1.2.3", 0},
+		{"1.2.3-rc.1", "1.2.3", -1},
+		{"1.2", "1.2.0", 0},
+		{"1.2.3+build.5", "1.2.4", -1},
+	}
+	for _, c := range cases {
+		if got := compareChartVersions(c.a, c.b); got != c.want {
+			t.Errorf("compareChartVersions(%q, %q) = %d, want %d", c.a, c.b, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// The container images running on a cluster, or on a host for plain Docker,
+// are stored as one inventory per owner and replaced by every push. The
+// inventory shares its ID with the owner's cluster or host record but lives
+// under its own key prefix, so the package and cluster scans never see it.
+
+const (
+	OwnerKindCluster = "cluster"
+	OwnerKindHost    = "host"
+
+	containerImagesKeyPrefix = "keepup:container_images:"
+)
+
+// imageEOLProducts maps the last path segment of an image repository onto
+// the endoflife.date product whose release cycles its tags follow.
+var imageEOLProducts = map[string]string{
+	"redis":         "redis",
+	"memcached":     "memcached",
+	"mongo":         "mongodb",
+	"mongodb":       "mongodb",
+	"mysql":         "mysql",
+	"rabbitmq":      "rabbitmq",
+	"envoy":         "envoy",
+	"debian":        "debian",
+	"postgres":      "postgresql",
+	"postgresql":    "postgresql",
+	"elasticsearch": "elasticsearch",
+	"php":           "php",
+	"gitlab-runner": "gitlab-runner",
+}
+
+type ContainerImages struct {
+	ID          uuid.UUID         `json:"id"`
+	OwnerKind   string            `json:"owner_kind"`
+	ClusterName string            `json:"cluster_name,omitempty" validate:"max=253,label"`
+	Project     string            `json:"project,omitempty" validate:"max=64,label"`
+	Environment string            `json:"environment,omitempty" validate:"max=64,label"`
+	Region      string            `json:"region,omitempty" validate:"max=64,label"`
+	Provider    string            `json:"provider,omitempty" validate:"max=64,label"`
+	DataCenter  string            `json:"data_center,omitempty" validate:"max=64,label"`
+	HostIP      string            `json:"host_ip,omitempty" validate:"ip"`
+	Hostname    string            `json:"hostname,omitempty" validate:"max=253,label"`
+	MachineID   string            `json:"machine_id,omitempty" validate:"max=64,label"`
+	Identity    map[string]string `json:"identity,omitempty" validate_keys:"max=64,label"`
+	Team        string            `json:"team,omitempty" validate:"max=64,label"`
+	Labels      map[string]string `json:"labels,omitempty" validate_keys:"max=64"`
+	Images      []ContainerImage  `json:"images"`
+	UpdatedAt   string            `json:"updated_at"`
+}
+
+// ContainerImage is one image in use. Namespace and workload are empty for
+// plain Docker, where the container name tells the uses apart.
+type ContainerImage struct {
+	Repository string `json:"repository" validate:"required,max=255,label"`
+	Tag        string `json:"tag,omitempty" validate:"max=128,label"`
+	Digest     string `json:"digest,omitempty" validate:"max=128,label"`
+	Namespace  string `json:"namespace,omitempty" validate:"max=63,label"`
+	Workload   string `json:"workload,omitempty" validate:"max=253,label"`
+	Container  string `json:"container,omitempty" validate:"max=253,label"`
+
+	// Set by keepup from endoflife.date, see enrichImages.
+	EOLProduct        string `json:"eol_product,omitempty"`
+	CurrentVersion    string `json:"current_version,omitempty"`
+	CurrentVersionEoF string `json:"current_version_eol,omitempty"`
+	NewestVersion     string `json:"newest_version,omitempty"`
+	Expired           bool   `json:"expired,omitempty"`
+}
+
+type ContainerImageInventories struct {
+	Items        map[uuid.UUID]ContainerImages
+	HostIdentity []string // HOST_IDENTITY_FIELDS, DefaultHostIdentity when empty
+	// EOLEntries looks up the release cycles of an endoflife.date product;
+	// images are not enriched when it is nil. See QueryEOLEntries.
+	EOLEntries func(ctx context.Context, con *redis.Client, product string) ([]EndOfLifeEntry, error)
+}
+
+var (
+	ErrImagesInsertFailed  = errors.New("Container images insert failed")
+	ErrImagesMarshalFailed = errors.New("Container images marshal failed")
+	ErrImagesNotFound      = errors.New("Container images ID not found")
+	ErrImagesDeleteFailed  = errors.New("Container images delete failed")
+)
+
+var imageRepository = Repository[ContainerImages]{
+	Name:     "container images",
+	Prefix:   containerImagesKeyPrefix,
+	Identity: func(images ContainerImages) uuid.UUID { return images.ID },
+	Errors: RepositoryErrors{
+		NotFound:      ErrImagesNotFound,
+		InsertFailed:  ErrImagesInsertFailed,
+		MarshalFailed: ErrImagesMarshalFailed,
+		DeleteFailed:  ErrImagesDeleteFailed,
+	},
+}
+
+// OwnerID returns the ID of an inventory: the ID of its cluster, or of its
+// host record when no cluster is named.
+func (c *ContainerImageInventories) OwnerID(images ContainerImages) uuid.UUID {
+	if images.ClusterName == "" {
+		return ownerHostID(c.HostIdentity, images.host())
+	}
+	return UUIDFromCluster(KubernetesCluster{
+		ClusterName: images.ClusterName,
+		Project:     images.Project,
+		Environment: images.Environment,
+		Region:      images.Region,
+		Provider:    images.Provider,
+	})
+}
+
+// InsertIfMatch stores images, guarded by an If-Match header value ("" for
+// an unconditional write). It returns the ID and ETag of the stored record.
+func (c *ContainerImageInventories) InsertIfMatch(images ContainerImages, ctx context.Context, con *redis.Client, ttl int, ifMatch string) (uuid.UUID, string, error) {
+	images.ID = c.OwnerID(images)
+	images.OwnerKind = OwnerKindHost
+	if images.ClusterName != "" {
+		images.OwnerKind = OwnerKindCluster
+	}
+	images.UpdatedAt = fmt.Sprint(time.Now().Unix())
+	c.enrichImages(ctx, con, images.Images)
+
+	id, etag, err := imageRepository.Put(images, ctx, con, ttl, ifMatch)
+	if err != nil {
+		return id, "", err
+	}
+
+	log.Printf("Container images of %s %s stored with ID: %s", images.OwnerKind, ownerName(images.ClusterName, images.HostIP), id)
+	return id, etag, nil
+}
+
+func (c *ContainerImageInventories) RetrieveWithETag(id uuid.UUID, ctx context.Context, con *redis.Client) (ContainerImages, string, error) {
+	return imageRepository.Get(id, ctx, con)
+}
+
+// Delete removes an inventory, guarded by an If-Match header value ("" for
+// an unconditional delete).
+func (c *ContainerImageInventories) Delete(id uuid.UUID, ctx context.Context, con *redis.Client, ifMatch string) error {
+	if err := imageRepository.Delete(id, ctx, con, ifMatch); err != nil {
+		return err
+	}
+	log.Printf("Container images %s deleted", id)
+	return nil
+}
+
+func (c *ContainerImageInventories) Scan(ctx context.Context, con *redis.Client) (ContainerImageInventories, error) {
+	items, err := imageRepository.Scan(ctx, con)
+	return ContainerImageInventories{Items: items}, err
+}
+
+func ownerName(clusterName string, hostIP string) string {
+	if clusterName != "" {
+		return clusterName
+	}
+	return hostIP
+}
+
+// host returns the owning host as its host record names it.
+func (images ContainerImages) host() PackageVersions {
+	return PackageVersions{
+		DataCenterPkg: images.DataCenter,
+		HostIPPkg:     images.HostIP,
+		Hostname:      images.Hostname,
+		MachineID:     images.MachineID,
+		Identity:      images.Identity,
+	}
+}
+
+// ownerViolations reports an inventory that names neither or both of a
+// cluster and a host.
+func ownerViolations(clusterName string, dataCenter string, hostIP string) []Violation {
+	host := dataCenter != "" || hostIP != ""
+	switch {
+	case clusterName != "" && host:
+		return []Violation{{Field: "cluster_name", Rule: "owner", Message: "can't be combined with data_center and host_ip"}}
+	case clusterName == "" && (dataCenter == "" || hostIP == ""):
+		return []Violation{{Field: "cluster_name", Rule: "owner", Message: "is required unless data_center and host_ip are set"}}
+	}
+	return nil
+}
+
+// imageViolations reports an inventory without exactly one owner, a host
+// owner lacking one of the hostIdentity fields, and images listed more than
+// once, which would collapse into the same metric series.
+func imageViolations(images ContainerImages, hostIdentity []string) []Violation {
+	violations := ownerViolations(images.ClusterName, images.DataCenter, images.HostIP)
+	if len(violations) == 0 && images.ClusterName == "" {
+		violations = ownerIdentityViolations(hostIdentity, images.host())
+	}
+
+	seen := make(map[string]bool)
+	for i, image := range images.Images {
+		key := strings.Join([]string{image.Namespace, image.Workload, image.Container, image.Repository, image.Tag, image.Digest}, "|")
+		if seen[key] {
+			violations = append(violations, Violation{
+				Field:   fmt.Sprintf("images[%d]", i),
+				Rule:    "duplicate",
+				Message: fmt.Sprintf("image %s is listed more than once for the same container", image.Repository),
+			})
+		}
+		seen[key] = true
+	}
+	return violations
+}
+
+// imageProduct returns the endoflife.date product of an image repository
+// such as redis, docker.io/library/postgres or bitnami/redis.
+func imageProduct(repository string) (string, bool) {
+	name := repository[strings.LastIndex(repository, "/")+1:]
+	product, ok := imageEOLProducts[strings.ToLower(name)]
+	return product, ok
+}
+
+// enrichImages sets the EOL fields of the images that map onto a known
+// product. They stay empty when the tag's release cycle is unknown or the
+// lookup fails.
+func (c *ContainerImageInventories) enrichImages(ctx context.Context, con *redis.Client, images []ContainerImage) {
+	for i := range images {
+		image := &images[i]
+		image.EOLProduct = ""
+		image.CurrentVersion = ""
+		image.CurrentVersionEoF = ""
+		image.NewestVersion = ""
+		image.Expired = false
+		if c.EOLEntries == nil {
+			continue
+		}
+		product, ok := imageProduct(image.Repository)
+		cycles := releaseCycles(image.Tag)
+		if !ok || len(cycles) == 0 {
+			continue
+		}
+
+		entries, err := c.EOLEntries(ctx, con, product)
+		if err != nil {
+			log.Printf("Can't look up EOL of %s: %v", product, err)
+			continue
+		}
+	lookup:
+		for _, cycle := range cycles {
+			for _, entry := range entries {
+				if entry.Cycle != cycle {
+					continue
+				}
+				image.EOLProduct = product
+				image.CurrentVersion = cycle
+				image.CurrentVersionEoF = string(entry.EOL)
+				image.NewestVersion = entry.Latest
+				image.Expired = eolPassed(image.CurrentVersionEoF, time.Now())
+				break lookup
+			}
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package handler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestContainerImagesInsert_EnrichesKnownProducts(t *testing.T) {
+	ctx := context.Background()
+	con := newTestClient(t)
+	seedEOLCache(t, con, `{
+		"redis": [
+			{"cycle": "7.2", "eol": "2099-02-28", "latest": "7.2.5"},
+			{"cycle": "6.2", "eol": "2024-02-28", "latest": "6.2.14"}
+		],
+		"postgresql": [
+			{"cycle": "13", "eol": "2025-11-13", "latest": "13.16"}
+		]
+	}`)
+	c := &ContainerImageInventories{Items: make(map[uuid.UUID]ContainerImages), EOLEntries: QueryEOLEntries}
+
+	id, _, err := c.InsertIfMatch(ContainerImages{
+		ClusterName: "minikube",
+		Images: []ContainerImage{
+			{Repository: "redis", Tag: "6.2", Namespace: "cache", Workload: "redis"},
+			{Repository: "docker.io/bitnami/redis", Tag: "7.2.4-debian-12-r9", Namespace: "cache", Workload: "redis-ha"},
+			{Repository: "postgres", Tag: "13-alpine", Namespace: "db", Workload: "postgres"},
+			{Repository: "redis", Tag: "latest", Namespace: "cache", Workload: "scratch"},
+			{Repository: "registry.example.com/team/api", Tag: "1.4.2", Namespace: "api", Workload: "api"},
+		},
+	}, ctx, con, 60, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if id != UUIDFromClusterName("minikube") {
+		t.Errorf("expected the inventory to share the cluster's ID, got %s", id)
+	}
+
+	stored, _, err := c.RetrieveWithETag(id, ctx, con)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stored.OwnerKind != OwnerKindCluster {
+		t.Errorf("expected owner kind %q, got %q", OwnerKindCluster, stored.OwnerKind)
+	}
+	expected := []struct {
+		product, cycle, eol string
+		expired             bool
+	}{
+		{"redis", "6.2", "2024-02-28", true},
+		{"redis", "7.2", "2099-02-28", false},
+		{"postgresql", "13", "2025-11-13", true},
+		{"", "", "", false},
+		{"", "", "", false},
+	}
+	for i, want := range expected {
+		image := stored.Images[i]
+		if image.EOLProduct != want.product || image.CurrentVersion != want.cycle || image.CurrentVersionEoF != want.eol || image.Expired != want.expired {
+			t.Errorf("%s:%s: expected %s/%s/%s/%t, got %s/%s/%s/%t", image.Repository, image.Tag,
+				want.product, want.cycle, want.eol, want.expired,
+				image.EOLProduct, image.CurrentVersion, image.CurrentVersionEoF, image.Expired)
+		}
+	}
+}
+
+func TestContainerImagesScan_IsSeparateFromClusters(t *testing.T) {
+	ctx := context.Background()
+	con := newTestClient(t)
+	c := &ContainerImageInventories{Items: make(map[uuid.UUID]ContainerImages)}
+
+	hostID, _, err := c.InsertIfMatch(ContainerImages{
+		DataCenter: "dc1",
+		HostIP:     "10.0.0.1",
+		Images:     []ContainerImage{{Repository: "nginx", Tag: "1.25", Container: "web"}},
+	}, ctx, con, 60, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if hostID != UUIDFromDcAndIPPackage("dc1", "10.0.0.1") {
+		t.Errorf("expected the inventory to share the host's ID, got %s", hostID)
+	}
+
+	inventories, err := c.Scan(ctx, con)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(inventories.Items) != 1 || inventories.Items[hostID].OwnerKind != OwnerKindHost {
+		t.Errorf("expected the host inventory, got %+v", inventories.Items)
+	}
+
+	clusters, err := (&KubernetesClusters{}).ScanClusters(ctx, con)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(clusters.Items) != 0 {
+		t.Errorf("expected no clusters, got %+v", clusters.Items)
+	}
+}
+
+func TestContainerImagesInsert_JoinsConfiguredHostID(t *testing.T) {
+	ctx := context.Background()
+	con := newTestClient(t)
+	fields := []string{"data_center", "hostname"}
+	c := &ContainerImageInventories{Items: make(map[uuid.UUID]ContainerImages), HostIdentity: fields}
+
+	images := ContainerImages{
+		DataCenter: "dc1",
+		HostIP:     "10.0.0.1",
+		Hostname:   "web-1",
+		Images:     []ContainerImage{{Repository: "nginx", Tag: "1.25", Container: "web"}},
+	}
+	if violations := imageViolations(images, fields); len(violations) != 0 {
+		t.Fatalf("unexpected violations: %+v", violations)
+	}
+	id, _, err := c.InsertIfMatch(images, ctx, con, 60, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	hosts := &PackageVersionss{Identity: fields}
+	want := hosts.HostID(PackageVersions{DataCenterPkg: "dc1", HostIPPkg: "10.0.0.99", Hostname: "web-1"})
+	if id != want {
+		t.Errorf("expected the inventory to share the host record's ID %s, got %s", want, id)
+	}
+
+	images.Hostname = ""
+	violations := imageViolations(images, fields)
+	if len(violations) != 1 || violations[0].Field != "hostname" {
+		t.Errorf("expected a violation for the missing hostname, got %+v", violations)
+	}
+}
+
+func TestImageViolations(t *testing.T) {
+	cases := []struct {
+		images ContainerImages
+		fields []string
+	}{
+		{ContainerImages{ClusterName: "minikube"}, nil},
+		{ContainerImages{DataCenter: "dc1", HostIP: "10.0.0.1"}, nil},
+		{ContainerImages{DataCenter: "dc1"}, []string{"cluster_name"}},
+		{ContainerImages{ClusterName: "minikube", DataCenter: "dc1", HostIP: "10.0.0.1"}, []string{"cluster_name"}},
+		{ContainerImages{ClusterName: "minikube", Images: []ContainerImage{
+			{Repository: "redis", Tag: "7.2", Namespace: "cache", Workload: "redis"},
+			{Repository: "redis", Tag: "7.2", Namespace: "cache", Workload: "redis-ha"},
+			{Repository: "redis", Tag: "7.2", Namespace: "cache", Workload: "redis"},
+		}}, []string{"images[2]"}},
+	}
+	for i, tc := range cases {
+		violations := imageViolations(tc.images, nil)
+		if len(violations) != len(tc.fields) {
+			t.Errorf("case %d: expected %v, got %+v", i, tc.fields, violations)
+			continue
+		}
+		for j, field := range tc.fields {
+			if violations[j].Field != field {
+				t.Errorf("case %d: expected violation at %s, got %s", i, field, violations[j].Field)
+			}
+		}
+	}
+}
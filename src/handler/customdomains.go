@@ -0,0 +1,282 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// Operators may declare further inventory domains in CUSTOM_DOMAINS without
+// writing code. A custom domain stores any JSON object pushed to its path,
+// identified by a few of its top-level fields, and exports one info metric
+// series per entry of its item list, with the configured fields as labels.
+
+const (
+	customUUIDSuffix      = "CUSTOM_UUID"
+	customDomainKeyPrefix = "keepup:custom:"
+)
+
+var (
+	customDomainName = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+	customLabelName  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// reservedPaths are served by keepup itself. The API routes are also mounted
+// under /api/v1, which custom domains may not use at all.
+var reservedPaths = map[string]bool{
+	"/package-version":          true,
+	"/package-versions/batch":   true,
+	"/sbom":                     true,
+	"/helm-cluster":             true,
+	"/container-images":         true,
+	"/application-dependencies": true,
+	"/certificates":             true,
+	"/missing-entities":         true,
+	"/metrics":                  true,
+	"/healthcheck":              true,
+	"/openapi.json":             true,
+}
+
+const reservedPathPrefix = "/api/v1"
+
+// CustomDomain is a domain declared in CUSTOM_DOMAINS.
+type CustomDomain struct {
+	Name     string   `json:"name"`     // exported as <name>_info
+	Path     string   `json:"path"`     // e.g. /dns-records
+	Identity []string `json:"identity"` // top-level fields the ID is derived from
+	Items    string   `json:"items"`    // top-level list with one object per metric series
+	// Labels maps a metric label onto the field it is read from: a field of
+	// the item, or else a top-level field.
+	Labels     map[string]string `json:"labels"`
+	TTLSeconds int               `json:"ttl_seconds"` // 0 for TTL_SECONDS
+
+	labelNames []string
+}
+
+// CustomRecord is a document pushed to a custom domain.
+type CustomRecord struct {
+	ID        uuid.UUID      `json:"id"`
+	Domain    string         `json:"domain"`
+	Document  map[string]any `json:"document"`
+	UpdatedAt string         `json:"updated_at"`
+}
+
+type CustomRecords struct {
+	Domain CustomDomain
+	Items  map[uuid.UUID]CustomRecord
+}
+
+var (
+	ErrInvalidDomain             = errors.New("Invalid custom domain")
+	ErrCustomRecordInsertFailed  = errors.New("Custom record insert failed")
+	ErrCustomRecordMarshalFailed = errors.New("Custom record marshal failed")
+	ErrCustomRecordNotFound      = errors.New("Custom record ID not found")
+	ErrCustomRecordDeleteFailed  = errors.New("Custom record delete failed")
+)
+
+// ParseCustomDomains decodes the CUSTOM_DOMAINS JSON array and checks that
+// every domain can be served and exported.
+func ParseCustomDomains(raw string) ([]CustomDomain, error) {
+	var domains []CustomDomain
+	if err := json.Unmarshal([]byte(raw), &domains); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDomain, err)
+	}
+
+	names := make(map[string]bool)
+	paths := make(map[string]bool)
+	for i := range domains {
+		d := &domains[i]
+		switch {
+		case !customDomainName.MatchString(d.Name):
+			return nil, fmt.Errorf("%w: domain %d: name %q must be lower case letters, digits and _", ErrInvalidDomain, i, d.Name)
+		case names[d.Name]:
+			return nil, fmt.Errorf("%w: domain %s is declared twice", ErrInvalidDomain, d.Name)
+		case !strings.HasPrefix(d.Path, "/") || strings.ContainsAny(d.Path, "?#") || strings.HasSuffix(d.Path, "/"):
+			return nil, fmt.Errorf("%w: domain %s: path %q must start and may not end with /", ErrInvalidDomain, d.Name, d.Path)
+		case reservedPaths[d.Path] || d.Path == reservedPathPrefix || strings.HasPrefix(d.Path, reservedPathPrefix+"/"):
+			return nil, fmt.Errorf("%w: domain %s: path %s is reserved", ErrInvalidDomain, d.Name, d.Path)
+		case paths[d.Path]:
+			return nil, fmt.Errorf("%w: domain %s: path %s is taken by another domain", ErrInvalidDomain, d.Name, d.Path)
+		case len(d.Identity) == 0:
+			return nil, fmt.Errorf("%w: domain %s has no identity fields", ErrInvalidDomain, d.Name)
+		case d.Items == "":
+			return nil, fmt.Errorf("%w: domain %s has no items field", ErrInvalidDomain, d.Name)
+		case d.TTLSeconds < 0:
+			return nil, fmt.Errorf("%w: domain %s: ttl_seconds can't be negative", ErrInvalidDomain, d.Name)
+		}
+		names[d.Name] = true
+		paths[d.Path] = true
+
+		labels := map[string]bool{"id": true}
+		for _, field := range d.Identity {
+			if !customLabelName.MatchString(field) || labels[field] {
+				return nil, fmt.Errorf("%w: domain %s: identity field %q is not a unique label name", ErrInvalidDomain, d.Name, field)
+			}
+			labels[field] = true
+		}
+		for label, field := range d.Labels {
+			if !customLabelName.MatchString(label) || labels[label] {
+				return nil, fmt.Errorf("%w: domain %s: label %q is not a unique label name", ErrInvalidDomain, d.Name, label)
+			}
+			if field == "" {
+				return nil, fmt.Errorf("%w: domain %s: label %s names no field", ErrInvalidDomain, d.Name, label)
+			}
+			labels[label] = true
+			d.labelNames = append(d.labelNames, label)
+		}
+		sort.Strings(d.labelNames)
+	}
+	return domains, nil
+}
+
+// LabelNames returns the configured metric labels, sorted.
+func (d CustomDomain) LabelNames() []string {
+	return d.labelNames
+}
+
+// UUIDFromCustomIdentity returns the ID of the record of domain whose
+// identity fields have values.
+func UUIDFromCustomIdentity(domain string, values []string) uuid.UUID {
+	// "|" is not label-safe, so the values can't run into each other.
+	return uuid.NewSHA1(uuid.NameSpaceDNS, []byte(fmt.Sprintf("%s|%s|%s", domain, strings.Join(values, "|"), customUUIDSuffix)))
+}
+
+// repository stores the records of the domain under a prefix of their own.
+func (d CustomDomain) repository() Repository[CustomRecord] {
+	return Repository[CustomRecord]{
+		Name:   d.Name + " records",
+		Prefix: customDomainKeyPrefix + d.Name + ":",
+		TTL:    d.TTLSeconds,
+		Identity: func(record CustomRecord) uuid.UUID {
+			return UUIDFromCustomIdentity(d.Name, d.IdentityValues(record.Document))
+		},
+		Errors: RepositoryErrors{
+			NotFound:      ErrCustomRecordNotFound,
+			InsertFailed:  ErrCustomRecordInsertFailed,
+			MarshalFailed: ErrCustomRecordMarshalFailed,
+			DeleteFailed:  ErrCustomRecordDeleteFailed,
+		},
+	}
+}
+
+// IdentityValues returns the values of the identity fields of doc.
+func (d CustomDomain) IdentityValues(doc map[string]any) []string {
+	values := make([]string, len(d.Identity))
+	for i, field := range d.Identity {
+		values[i], _ = customScalar(doc[field])
+	}
+	return values
+}
+
+// ItemList returns the objects in the item list of doc.
+func (d CustomDomain) ItemList(doc map[string]any) []map[string]any {
+	list, _ := doc[d.Items].([]any)
+	items := make([]map[string]any, 0, len(list))
+	for _, entry := range list {
+		if item, ok := entry.(map[string]any); ok {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// LabelValue returns the value of the field label is read from, in item or
+// else in doc; "" when it is missing or not a scalar.
+func (d CustomDomain) LabelValue(doc map[string]any, item map[string]any, label string) string {
+	field := d.Labels[label]
+	if value, ok := item[field]; ok {
+		str, _ := customScalar(value)
+		return str
+	}
+	str, _ := customScalar(doc[field])
+	return str
+}
+
+// customScalar formats a JSON string, number or boolean.
+func customScalar(value any) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+	return "", false
+}
+
+// violations reports missing or malformed identity fields and an item list
+// that isn't a list of objects.
+func (d CustomDomain) violations(doc map[string]any) []Violation {
+	var violations []Violation
+	for _, field := range d.Identity {
+		value, ok := customScalar(doc[field])
+		if doc[field] != nil && !ok {
+			violations = append(violations, Violation{Field: field, Rule: "type", Message: "must be a string, number or boolean"})
+			continue
+		}
+		checkRules(reflect.ValueOf(value), field, "required,max=253,label", &violations)
+	}
+
+	switch list := doc[d.Items].(type) {
+	case nil:
+	case []any:
+		for i, entry := range list {
+			if _, ok := entry.(map[string]any); !ok {
+				violations = append(violations, Violation{Field: fmt.Sprintf("%s[%d]", d.Items, i), Rule: "type", Message: "must be an object"})
+			}
+		}
+	default:
+		violations = append(violations, Violation{Field: d.Items, Rule: "type", Message: "must be a list of objects"})
+	}
+	return violations
+}
+
+// InsertIfMatch stores doc, guarded by an If-Match header value ("" for an
+// unconditional write). It returns the ID and ETag of the stored record.
+func (c *CustomRecords) InsertIfMatch(doc map[string]any, ctx context.Context, con *redis.Client, ttl int, ifMatch string) (uuid.UUID, string, error) {
+	record := CustomRecord{
+		ID:        UUIDFromCustomIdentity(c.Domain.Name, c.Domain.IdentityValues(doc)),
+		Domain:    c.Domain.Name,
+		Document:  doc,
+		UpdatedAt: fmt.Sprint(time.Now().Unix()),
+	}
+
+	id, etag, err := c.Domain.repository().Put(record, ctx, con, ttl, ifMatch)
+	if err != nil {
+		return id, "", err
+	}
+
+	log.Printf("%s record %s stored with ID: %s", c.Domain.Name, strings.Join(c.Domain.IdentityValues(doc), "/"), id)
+	return id, etag, nil
+}
+
+func (c *CustomRecords) RetrieveWithETag(id uuid.UUID, ctx context.Context, con *redis.Client) (CustomRecord, string, error) {
+	return c.Domain.repository().Get(id, ctx, con)
+}
+
+// Delete removes a record, guarded by an If-Match header value ("" for an
+// unconditional delete).
+func (c *CustomRecords) Delete(id uuid.UUID, ctx context.Context, con *redis.Client, ifMatch string) error {
+	if err := c.Domain.repository().Delete(id, ctx, con, ifMatch); err != nil {
+		return err
+	}
+	log.Printf("%s record %s deleted", c.Domain.Name, id)
+	return nil
+}
+
+func (c *CustomRecords) Scan(ctx context.Context, con *redis.Client) (CustomRecords, error) {
+	items, err := c.Domain.repository().Scan(ctx, con)
+	return CustomRecords{Domain: c.Domain, Items: items}, err
+}
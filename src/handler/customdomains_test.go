@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+const testCustomDomains = `[{
+	"name": "dns_record",
+	"path": "/dns-records",
+	"identity": ["zone"],
+	"items": "records",
+	"labels": {"type": "type", "record": "name", "provider": "provider"}
+}]`
+
+func TestParseCustomDomains(t *testing.T) {
+	domains, err := ParseCustomDomains(testCustomDomains)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(domains) != 1 || strings.Join(domains[0].LabelNames(), ",") != "provider,record,type" {
+		t.Errorf("expected the labels sorted, got %+v", domains)
+	}
+
+	invalid := []string{
+		`{}`,
+		`[{"name": "DNS", "path": "/dns", "identity": ["zone"], "items": "records"}]`,
+		`[{"name": "dns", "path": "dns", "identity": ["zone"], "items": "records"}]`,
+		`[{"name": "dns", "path": "/dns", "identity": [], "items": "records"}]`,
+		`[{"name": "dns", "path": "/dns", "identity": ["zone"]}]`,
+		`[{"name": "dns", "path": "/dns", "identity": ["id"], "items": "records"}]`,
+		`[{"name": "dns", "path": "/dns", "identity": ["zone"], "items": "records", "labels": {"zone": "zone"}}]`,
+		`[{"name": "dns", "path": "/dns", "identity": ["zone"], "items": "records"},
+		  {"name": "dns2", "path": "/dns", "identity": ["zone"], "items": "records"}]`,
+		`[{"name": "dns", "path": "/certificates", "identity": ["zone"], "items": "records"}]`,
+		`[{"name": "dns", "path": "/metrics", "identity": ["zone"], "items": "records"}]`,
+		`[{"name": "dns", "path": "/api/v1/dns", "identity": ["zone"], "items": "records"}]`,
+	}
+	for _, raw := range invalid {
+		if _, err := ParseCustomDomains(raw); !errors.Is(err, ErrInvalidDomain) {
+			t.Errorf("%s: expected ErrInvalidDomain, got %v", raw, err)
+		}
+	}
+}
+
+func TestUUIDFromCustomIdentity_ValuesDontRunTogether(t *testing.T) {
+	if UUIDFromCustomIdentity("dns", []string{"a-b", "c"}) == UUIDFromCustomIdentity("dns", []string{"a", "b-c"}) {
+		t.Error("expected distinct IDs for distinct identity values")
+	}
+}
+
+func TestHandleInsertCustomRecord(t *testing.T) {
+	ctx := context.Background()
+	con := newTestClient(t)
+	domains, err := ParseCustomDomains(testCustomDomains)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s := &CustomDomainMiddleware{
+		Records:  &CustomRecords{Domain: domains[0], Items: make(map[uuid.UUID]CustomRecord)},
+		Client:   con,
+		Context:  ctx,
+		ApiToken: "secret",
+		TTL:      300,
+	}
+	put := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/dns-records", strings.NewReader(body))
+		req.Header.Set("x-api-token", "secret")
+		rec := httptest.NewRecorder()
+		s.Handler()(rec, req)
+		return rec
+	}
+
+	rec := put(`{"zone": "example.com", "provider": "route53", "records": [
+		{"name": "www", "type": "CNAME", "ttl": 300},
+		{"name": "example.com", "type": "A", "provider": "cloudflare"}
+	]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	id := UUIDFromCustomIdentity("dns_record", []string{"example.com"})
+	record, _, err := s.Records.RetrieveWithETag(id, ctx, con)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	items := s.Records.Domain.ItemList(record.Document)
+	if len(items) != 2 {
+		t.Fatalf("expected 2 items, got %+v", record.Document)
+	}
+	if provider := s.Records.Domain.LabelValue(record.Document, items[0], "provider"); provider != "route53" {
+		t.Errorf("expected the top-level provider, got %q", provider)
+	}
+	if provider := s.Records.Domain.LabelValue(record.Document, items[1], "provider"); provider != "cloudflare" {
+		t.Errorf("expected the item's provider, got %q", provider)
+	}
+
+	rec = put(`{"zone": ["example.com"], "records": [{"name": "www"}, "mx"]}`)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected status 422, got %d", rec.Code)
+	}
+	for _, field := range []string{`"zone"`, `"records[1]"`} {
+		if !strings.Contains(rec.Body.String(), field) {
+			t.Errorf("expected a violation at %s, got %s", field, rec.Body.String())
+		}
+	}
+
+	if rec := put(`[]`); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for a non-object body, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,170 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Records carry a strong ETag derived from their stored JSON. GET honours
+// If-None-Match with 304; PUT, PATCH and DELETE honour If-Match by comparing and
+// writing inside a Redis WATCH transaction, so that a concurrent writer
+// makes the request fail with 412 instead of being overwritten. A write
+// without If-Match that keeps losing such races fails with 409 and may
+// simply be retried.
+
+var (
+	ErrPreconditionFailed = errors.New("Precondition failed")
+	ErrWriteConflict      = errors.New("Write conflict")
+)
+
+func ETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches reports whether etag is listed in an If-Match or If-None-Match
+// header value. A nil etag stands for a missing record, which only fails to
+// match. Weak validators compare by their opaque part.
+func etagMatches(header string, etag *string) bool {
+	if etag == nil {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == *etag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified answers 304 when the client's If-None-Match already names etag.
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	if header := r.Header.Get("If-None-Match"); header != "" && etagMatches(header, &etag) {
+		w.Header().Del("Content-Type")
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// storeIfMatch writes data under key. With a non-empty ifMatch the current
+// record must carry one of the listed ETags, checked atomically with the
+// write; otherwise ErrPreconditionFailed is returned.
+func storeIfMatch(ctx context.Context, con *redis.Client, key string, ifMatch string, data []byte, ttl time.Duration) error {
+	if ifMatch == "" {
+		return con.Set(ctx, key, data, ttl).Err()
+	}
+	err := withPrecondition(ctx, con, key, ifMatch, func(pipe redis.Pipeliner, _ []byte) error {
+		return pipe.Set(ctx, key, data, ttl).Err()
+	})
+	if err == errConflict {
+		return ErrPreconditionFailed
+	}
+	return err
+}
+
+// deleteIfMatch removes key, honouring ifMatch like storeIfMatch. It returns
+// redis.Nil when there is nothing to delete.
+func deleteIfMatch(ctx context.Context, con *redis.Client, key string, ifMatch string) error {
+	if ifMatch == "" {
+		deleted, err := con.Del(ctx, key).Result()
+		if err == nil && deleted == 0 {
+			return redis.Nil
+		}
+		return err
+	}
+	err := withPrecondition(ctx, con, key, ifMatch, func(pipe redis.Pipeliner, _ []byte) error {
+		return pipe.Del(ctx, key).Err()
+	})
+	if err == errConflict {
+		return ErrPreconditionFailed
+	}
+	return err
+}
+
+const updateRetries = 3
+
+// updateIfMatch rewrites the record under key with the result of update,
+// which receives the stored JSON, and returns the new ETag. missing is
+// returned when there is no record; with a nil missing, update is called
+// with nil to create it. A concurrent write fails the request with
+// ErrPreconditionFailed when ifMatch is set and is otherwise retried a few
+// times before failing with ErrWriteConflict.
+func updateIfMatch(
+	ctx context.Context,
+	con *redis.Client,
+	key string,
+	ifMatch string,
+	ttl time.Duration,
+	missing error,
+	update func([]byte) ([]byte, error),
+) (string, error) {
+	var etag string
+	write := func(pipe redis.Pipeliner, current []byte) error {
+		if current == nil && missing != nil {
+			return missing
+		}
+		data, err := update(current)
+		if err != nil {
+			return err
+		}
+		etag = ETag(data)
+		return pipe.Set(ctx, key, data, ttl).Err()
+	}
+	for attempt := 1; ; attempt++ {
+		err := withPrecondition(ctx, con, key, ifMatch, write)
+		if err != errConflict {
+			return etag, err
+		}
+		if ifMatch != "" {
+			return "", ErrPreconditionFailed
+		}
+		if attempt == updateRetries {
+			return "", ErrWriteConflict
+		}
+	}
+}
+
+// errConflict reports that the watched key changed before the transaction
+// was executed.
+var errConflict = errors.New("Concurrent modification")
+
+// withPrecondition runs write in a transaction that only commits when the
+// record under key is unchanged since it was read and, with a non-empty
+// ifMatch, carries one of the listed ETags. write receives the stored JSON,
+// or nil when there is none.
+func withPrecondition(ctx context.Context, con *redis.Client, key string, ifMatch string, write func(redis.Pipeliner, []byte) error) error {
+	err := con.Watch(ctx, func(tx *redis.Tx) error {
+		var current *string
+		data, err := tx.Get(ctx, key).Bytes()
+		switch {
+		case err == nil:
+			etag := ETag(data)
+			current = &etag
+		case err == redis.Nil:
+			data = nil
+		default:
+			return err
+		}
+		if ifMatch != "" && !etagMatches(ifMatch, current) {
+			return ErrPreconditionFailed
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			return write(pipe, data)
+		})
+		return err
+	}, key)
+	if err == redis.TxFailedErr {
+		return errConflict
+	}
+	return err
+}
@@ -0,0 +1,187 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestEtagMatches(t *testing.T) {
+	etag := `"abc"`
+	cases := []struct {
+		header string
+		etag   *string
+		want   bool
+	}{
+		{`"abc"`, &etag, true},
+		{`"xyz", "abc"`, &etag, true},
+		{`W/"abc"`, &etag, true},
+		{`*`, &etag, true},
+		{`"xyz"`, &etag, false},
+		{`*`, nil, false},
+		{`"abc"`, nil, false},
+	}
+	for _, c := range cases {
+		if got := etagMatches(c.header, c.etag); got != c.want {
+			t.Errorf("etagMatches(%q, %v) = %v, want %v", c.header, c.etag, got, c.want)
+		}
+	}
+}
+
+func TestHandleGetPackages_HonoursIfNoneMatch(t *testing.T) {
+	ctx := context.Background()
+	con := newTestClient(t)
+	seedEOLCache(t, con, `{"redis":[{"cycle":"7.2","eol":false,"latest":"7.2.4"}]}`)
+	p := &PackageVersionsHandler{
+		PackageVersions: &PackageVersionss{Items: make(map[uuid.UUID]PackageVersions)},
+		Client:          con,
+		Context:         ctx,
+		ApiToken:        "secret",
+		TTL:             300,
+	}
+	send := func(method, url, body string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("x-api-token", "secret")
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		rec := httptest.NewRecorder()
+		p.Handler()(rec, req)
+		return rec
+	}
+
+	put := send(http.MethodPut, "/package-version", `{"packages":{"redis":"7.2.1","data_center":"dc1","host_ip":"10.0.0.1"}}`, nil)
+	etag := put.Header().Get("ETag")
+	if put.Code != http.StatusOK || etag == "" {
+		t.Fatalf("expected 200 with an ETag, got %d %q: %s", put.Code, etag, put.Body.String())
+	}
+
+	url := "/package-version?id=" + UUIDFromDcAndIPPackage("dc1", "10.0.0.1").String()
+	if rec := send(http.MethodGet, url, "", nil); rec.Code != http.StatusOK || rec.Header().Get("ETag") != etag {
+		t.Fatalf("expected 200 with ETag %s, got %d %q", etag, rec.Code, rec.Header().Get("ETag"))
+	}
+	rec := send(http.MethodGet, url, "", map[string]string{"If-None-Match": etag})
+	if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("expected an empty 304, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := send(http.MethodGet, url, "", map[string]string{"If-None-Match": `"stale"`}); rec.Code != http.StatusOK {
+		t.Errorf("expected 200 for a stale ETag, got %d", rec.Code)
+	}
+}
+
+func TestClusterHandler_IfMatchPreventsLostUpdates(t *testing.T) {
+	s := newTestClusterMiddleware(t, BodyLimits{})
+	send := func(method, body, ifMatch string) *httptest.ResponseRecorder {
+		url := "/helm-cluster?id=" + UUIDFromClusterName("minikube").String()
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("x-api-token", "secret")
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		rec := httptest.NewRecorder()
+		s.Handler()(rec, req)
+		return rec
+	}
+	payload := func(version string) string {
+		return `{"cluster_name":"minikube","kube_version":"` + version + `"}`
+	}
+
+	if rec := send(http.MethodPut, payload("1.29"), `"missing"`); rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412 for If-Match on a missing record, got %d", rec.Code)
+	}
+	first := send(http.MethodPut, payload("1.29"), "").Header().Get("ETag")
+
+	// Both collectors read the first version; only the first write wins.
+	second := send(http.MethodPut, payload("1.30"), first)
+	if second.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", second.Code, second.Body.String())
+	}
+	rec := send(http.MethodPut, payload("1.31"), first)
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412 for a stale ETag, got %d", rec.Code)
+	}
+	if problem := decodeProblem(t, rec); problem.Code != CodePreconditionFailed {
+		t.Errorf("expected code %s, got %s", CodePreconditionFailed, problem.Code)
+	}
+	stored, _ := s.Clusters.RetrieveCluster(UUIDFromClusterName("minikube"), s.Context, s.Client)
+	if stored.KubeVersion != "1.30" {
+		t.Errorf("expected the stale write to be rejected, stored version is %s", stored.KubeVersion)
+	}
+
+	if rec := send(http.MethodDelete, "", first); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected 412 when deleting with a stale ETag, got %d", rec.Code)
+	}
+	if rec := send(http.MethodDelete, "", second.Header().Get("ETag")); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := send(http.MethodGet, "", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 after delete, got %d", rec.Code)
+	}
+	if stale, _ := StaleEntities(s.Context, s.Client, EntityKindCluster, -1); len(stale) != 0 {
+		t.Errorf("expected the last seen entry to be removed, got %+v", stale)
+	}
+}
+
+func TestUpdateIfMatch_UnconditionalWriteThatKeepsLosingConflicts(t *testing.T) {
+	ctx := context.Background()
+	con := newTestClient(t)
+	const key = "keepup:test:contended"
+	con.Set(ctx, key, "0", 0)
+
+	attempts := 0
+	update := func(current []byte) ([]byte, error) {
+		attempts++
+		// Another writer gets in between every read and write.
+		con.Set(ctx, key, fmt.Sprint(attempts), 0)
+		return []byte("mine"), nil
+	}
+	if _, err := updateIfMatch(ctx, con, key, "", time.Minute, nil, update); err != ErrWriteConflict {
+		t.Fatalf("expected ErrWriteConflict, got %v", err)
+	}
+	if attempts != updateRetries {
+		t.Errorf("expected %d attempts, got %d", updateRetries, attempts)
+	}
+
+	attempts = 0
+	current := ETag([]byte(con.Get(ctx, key).Val()))
+	if _, err := updateIfMatch(ctx, con, key, current, time.Minute, nil, update); err != ErrPreconditionFailed {
+		t.Errorf("expected ErrPreconditionFailed with If-Match, got %v", err)
+	}
+	if attempts != 1 {
+		t.Errorf("expected a conditional write not to be retried, got %d attempts", attempts)
+	}
+}
+
+func TestPackageHandler_DoesNotReachClusterRecords(t *testing.T) {
+	s := newTestClusterMiddleware(t, BodyLimits{})
+	p := &PackageVersionsHandler{
+		PackageVersions: &PackageVersionss{Items: make(map[uuid.UUID]PackageVersions)},
+		Client:          s.Client,
+		Context:         s.Context,
+		ApiToken:        "secret",
+		TTL:             300,
+	}
+	if rec := putCluster(s, "", []byte(`{"cluster_name":"minikube","kube_version":"1.30"}`)); rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	url := "/package-version?id=" + UUIDFromClusterName("minikube").String()
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		req := httptest.NewRequest(method, url, nil)
+		req.Header.Set("x-api-token", "secret")
+		rec := httptest.NewRecorder()
+		p.Handler()(rec, req)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%s: expected 404 for a cluster ID, got %d", method, rec.Code)
+		}
+	}
+	if _, err := s.Clusters.RetrieveCluster(UUIDFromClusterName("minikube"), s.Context, s.Client); err != nil {
+		t.Errorf("expected the cluster to be kept, got %v", err)
+	}
+}
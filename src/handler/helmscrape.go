@@ -6,6 +6,8 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -13,117 +15,328 @@ import (
 )
 
 type KubernetesCluster struct {
-	ID          uuid.UUID       `json:"id"`
-	ClusterName string          `json:"cluster_name"` // Default value from scraper: minikube
-	KubeVersion string          `json:"kube_version"`
-	Team        string          `json:"team"`
-	HelmCharts  []HelmChartData `json:"helm_charts"`
-	UpdatedAt   string          `json:"updated_at"`
+	ID          uuid.UUID         `json:"id"`
+	ClusterName string            `json:"cluster_name" validate:"required,max=253,label"` // Default value from scraper: minikube
+	Project     string            `json:"project,omitempty" validate:"max=64,label"`
+	Environment string            `json:"environment,omitempty" validate:"max=64,label"`
+	Region      string            `json:"region,omitempty" validate:"max=64,label"`
+	Provider    string            `json:"provider,omitempty" validate:"max=64,label"`
+	KubeVersion string            `json:"kube_version" validate:"required,max=64,label"`
+	Team        string            `json:"team" validate:"max=64,label"`
+	Labels      map[string]string `json:"labels,omitempty" validate_keys:"max=64"`
+	HelmCharts  []HelmChartData   `json:"helm_charts"`
+	UpdatedAt   string            `json:"updated_at"`
+
+	// Set by keepup from endoflife.date, see enrichKubeVersion.
+	KubeEOLProduct    string `json:"kube_eol_product,omitempty"`
+	KubeVersionEoF    string `json:"kube_version_eol,omitempty"`
+	KubeLatestVersion string `json:"kube_latest_version,omitempty"`
+	KubeExpired       bool   `json:"kube_expired,omitempty"`
 }
 
+// HelmChartData is one Helm release. Releases are told apart by namespace
+// and release name; agents that don't send a release name identify them by
+// chart name instead, see Key.
 type HelmChartData struct {
-	ChartName string `json:"chart_name"`
-	Version   string `json:"version"`
-	Namespace string `json:"namespace"`
+	ChartName      string `json:"chart_name" validate:"required,max=253,label"`
+	Version        string `json:"version" validate:"required,max=64,label"`
+	Namespace      string `json:"namespace" validate:"required,max=63,label"`
+	ReleaseName    string `json:"release_name,omitempty" validate:"max=53,label"`
+	Status         string `json:"status,omitempty" validate:"oneof=unknown|deployed|uninstalled|superseded|failed|uninstalling|pending-install|pending-upgrade|pending-rollback"`
+	Revision       int    `json:"revision,omitempty"`
+	AppVersion     string `json:"app_version,omitempty" validate:"max=64,label"`
+	LastDeployed   string `json:"last_deployed,omitempty" validate:"rfc3339"`
+	Repository     string `json:"repository,omitempty" validate:"max=2048,url"`
+	LatestVersion  string `json:"latest_version,omitempty"`
+	Outdated       bool   `json:"outdated,omitempty"`
+	VersionsBehind int    `json:"versions_behind,omitempty"`
+}
+
+// ClusterPatch is a merge patch for an existing cluster record, which is
+// identified like a PUT by its name, project, environment, region and
+// provider. Charts are keyed by "<namespace>/<release_name>", or by chart
+// name for releases without one; a chart or label mapped to null is
+// removed, any other is added or updated.
+type ClusterPatch struct {
+	ClusterName string                     `json:"cluster_name" validate:"required,max=253,label"`
+	Project     string                     `json:"project,omitempty" validate:"max=64,label"`
+	Environment string                     `json:"environment,omitempty" validate:"max=64,label"`
+	Region      string                     `json:"region,omitempty" validate:"max=64,label"`
+	Provider    string                     `json:"provider,omitempty" validate:"max=64,label"`
+	KubeVersion *string                    `json:"kube_version" validate:"max=64,label"`
+	Team        *string                    `json:"team" validate:"max=64,label"`
+	Labels      map[string]*string         `json:"labels,omitempty" validate_keys:"max=64"`
+	HelmCharts  map[string]*HelmChartPatch `json:"helm_charts" validate_keys:"max=317,label"`
+}
+
+// HelmChartPatch updates a release. Its fields other than the version are
+// only changed when set; ChartName is needed to add a release keyed by its
+// release name.
+type HelmChartPatch struct {
+	Version      string `json:"version" validate:"required,max=64,label"`
+	ChartName    string `json:"chart_name,omitempty" validate:"max=253,label"`
+	Status       string `json:"status,omitempty" validate:"oneof=unknown|deployed|uninstalled|superseded|failed|uninstalling|pending-install|pending-upgrade|pending-rollback"`
+	Revision     int    `json:"revision,omitempty"`
+	AppVersion   string `json:"app_version,omitempty" validate:"max=64,label"`
+	LastDeployed string `json:"last_deployed,omitempty" validate:"rfc3339"`
+	Repository   string `json:"repository,omitempty" validate:"max=2048,url"`
 }
 
 type KubernetesClusters struct {
 	Items map[uuid.UUID]KubernetesCluster
+	// ChartVersions looks up the versions of a chart, newest first; charts
+	// are not enriched when it is nil. See ChartRepositories.Query.
+	ChartVersions func(ctx context.Context, con *redis.Client, repository string, chart string) ([]string, error)
+	// EOLEntries looks up the release cycles of an endoflife.date product;
+	// the Kubernetes version is not enriched when it is nil. See
+	// QueryEOLEntries.
+	EOLEntries func(ctx context.Context, con *redis.Client, product string) ([]EndOfLifeEntry, error)
 }
 
+const clusterKeyPrefix = "keepup:cluster:"
+
 var (
 	ErrClusterInsertFailed  = errors.New("Cluster insert failed")
 	ErrClusterMarshalFailed = errors.New("Cluster marshal failed")
 	ErrClusterNotFound      = errors.New("Cluster ID not found")
+	ErrClusterDeleteFailed  = errors.New("Cluster delete failed")
 )
 
+// clusterRepository stores cluster records.
+var clusterRepository = Repository[KubernetesCluster]{
+	Name:     "clusters",
+	Prefix:   clusterKeyPrefix,
+	Identity: UUIDFromCluster,
+	Errors: RepositoryErrors{
+		NotFound:      ErrClusterNotFound,
+		InsertFailed:  ErrClusterInsertFailed,
+		MarshalFailed: ErrClusterMarshalFailed,
+		DeleteFailed:  ErrClusterDeleteFailed,
+	},
+}
+
 func (c *KubernetesClusters) InsertClusterData(cluster KubernetesCluster, ctx context.Context, con *redis.Client, ttl int) (uuid.UUID, error) {
+	id, _, err := c.InsertClusterDataIfMatch(cluster, ctx, con, ttl, "")
+	return id, err
+}
+
+// InsertClusterDataIfMatch is InsertClusterData guarded by an If-Match
+// header value ("" for an unconditional write). It also returns the ETag of
+// the stored record.
+func (c *KubernetesClusters) InsertClusterDataIfMatch(cluster KubernetesCluster, ctx context.Context, con *redis.Client, ttl int, ifMatch string) (uuid.UUID, string, error) {
 
-	cluster.ID = UUIDFromClusterName(cluster.ClusterName)
+	cluster.ID = UUIDFromCluster(cluster)
 	cluster.UpdatedAt = fmt.Sprint(time.Now().Unix())
+	c.enrichKubeVersion(ctx, con, &cluster)
+	c.enrichCharts(ctx, con, cluster.HelmCharts)
 
-	data, err := json.Marshal(cluster)
+	id, etag, err := clusterRepository.Put(cluster, ctx, con, ttl, ifMatch)
 	if err != nil {
-		return cluster.ID, ErrClusterMarshalFailed
+		return id, "", err
 	}
 
-	_, err = con.Set(ctx, fmt.Sprint(cluster.ID), data, time.Duration(ttl)*time.Second).Result()
-	if err != nil {
-		return cluster.ID, ErrClusterInsertFailed
+	log.Printf("Cluster %s stored with ID: %s", cluster.ClusterName, id)
+	return id, etag, nil
+}
+
+func (c *KubernetesClusters) RetrieveCluster(id uuid.UUID, ctx context.Context, con *redis.Client) (KubernetesCluster, error) {
+	cluster, _, err := c.RetrieveClusterWithETag(id, ctx, con)
+	return cluster, err
+}
+
+func (c *KubernetesClusters) RetrieveClusterWithETag(id uuid.UUID, ctx context.Context, con *redis.Client) (KubernetesCluster, string, error) {
+	return clusterRepository.Get(id, ctx, con)
+}
+
+// DeleteCluster removes a cluster record and its last seen entry, guarded by
+// an If-Match header value ("" for an unconditional delete).
+func (c *KubernetesClusters) DeleteCluster(id uuid.UUID, ctx context.Context, con *redis.Client, ifMatch string) error {
+	if err := clusterRepository.Delete(id, ctx, con, ifMatch); err != nil {
+		return err
+	}
+	if err := ForgetLastSeen(ctx, con, EntityKindCluster, id); err != nil {
+		log.Printf("Can't remove last seen entry for %s: %v", id, err)
 	}
+	log.Printf("Cluster %s deleted", id)
+	return nil
+}
 
-	log.Printf("Cluster %s stored with ID: %s", cluster.ClusterName, cluster.ID)
-	return cluster.ID, nil
+func (c *KubernetesClusters) ScanClusters(ctx context.Context, con *redis.Client) (KubernetesClusters, error) {
+	items, err := clusterRepository.Scan(ctx, con)
+	return KubernetesClusters{Items: items}, err
 }
 
-func (c *KubernetesClusters) RetrieveCluster(id uuid.UUID, ctx context.Context, con *redis.Client) (KubernetesCluster, error) {
-	data, err := con.Get(ctx, fmt.Sprint(id)).Result()
+// PatchCluster applies patch to the stored cluster record, guarded by an
+// If-Match header value ("" for none). It returns the merged record and its
+// ETag.
+func (c *KubernetesClusters) PatchCluster(patch ClusterPatch, ctx context.Context, con *redis.Client, ttl int, ifMatch string) (KubernetesCluster, string, error) {
+	var cluster KubernetesCluster
+	id := UUIDFromCluster(KubernetesCluster{
+		ClusterName: patch.ClusterName,
+		Project:     patch.Project,
+		Environment: patch.Environment,
+		Region:      patch.Region,
+		Provider:    patch.Provider,
+	})
+
+	// The lookups may go over the network, so they are done before the
+	// transaction, against the record as it is now. Charts another writer
+	// adds in the meantime are kept as that writer enriched them.
+	stored, _, err := clusterRepository.Get(id, ctx, con)
 	if err != nil {
-		return KubernetesCluster{}, ErrClusterNotFound
+		return KubernetesCluster{}, "", err
 	}
+	kube := KubernetesCluster{Provider: stored.Provider}
+	if patch.KubeVersion != nil {
+		kube.KubeVersion = *patch.KubeVersion
+		c.enrichKubeVersion(ctx, con, &kube)
+	}
+	charts := c.chartVersions(ctx, con, mergeCharts(stored.HelmCharts, patch.HelmCharts))
 
-	var cluster KubernetesCluster
-	if err := json.Unmarshal([]byte(data), &cluster); err != nil {
-		return KubernetesCluster{}, ErrClusterMarshalFailed
+	etag, err := clusterRepository.Update(id, ctx, con, ttl, ifMatch, false, func(current []byte) ([]byte, error) {
+		cluster = KubernetesCluster{}
+		if err := json.Unmarshal(current, &cluster); err != nil {
+			return nil, ErrClusterMarshalFailed
+		}
+		if patch.KubeVersion != nil {
+			cluster.KubeVersion = kube.KubeVersion
+			cluster.KubeEOLProduct = kube.KubeEOLProduct
+			cluster.KubeVersionEoF = kube.KubeVersionEoF
+			cluster.KubeLatestVersion = kube.KubeLatestVersion
+			cluster.KubeExpired = kube.KubeExpired
+		}
+		if patch.Team != nil {
+			cluster.Team = *patch.Team
+		}
+		cluster.Labels = mergeLabels(cluster.Labels, patch.Labels)
+		cluster.HelmCharts = mergeCharts(cluster.HelmCharts, patch.HelmCharts)
+		applyChartVersions(cluster.HelmCharts, charts)
+		cluster.UpdatedAt = fmt.Sprint(time.Now().Unix())
+
+		data, err := json.Marshal(cluster)
+		if err != nil {
+			return nil, ErrClusterMarshalFailed
+		}
+		return data, nil
+	})
+
+	if err != nil {
+		return KubernetesCluster{}, "", err
 	}
-	return cluster, nil
+	log.Printf("Cluster %s patched: %d charts changed", patch.ClusterName, len(patch.HelmCharts))
+	return cluster, etag, nil
 }
 
-func (c *KubernetesClusters) ScanClusters(ctx context.Context, con *redis.Client) (KubernetesClusters, error) {
-	clusters := KubernetesClusters{
-		Items: make(map[uuid.UUID]KubernetesCluster),
+// mergeCharts applies chart patches to charts, keeping the existing order
+// and appending new charts sorted by key.
+func mergeCharts(charts []HelmChartData, patches map[string]*HelmChartPatch) []HelmChartData {
+	merged := make([]HelmChartData, 0, len(charts)+len(patches))
+	applied := make(map[string]bool)
+	for _, chart := range charts {
+		key := chart.Key()
+		patch, ok := patches[key]
+		applied[key] = true
+		switch {
+		case !ok:
+			merged = append(merged, chart)
+		case patch != nil:
+			merged = append(merged, patch.apply(chart))
+		}
 	}
 
-	var uids []uuid.UUID
-	var keys []string
-	iter := con.Scan(ctx, 0, "*", 0).Iterator()
-	for iter.Next(ctx) {
-		uid, err := uuid.Parse(iter.Val())
-		if err != nil {
-			if iter.Val() != "eol_cache:all_packages" {
-				log.Printf("Cannot parse UUID: %s, %v", iter.Val(), err)
-			}
-			continue
+	keys := make([]string, 0, len(patches))
+	for key, patch := range patches {
+		if patch != nil && !applied[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		namespace, name, _ := SplitChartKey(key)
+		chart := HelmChartData{ChartName: name, Namespace: namespace}
+		if patches[key].ChartName != "" {
+			chart.ChartName = patches[key].ChartName
+			chart.ReleaseName = name
 		}
-		uids = append(uids, uid)
-		keys = append(keys, iter.Val())
+		merged = append(merged, patches[key].apply(chart))
+	}
+	return merged
+}
+
+func (patch HelmChartPatch) apply(chart HelmChartData) HelmChartData {
+	chart.Version = patch.Version
+	if patch.Status != "" {
+		chart.Status = patch.Status
+	}
+	if patch.Revision != 0 {
+		chart.Revision = patch.Revision
 	}
-	if err := iter.Err(); err != nil {
-		log.Printf("Error scanning clusters: %v", err)
-		return clusters, err
+	if patch.AppVersion != "" {
+		chart.AppVersion = patch.AppVersion
 	}
-	if len(keys) == 0 {
-		return clusters, nil
+	if patch.LastDeployed != "" {
+		chart.LastDeployed = patch.LastDeployed
 	}
+	if patch.Repository != "" {
+		chart.Repository = patch.Repository
+	}
+	return chart
+}
 
-	values, err := con.MGet(ctx, keys...).Result()
-	if err != nil {
-		log.Printf("Error fetching clusters: %v", err)
-		return clusters, err
+// Key returns the key a release is patched by.
+func (chart HelmChartData) Key() string {
+	if chart.ReleaseName != "" {
+		return ChartKey(chart.Namespace, chart.ReleaseName)
 	}
-	for i, val := range values {
-		if val == nil {
-			// Key expired between SCAN and MGET.
-			continue
-		}
-		str, ok := val.(string)
-		if !ok {
-			log.Printf("Unexpected value type for key %s", keys[i])
-			continue
-		}
-		var cluster KubernetesCluster
-		if err := json.Unmarshal([]byte(str), &cluster); err != nil {
-			log.Printf("Can't unmarshal cluster %s: %v", keys[i], err)
-			continue
+	return ChartKey(chart.Namespace, chart.ChartName)
+}
+
+func ChartKey(namespace string, name string) string {
+	return namespace + "/" + name
+}
+
+// chartViolations reports releases listed more than once, which would
+// collapse into the same metric series.
+func chartViolations(charts []HelmChartData) []Violation {
+	var violations []Violation
+	seen := make(map[string]bool)
+	for i, chart := range charts {
+		if seen[chart.Key()] {
+			violations = append(violations, Violation{
+				Field:   fmt.Sprintf("helm_charts[%d].release_name", i),
+				Rule:    "duplicate",
+				Message: fmt.Sprintf("release %s is listed more than once", chart.Key()),
+			})
 		}
-		clusters.Items[uids[i]] = cluster
+		seen[chart.Key()] = true
 	}
+	return violations
+}
 
-	return clusters, nil
+func SplitChartKey(key string) (namespace string, chartName string, ok bool) {
+	namespace, chartName, ok = strings.Cut(key, "/")
+	return namespace, chartName, ok && namespace != "" && chartName != "" && !strings.Contains(chartName, "/")
 }
 
-// TODO
-// Add more uniqe values to identife entity. To prevent names overlaping for different projects.
 func UUIDFromClusterName(clusterName string) uuid.UUID {
 	return uuid.NewSHA1(uuid.NameSpaceDNS, []byte(clusterName))
 }
+
+// UUIDFromCluster returns the ID of a cluster. Project, environment, region
+// and provider tell apart clusters sharing a name, such as every team's
+// minikube; a cluster without them keeps the ID of UUIDFromClusterName.
+func UUIDFromCluster(cluster KubernetesCluster) uuid.UUID {
+	name := cluster.ClusterName
+	for _, part := range [][2]string{
+		{"project", cluster.Project},
+		{"environment", cluster.Environment},
+		{"region", cluster.Region},
+		{"provider", cluster.Provider},
+	} {
+		// "|" is not label-safe, so the parts can't run into each other.
+		if part[1] != "" {
+			name += "|" + part[0] + "=" + part[1]
+		}
+	}
+	return UUIDFromClusterName(name)
+}
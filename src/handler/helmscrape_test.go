@@ -2,9 +2,12 @@ package handler
 
 import (
 	"context"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
 )
 
 func TestUUIDFromClusterName_Deterministic(t *testing.T) {
@@ -48,7 +51,7 @@ func TestClusterRetrieve_UnmarshalFailureOnCorruptData(t *testing.T) {
 	c := &KubernetesClusters{Items: make(map[uuid.UUID]KubernetesCluster)}
 
 	id := uuid.New()
-	if err := con.Set(ctx, id.String(), "not-json", 0).Err(); err != nil {
+	if err := con.Set(ctx, clusterRepository.Key(id), "not-json", 0).Err(); err != nil {
 		t.Fatalf("failed to seed corrupt value: %v", err)
 	}
 
@@ -96,7 +99,7 @@ func TestClusterScan_SkipsCorruptAndNonUUIDEntries(t *testing.T) {
 		t.Fatalf("unexpected error: %v", err)
 	}
 	corrupt := uuid.New()
-	if err := con.Set(ctx, corrupt.String(), "not-json", 0).Err(); err != nil {
+	if err := con.Set(ctx, clusterRepository.Key(corrupt), "not-json", 0).Err(); err != nil {
 		t.Fatalf("failed to seed corrupt value: %v", err)
 	}
 	if err := con.Set(ctx, "eol_cache:all_packages", "{}", 0).Err(); err != nil {
@@ -128,3 +131,230 @@ func TestClusterScan_EmptyDatabase(t *testing.T) {
 		t.Fatalf("expected no items in an empty database, got %d", len(result.Items))
 	}
 }
+
+func TestClusterPatch_AddsUpdatesAndRemovesCharts(t *testing.T) {
+	ctx := context.Background()
+	con := newTestClient(t)
+	c := &KubernetesClusters{Items: make(map[uuid.UUID]KubernetesCluster)}
+
+	_, err := c.InsertClusterData(KubernetesCluster{
+		ClusterName: "minikube",
+		KubeVersion: "1.30",
+		HelmCharts: []HelmChartData{
+			{ChartName: "redis", Version: "18.1.0", Namespace: "cache"},
+			{ChartName: "keepup", Version: "0.5.0", Namespace: "monitoring"},
+			{ChartName: "ingress-nginx", Version: "4.9.0", Namespace: "ingress"},
+		},
+	}, ctx, con, 60)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	version := "1.31"
+	cluster, _, err := c.PatchCluster(ClusterPatch{
+		ClusterName: "minikube",
+		KubeVersion: &version,
+		HelmCharts: map[string]*HelmChartPatch{
+			"cache/redis":         {Version: "18.2.0"},
+			"monitoring/keepup":   nil,
+			"db/postgresql":       {Version: "15.5.0"},
+			"missing/not-present": nil,
+		},
+	}, ctx, con, 60, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []HelmChartData{
+		{ChartName: "redis", Version: "18.2.0", Namespace: "cache"},
+		{ChartName: "ingress-nginx", Version: "4.9.0", Namespace: "ingress"},
+		{ChartName: "postgresql", Version: "15.5.0", Namespace: "db"},
+	}
+	if len(cluster.HelmCharts) != len(want) {
+		t.Fatalf("expected %d charts, got %+v", len(want), cluster.HelmCharts)
+	}
+	for i := range want {
+		if cluster.HelmCharts[i] != want[i] {
+			t.Errorf("chart %d: expected %+v, got %+v", i, want[i], cluster.HelmCharts[i])
+		}
+	}
+	if cluster.KubeVersion != "1.31" {
+		t.Errorf("expected kube_version 1.31, got %s", cluster.KubeVersion)
+	}
+}
+
+func TestSplitChartKey(t *testing.T) {
+	cases := map[string]bool{
+		"cache/redis": true,
+		"redis":       false,
+		"/redis":      false,
+		"cache/":      false,
+		"a/b/c":       false,
+	}
+	for key, want := range cases {
+		if _, _, ok := SplitChartKey(key); ok != want {
+			t.Errorf("SplitChartKey(%q) ok = %v, want %v", key, ok, want)
+		}
+	}
+}
+
+func TestUUIDFromCluster_ScopesSeparateSameName(t *testing.T) {
+	plain := KubernetesCluster{ClusterName: "prod"}
+	if UUIDFromCluster(plain) != UUIDFromClusterName("prod") {
+		t.Errorf("expected a cluster without scope to keep its name-based ID")
+	}
+
+	a := KubernetesCluster{ClusterName: "prod", Project: "checkout", Region: "eu-west-1"}
+	b := KubernetesCluster{ClusterName: "prod", Project: "search", Region: "eu-west-1"}
+	swapped := KubernetesCluster{ClusterName: "prod", Environment: "checkout", Region: "eu-west-1"}
+	if UUIDFromCluster(a) == UUIDFromCluster(b) || UUIDFromCluster(a) == UUIDFromCluster(swapped) {
+		t.Errorf("expected differently scoped clusters to get different IDs")
+	}
+}
+
+func TestClusterInsert_ScopedClustersDoNotOverwrite(t *testing.T) {
+	ctx := context.Background()
+	con := newTestClient(t)
+	c := &KubernetesClusters{Items: make(map[uuid.UUID]KubernetesCluster)}
+
+	a, err := c.InsertClusterData(KubernetesCluster{ClusterName: "minikube", Project: "checkout", KubeVersion: "1.29"}, ctx, con, 60)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err := c.InsertClusterData(KubernetesCluster{ClusterName: "minikube", Project: "search", KubeVersion: "1.30"}, ctx, con, 60)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	team := "payments"
+	patched, _, err := c.PatchCluster(ClusterPatch{ClusterName: "minikube", Project: "checkout", Team: &team}, ctx, con, 60, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if patched.ID != a {
+		t.Errorf("expected the patch to address %s, got %s", a, patched.ID)
+	}
+	stored, err := c.RetrieveCluster(b, ctx, con)
+	if err != nil || stored.KubeVersion != "1.30" || stored.Team != "" {
+		t.Errorf("expected the search cluster to be untouched, got %+v (err %v)", stored, err)
+	}
+}
+
+func TestClusterPatch_MergesLabels(t *testing.T) {
+	ctx := context.Background()
+	con := newTestClient(t)
+	c := &KubernetesClusters{Items: make(map[uuid.UUID]KubernetesCluster)}
+
+	_, err := c.InsertClusterData(KubernetesCluster{
+		ClusterName: "minikube",
+		KubeVersion: "1.30",
+		Labels:      map[string]string{"owner": "sre", "service": "checkout"},
+	}, ctx, con, 60)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	costCenter := "cc-42"
+	cluster, _, err := c.PatchCluster(ClusterPatch{
+		ClusterName: "minikube",
+		Labels:      map[string]*string{"owner": nil, "cost_center": &costCenter},
+	}, ctx, con, 60, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cluster.Labels) != 2 || cluster.Labels["service"] != "checkout" || cluster.Labels["cost_center"] != "cc-42" {
+		t.Errorf("expected service and cost_center labels, got %v", cluster.Labels)
+	}
+}
+
+func TestClusterPatch_KeysReleasesByReleaseName(t *testing.T) {
+	ctx := context.Background()
+	con := newTestClient(t)
+	c := &KubernetesClusters{Items: make(map[uuid.UUID]KubernetesCluster)}
+
+	_, err := c.InsertClusterData(KubernetesCluster{
+		ClusterName: "minikube",
+		KubeVersion: "1.30",
+		HelmCharts: []HelmChartData{
+			{ChartName: "redis", Version: "18.1.5", Namespace: "cache", ReleaseName: "sessions", Status: "deployed", Revision: 3},
+			{ChartName: "redis", Version: "18.1.5", Namespace: "cache", ReleaseName: "queue", Status: "deployed", Revision: 1},
+		},
+	}, ctx, con, 60)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cluster, _, err := c.PatchCluster(ClusterPatch{
+		ClusterName: "minikube",
+		HelmCharts: map[string]*HelmChartPatch{
+			"cache/queue":   {Version: "18.2.0", Status: "failed", Revision: 2},
+			"cache/ratelim": {Version: "18.2.0", ChartName: "redis", Status: "pending-install"},
+		},
+	}, ctx, con, 60, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []HelmChartData{
+		{ChartName: "redis", Version: "18.1.5", Namespace: "cache", ReleaseName: "sessions", Status: "deployed", Revision: 3},
+		{ChartName: "redis", Version: "18.2.0", Namespace: "cache", ReleaseName: "queue", Status: "failed", Revision: 2},
+		{ChartName: "redis", Version: "18.2.0", Namespace: "cache", ReleaseName: "ratelim", Status: "pending-install"},
+	}
+	if len(cluster.HelmCharts) != len(want) {
+		t.Fatalf("expected %d releases, got %+v", len(want), cluster.HelmCharts)
+	}
+	for i := range want {
+		if cluster.HelmCharts[i] != want[i] {
+			t.Errorf("release %d: expected %+v, got %+v", i, want[i], cluster.HelmCharts[i])
+		}
+	}
+}
+
+func TestChartViolations_RejectsDuplicateReleases(t *testing.T) {
+	violations := chartViolations([]HelmChartData{
+		{ChartName: "redis", Namespace: "cache"},
+		{ChartName: "redis", Namespace: "cache", ReleaseName: "queue"},
+		{ChartName: "redis", Namespace: "cache"},
+	})
+	if len(violations) != 1 || violations[0].Field != "helm_charts[2].release_name" || violations[0].Rule != "duplicate" {
+		t.Errorf("expected one duplicate violation for the third release, got %+v", violations)
+	}
+
+	v := validate(HelmChartData{ChartName: "redis", Version: "1", Namespace: "cache", Status: "happy", LastDeployed: "yesterday"})
+	if got := strings.Join(violationFields(v), " "); got != "status:oneof last_deployed:rfc3339" {
+		t.Errorf("expected status and last_deployed violations, got %q", got)
+	}
+}
+
+func TestClusterPatch_LooksUpChartsOutsideTheTransaction(t *testing.T) {
+	ctx := context.Background()
+	con := newTestClient(t)
+	key := clusterRepository.Key(UUIDFromClusterName("minikube"))
+	lookups := 0
+	c := &KubernetesClusters{
+		Items: make(map[uuid.UUID]KubernetesCluster),
+		ChartVersions: func(ctx context.Context, con *redis.Client, repository string, chart string) ([]string, error) {
+			lookups++
+			// Rewriting the record would abort a transaction this runs in.
+			con.Set(ctx, key, con.Get(ctx, key).Val(), time.Minute)
+			return []string{"18.3.0", "18.2.0"}, nil
+		},
+	}
+	if _, err := c.InsertClusterData(KubernetesCluster{ClusterName: "minikube", KubeVersion: "1.30"}, ctx, con, 60); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lookups = 0
+
+	cluster, _, err := c.PatchCluster(ClusterPatch{
+		ClusterName: "minikube",
+		HelmCharts: map[string]*HelmChartPatch{
+			"cache/redis": {Version: "18.2.0", Repository: "https://charts.example.com"},
+		},
+	}, ctx, con, 60, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if lookups != 1 || len(cluster.HelmCharts) != 1 || cluster.HelmCharts[0].LatestVersion != "18.3.0" {
+		t.Errorf("expected one lookup enriching the chart, got %d: %+v", lookups, cluster.HelmCharts)
+	}
+}
@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// A host's operating system is reported apart from its packages, with the
+// distribution as named by os-release and the running kernel. Both are
+// enriched from endoflife.date: the distribution by its own product, the
+// kernel by the series of upstream linux it is built from.
+
+const kernelEOLProduct = "linux"
+
+// osEOLProducts maps an os-release ID onto its endoflife.date product.
+var osEOLProducts = map[string]string{
+	"debian":        "debian",
+	"ubuntu":        "ubuntu",
+	"rhel":          "rhel",
+	"centos":        "centos",
+	"rocky":         "rocky-linux",
+	"almalinux":     "almalinux",
+	"ol":            "oracle-linux",
+	"alpine":        "alpine-linux",
+	"amzn":          "amazon-linux",
+	"fedora":        "fedora",
+	"sles":          "sles",
+	"opensuse-leap": "opensuse",
+}
+
+// HostOS is the operating system of a host.
+type HostOS struct {
+	ID       string `json:"id" validate:"required,max=64,label"` // os-release ID, e.g. debian
+	Version  string `json:"version,omitempty" validate:"max=64,label"`
+	Codename string `json:"codename,omitempty" validate:"max=64,label"`
+	Kernel   string `json:"kernel,omitempty" validate:"max=128,label"` // uname -r
+	Arch     string `json:"arch,omitempty" validate:"max=32,label"`
+	BootTime string `json:"boot_time,omitempty" validate:"rfc3339"`
+
+	// Set by keepup from endoflife.date, see enrichOS.
+	EOLProduct          string `json:"eol_product,omitempty"`
+	VersionEoF          string `json:"version_eol,omitempty"`
+	LatestVersion       string `json:"latest_version,omitempty"`
+	Expired             bool   `json:"expired,omitempty"`
+	KernelCycle         string `json:"kernel_cycle,omitempty"`
+	KernelEoF           string `json:"kernel_eol,omitempty"`
+	KernelLatestVersion string `json:"kernel_latest_version,omitempty"`
+	KernelExpired       bool   `json:"kernel_expired,omitempty"`
+}
+
+// enrichOS sets the EOL fields of os. They stay empty when the
+// distribution or kernel series is unknown or the lookup fails.
+func (c *PackageVersionss) enrichOS(ctx context.Context, con *redis.Client, os *HostOS) {
+	if os == nil {
+		return
+	}
+	os.EOLProduct, os.VersionEoF, os.LatestVersion, os.Expired = "", "", "", false
+	os.KernelCycle, os.KernelEoF, os.KernelLatestVersion, os.KernelExpired = "", "", "", false
+	if c.EOLEntries == nil {
+		return
+	}
+
+	now := time.Now()
+	if product, ok := osEOLProducts[strings.ToLower(os.ID)]; ok {
+		if entry, ok := c.lookupCycle(ctx, con, product, os.Version); ok {
+			os.EOLProduct = product
+			os.VersionEoF = string(entry.EOL)
+			os.LatestVersion = entry.Latest
+			os.Expired = eolPassed(os.VersionEoF, now)
+		}
+	}
+	if entry, ok := c.lookupCycle(ctx, con, kernelEOLProduct, os.Kernel); ok {
+		os.KernelCycle = entry.Cycle
+		os.KernelEoF = string(entry.EOL)
+		os.KernelLatestVersion = entry.Latest
+		os.KernelExpired = eolPassed(os.KernelEoF, now)
+	}
+}
+
+// lookupCycle returns the most specific release cycle of product that
+// version belongs to.
+func (c *PackageVersionss) lookupCycle(ctx context.Context, con *redis.Client, product string, version string) (EndOfLifeEntry, bool) {
+	cycles := releaseCycles(version)
+	if len(cycles) == 0 {
+		return EndOfLifeEntry{}, false
+	}
+	entries, err := c.EOLEntries(ctx, con, product)
+	if err != nil {
+		log.Printf("Can't look up EOL of %s: %v", product, err)
+		return EndOfLifeEntry{}, false
+	}
+	for _, cycle := range cycles {
+		for _, entry := range entries {
+			if entry.Cycle == cycle {
+				return entry, true
+			}
+		}
+	}
+	return EndOfLifeEntry{}, false
+}
+
+// memoizeEOLEntries wraps lookup so that every product is looked up only
+// once, for writes that enrich many hosts at a time.
+func memoizeEOLEntries(lookup func(ctx context.Context, con *redis.Client, product string) ([]EndOfLifeEntry, error)) func(ctx context.Context, con *redis.Client, product string) ([]EndOfLifeEntry, error) {
+	if lookup == nil {
+		return nil
+	}
+	type result struct {
+		entries []EndOfLifeEntry
+		err     error
+	}
+	results := make(map[string]result)
+	return func(ctx context.Context, con *redis.Client, product string) ([]EndOfLifeEntry, error) {
+		cached, ok := results[product]
+		if !ok {
+			cached.entries, cached.err = lookup(ctx, con, product)
+			results[product] = cached
+		}
+		return cached.entries, cached.err
+	}
+}
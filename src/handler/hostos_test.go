@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestHostInsert_EnrichesOSAndKernel(t *testing.T) {
+	ctx := context.Background()
+	con := newTestClient(t)
+	seedEOLCache(t, con, `{
+		"ubuntu": [
+			{"cycle": "24.04", "eol": "2099-05-31", "latest": "24.04.1"},
+			{"cycle": "20.04", "eol": "2025-05-31", "latest": "20.04.6"}
+		],
+		"linux": [
+			{"cycle": "6.8", "eol": "2024-05-30", "latest": "6.8.12"},
+			{"cycle": "6.1", "eol": "2099-12-31", "latest": "6.1.112"}
+		]
+	}`)
+	c := &PackageVersionss{Items: make(map[uuid.UUID]PackageVersions), EOLEntries: QueryEOLEntries}
+
+	id, err := c.Insert(PackageVersions{
+		DataCenterPkg: "dc1",
+		HostIPPkg:     "10.0.0.1",
+		OS:            &HostOS{ID: "ubuntu", Version: "20.04", Codename: "focal", Kernel: "6.1.0-18-amd64", Arch: "x86_64"},
+	}, ctx, con, noopQuery, 60)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	stored, _ := c.Retrieve(id, ctx, con)
+	os := stored.OS
+	if os == nil {
+		t.Fatalf("expected the OS to be stored")
+	}
+	if os.EOLProduct != "ubuntu" || os.VersionEoF != "2025-05-31" || os.LatestVersion != "20.04.6" || !os.Expired {
+		t.Errorf("unexpected distribution EOL data: %+v", os)
+	}
+	if os.KernelCycle != "6.1" || os.KernelEoF != "2099-12-31" || os.KernelLatestVersion != "6.1.112" || os.KernelExpired {
+		t.Errorf("unexpected kernel EOL data: %+v", os)
+	}
+
+	// A push without an OS section, e.g. from another source, keeps it.
+	if _, err := c.Insert(PackageVersions{DataCenterPkg: "dc1", HostIPPkg: "10.0.0.1", Source: "containers"}, ctx, con, noopQuery, 60); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stored, _ = c.Retrieve(id, ctx, con)
+	if stored.OS == nil || stored.OS.ID != "ubuntu" {
+		t.Errorf("expected the OS to be kept, got %+v", stored.OS)
+	}
+}
+
+func TestDecodePackageDocument_ReadsHostOS(t *testing.T) {
+	pkg, _, err := decodePackageDocument([]byte(`{"host":{"data_center":"dc1","host_ip":"10.0.0.1","os":{"id":"debian","version":"12","kernel":"6.1.0-18-amd64"}},"packages":[]}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pkg.OS == nil || pkg.OS.ID != "debian" || pkg.OS.Version != "12" {
+		t.Errorf("expected the v2 host OS, got %+v", pkg.OS)
+	}
+
+	pkg, _, err = decodePackageDocument([]byte(`{"os":{"id":"alpine","version":"3.19.1"},"packages":{"data_center":"dc1","host_ip":"10.0.0.1"}}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pkg.OS == nil || pkg.OS.ID != "alpine" {
+		t.Errorf("expected the legacy document's OS, got %+v", pkg.OS)
+	}
+}
@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// A host's ID is derived from the identity fields configured with
+// HOST_IDENTITY_FIELDS. The default, data_center and host_ip, reproduces the
+// IDs of UUIDFromDcAndIPPackage; fleets with overlapping private ranges or
+// DHCP can key hosts by hostname, machine_id or any label sent in the
+// identity map instead. Only the default joins its values with "-"; other
+// compositions use "|", which the label-checked host fields can't contain,
+// so that hostnames like "web-1" don't run into the next value.
+
+var DefaultHostIdentity = []string{"data_center", "host_ip"}
+
+// hostMetaKeys are the keys of a package document that describe the host
+// rather than name a package.
+var hostMetaKeys = map[string]bool{
+	"data_center": true,
+	"host_ip":     true,
+	"team":        true,
+	"hostname":    true,
+	"machine_id":  true,
+}
+
+// ParseHostIdentity parses a comma separated HOST_IDENTITY_FIELDS value.
+func ParseHostIdentity(raw string) ([]string, error) {
+	if strings.TrimSpace(raw) == "" {
+		return DefaultHostIdentity, nil
+	}
+	var fields []string
+	for _, field := range strings.Split(raw, ",") {
+		field = strings.TrimSpace(field)
+		if !labelSafe.MatchString(field) {
+			return nil, fmt.Errorf("invalid identity field %q", field)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+func (c *PackageVersionss) identityFields() []string {
+	if len(c.Identity) == 0 {
+		return DefaultHostIdentity
+	}
+	return c.Identity
+}
+
+// HostID returns the ID of the host pkg describes, or of its artifact.
+func (c *PackageVersionss) HostID(pkg PackageVersions) uuid.UUID {
+	if pkg.Artifact != "" {
+		return UUIDFromArtifact(pkg.Artifact)
+	}
+	fields := c.identityFields()
+	separator := "|"
+	if slices.Equal(fields, DefaultHostIdentity) {
+		separator = "-"
+	}
+	parts := make([]string, 0, len(fields)+1)
+	for _, field := range fields {
+		value, _ := identityValue(pkg, field)
+		parts = append(parts, value)
+	}
+	parts = append(parts, UUIDSuffix)
+	return uuid.NewSHA1(uuid.NameSpaceDNS, []byte(strings.Join(parts, separator)))
+}
+
+// ownerHostID returns the ID of the host record that an inventory owned by
+// host joins on, derived from the same identity fields.
+func ownerHostID(fields []string, host PackageVersions) uuid.UUID {
+	return (&PackageVersionss{Identity: fields}).HostID(host)
+}
+
+// ownerIdentityViolations reports the identity fields that host, the owner
+// of an inventory, lacks.
+func ownerIdentityViolations(fields []string, host PackageVersions) []Violation {
+	return (&PackageVersionss{Identity: fields}).identityViolations(host)
+}
+
+// identityViolations reports the configured identity fields that pkg lacks.
+// data_center and host_ip are always required and reported by validate.
+func (c *PackageVersionss) identityViolations(pkg PackageVersions) []Violation {
+	var violations []Violation
+	for _, field := range c.identityFields() {
+		if field == "data_center" || field == "host_ip" {
+			continue
+		}
+		if value, path := identityValue(pkg, field); strings.TrimSpace(value) == "" {
+			violations = append(violations, Violation{Field: path, Rule: "identity", Message: "is required by HOST_IDENTITY_FIELDS"})
+		}
+	}
+	return violations
+}
+
+// identityValue returns the value of an identity field and its JSON path.
+// Names other than the built-in host fields refer to the identity map.
+func identityValue(pkg PackageVersions, field string) (string, string) {
+	switch field {
+	case "data_center":
+		return pkg.DataCenterPkg, field
+	case "host_ip":
+		return pkg.HostIPPkg, field
+	case "hostname":
+		return pkg.Hostname, field
+	case "machine_id":
+		return pkg.MachineID, field
+	default:
+		return pkg.Identity[field], joinPath("identity", field)
+	}
+}
@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestHostID_DefaultMatchesDcAndIP(t *testing.T) {
+	c := &PackageVersionss{Items: make(map[uuid.UUID]PackageVersions)}
+	pkg := PackageVersions{DataCenterPkg: "dc1", HostIPPkg: "10.0.0.1", Hostname: "web-1"}
+	if got, want := c.HostID(pkg), UUIDFromDcAndIPPackage("dc1", "10.0.0.1"); got != want {
+		t.Errorf("expected the default identity to keep the legacy ID %s, got %s", want, got)
+	}
+}
+
+func TestHostID_ConfiguredFields(t *testing.T) {
+	fields, err := ParseHostIdentity("data_center, hostname, rack")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c := &PackageVersionss{Items: make(map[uuid.UUID]PackageVersions), Identity: fields}
+
+	a := PackageVersions{DataCenterPkg: "dc1", HostIPPkg: "10.0.0.1", Hostname: "web-1", Identity: map[string]string{"rack": "r1"}}
+	b := a
+	b.Hostname = "web-2"
+	moved := a
+	moved.HostIPPkg = "10.0.0.99"
+
+	if c.HostID(a) == c.HostID(b) {
+		t.Errorf("expected hosts sharing an IP but not a hostname to get different IDs")
+	}
+	if c.HostID(a) != c.HostID(moved) {
+		t.Errorf("expected a host to keep its ID when its IP changes")
+	}
+
+	violations := c.identityViolations(PackageVersions{DataCenterPkg: "dc1", HostIPPkg: "10.0.0.1"})
+	if len(violations) != 2 || violations[0].Field != "hostname" || violations[1].Field != "identity.rack" {
+		t.Errorf("expected violations for hostname and identity.rack, got %+v", violations)
+	}
+}
+
+func TestHostID_ValuesDoNotRunTogether(t *testing.T) {
+	c := &PackageVersionss{Items: make(map[uuid.UUID]PackageVersions), Identity: []string{"hostname", "rack"}}
+	a := PackageVersions{Hostname: "web-1", Identity: map[string]string{"rack": "r1"}}
+	b := PackageVersions{Hostname: "web", Identity: map[string]string{"rack": "1-r1"}}
+	if c.HostID(a) == c.HostID(b) {
+		t.Errorf("expected hostname and rack values not to collide once joined")
+	}
+}
+
+func TestParseHostIdentity(t *testing.T) {
+	if fields, err := ParseHostIdentity(""); err != nil || len(fields) != 2 {
+		t.Errorf("expected the default identity, got %v (err %v)", fields, err)
+	}
+	if _, err := ParseHostIdentity("data_center,,host_ip"); err == nil {
+		t.Errorf("expected an error for an empty field")
+	}
+}
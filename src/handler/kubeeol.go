@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// A cluster's Kubernetes version is enriched from endoflife.date like a
+// package. Managed flavours have their own support windows, so the cluster's
+// provider picks the product; versions the flavour doesn't list fall back to
+// upstream kubernetes.
+
+const kubernetesEOLProduct = "kubernetes"
+
+var kubernetesEOLProducts = map[string]string{
+	"eks":                      "amazon-eks",
+	"aws":                      "amazon-eks",
+	"amazon-eks":               "amazon-eks",
+	"aks":                      "azure-kubernetes-service",
+	"azure":                    "azure-kubernetes-service",
+	"azure-kubernetes-service": "azure-kubernetes-service",
+	"gke":                      "google-kubernetes-engine",
+	"gcp":                      "google-kubernetes-engine",
+	"google":                   "google-kubernetes-engine",
+	"google-kubernetes-engine": "google-kubernetes-engine",
+}
+
+// kubeCycle returns the release cycle of a Kubernetes version such as
+// v1.29.3, 1.29.1-gke.1589000 or v1.28.5-eks-5e0fdde.
+func kubeCycle(version string) string {
+	return extractMajorMinor(strings.TrimPrefix(strings.TrimSpace(version), "v"))
+}
+
+// enrichKubeVersion sets the EOL fields of cluster. They stay empty when
+// the release cycle is unknown or the lookup fails.
+func (c *KubernetesClusters) enrichKubeVersion(ctx context.Context, con *redis.Client, cluster *KubernetesCluster) {
+	cluster.KubeEOLProduct = ""
+	cluster.KubeVersionEoF = ""
+	cluster.KubeLatestVersion = ""
+	cluster.KubeExpired = false
+	if c.EOLEntries == nil {
+		return
+	}
+
+	cycle := kubeCycle(cluster.KubeVersion)
+	products := []string{kubernetesEOLProduct}
+	if product, ok := kubernetesEOLProducts[strings.ToLower(cluster.Provider)]; ok {
+		products = []string{product, kubernetesEOLProduct}
+	}
+	for _, product := range products {
+		entries, err := c.EOLEntries(ctx, con, product)
+		if err != nil {
+			log.Printf("Can't look up EOL of %s: %v", product, err)
+			continue
+		}
+		for _, entry := range entries {
+			if entry.Cycle != cycle {
+				continue
+			}
+			cluster.KubeEOLProduct = product
+			cluster.KubeVersionEoF = string(entry.EOL)
+			cluster.KubeLatestVersion = entry.Latest
+			cluster.KubeExpired = eolPassed(cluster.KubeVersionEoF, time.Now())
+			return
+		}
+	}
+}
+
+// eolPassed reports whether an endoflife.date eol value (a date, "true" or
+// "false") lies before at.
+func eolPassed(eol string, at time.Time) bool {
+	if eol == "true" {
+		return true
+	}
+	date, err := time.Parse(eolDateLayout, eol)
+	return err == nil && date.Before(at)
+}
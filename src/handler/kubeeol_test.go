@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestClusterInsert_EnrichesKubeVersionByProvider(t *testing.T) {
+	ctx := context.Background()
+	con := newTestClient(t)
+	seedEOLCache(t, con, `{
+		"kubernetes": [
+			{"cycle": "1.30", "eol": "2025-06-28", "latest": "1.30.14"},
+			{"cycle": "1.27", "eol": "2024-06-28", "latest": "1.27.16"}
+		],
+		"amazon-eks": [
+			{"cycle": "1.30", "eol": "2099-07-23", "latest": "1.30-eks-38"}
+		],
+		"google-kubernetes-engine": [
+			{"cycle": "1.31", "eol": "2099-12-02", "latest": "1.31.1-gke.100"}
+		]
+	}`)
+	c := &KubernetesClusters{Items: make(map[uuid.UUID]KubernetesCluster), EOLEntries: QueryEOLEntries}
+
+	cases := []struct {
+		cluster KubernetesCluster
+		product string
+		eol     string
+		latest  string
+		expired bool
+	}{
+		{KubernetesCluster{ClusterName: "eks", Provider: "EKS", KubeVersion: "v1.30.4-eks-a737599"}, "amazon-eks", "2099-07-23", "1.30-eks-38", false},
+		{KubernetesCluster{ClusterName: "gke", Provider: "gke", KubeVersion: "1.30.3-gke.1639000"}, "kubernetes", "2025-06-28", "1.30.14", true},
+		{KubernetesCluster{ClusterName: "old", KubeVersion: "v1.27.3"}, "kubernetes", "2024-06-28", "1.27.16", true},
+		{KubernetesCluster{ClusterName: "new", KubeVersion: "1.99.0"}, "", "", "", false},
+	}
+	for _, tc := range cases {
+		id, err := c.InsertClusterData(tc.cluster, ctx, con, 60)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.cluster.ClusterName, err)
+		}
+		stored, _ := c.RetrieveCluster(id, ctx, con)
+		if stored.KubeEOLProduct != tc.product || stored.KubeVersionEoF != tc.eol || stored.KubeLatestVersion != tc.latest || stored.KubeExpired != tc.expired {
+			t.Errorf("%s: expected %s/%s/%s/%t, got %s/%s/%s/%t", tc.cluster.ClusterName,
+				tc.product, tc.eol, tc.latest, tc.expired,
+				stored.KubeEOLProduct, stored.KubeVersionEoF, stored.KubeLatestVersion, stored.KubeExpired)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package handler
+
+// Hosts and clusters carry free-form labels such as environment, service,
+// owner or cost_center. keepup stores them as sent; which of them become
+// metric labels is decided by the metrics package.
+
+// mergeLabels applies a label patch: a label mapped to nil is removed, any
+// other is added or updated. It returns nil when no labels are left.
+func mergeLabels(labels map[string]string, patch map[string]*string) map[string]string {
+	merged := make(map[string]string, len(labels)+len(patch))
+	for name, value := range labels {
+		merged[name] = value
+	}
+	for name, value := range patch {
+		if value == nil {
+			delete(merged, name)
+			continue
+		}
+		merged[name] = *value
+	}
+	if len(merged) == 0 {
+		return nil
+	}
+	return merged
+}
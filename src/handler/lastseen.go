@@ -0,0 +1,260 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"keepup/src/notify"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	EntityKindHost    = "host"
+	EntityKindCluster = "cluster"
+
+	lastSeenKeyPrefix     = "keepup:last_seen:"
+	lastSeenMetaKeyPrefix = "keepup:last_seen_meta:"
+	lastSeenRetention     = 7 * 24 * time.Hour
+)
+
+// SeenEntity is a "last seen" index entry. The index lives in a sorted set
+// per entity kind (score = unix time of the last push) plus a hash holding
+// the labels, and outlives the data TTL so that disappearance is visible.
+type SeenEntity struct {
+	Kind       string    `json:"kind"`
+	ID         uuid.UUID `json:"id"`
+	Name       string    `json:"name"`
+	Team       string    `json:"team"`
+	DataCenter string    `json:"data_center,omitempty"`
+	LastSeen   int64     `json:"last_seen"`
+	// TTLSeconds is the TTL the last push was stored with, x-keepup-ttl or
+	// the domain's.
+	TTLSeconds int `json:"ttl_seconds,omitempty"`
+}
+
+func TouchLastSeen(ctx context.Context, con *redis.Client, entity SeenEntity) error {
+	meta, err := json.Marshal(entity)
+	if err != nil {
+		return err
+	}
+	pipe := con.TxPipeline()
+	pipe.ZAdd(ctx, lastSeenKeyPrefix+entity.Kind, redis.Z{Score: float64(entity.LastSeen), Member: entity.ID.String()})
+	pipe.HSet(ctx, lastSeenMetaKeyPrefix+entity.Kind, entity.ID.String(), meta)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// ForgetLastSeen drops an entity from the index, e.g. after its record was
+// deleted on purpose.
+func ForgetLastSeen(ctx context.Context, con *redis.Client, kind string, id uuid.UUID) error {
+	pipe := con.TxPipeline()
+	pipe.ZRem(ctx, lastSeenKeyPrefix+kind, id.String())
+	pipe.HDel(ctx, lastSeenMetaKeyPrefix+kind, id.String())
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// StaleEntities returns the entities of kind whose last push is older than
+// olderThan.
+func StaleEntities(ctx context.Context, con *redis.Client, kind string, olderThan time.Duration) ([]SeenEntity, error) {
+	cutoff := time.Now().Add(-olderThan).Unix()
+	ids, err := con.ZRangeByScore(ctx, lastSeenKeyPrefix+kind, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: fmt.Sprint(cutoff),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	metas, err := con.HMGet(ctx, lastSeenMetaKeyPrefix+kind, ids...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var entities []SeenEntity
+	for i, val := range metas {
+		str, ok := val.(string)
+		if !ok {
+			continue
+		}
+		var entity SeenEntity
+		if err := json.Unmarshal([]byte(str), &entity); err != nil {
+			log.Printf("Can't unmarshal last seen entry %s: %v", ids[i], err)
+			continue
+		}
+		entities = append(entities, entity)
+	}
+	return entities, nil
+}
+
+func pruneLastSeen(ctx context.Context, con *redis.Client, kind string, olderThan time.Duration) error {
+	stale, err := con.ZRangeByScore(ctx, lastSeenKeyPrefix+kind, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: fmt.Sprint(time.Now().Add(-olderThan).Unix()),
+	}).Result()
+	if err != nil || len(stale) == 0 {
+		return err
+	}
+	members := make([]interface{}, len(stale))
+	for i, id := range stale {
+		members[i] = id
+	}
+	pipe := con.TxPipeline()
+	pipe.ZRem(ctx, lastSeenKeyPrefix+kind, members...)
+	pipe.HDel(ctx, lastSeenMetaKeyPrefix+kind, stale...)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// MissingEntitiesHandler exposes the entities that have not reported within
+// their grace period. Grace maps an entity kind to how long it may stay
+// silent; it should be shorter than the data TTL so that a dead agent is
+// noticed before its record is evicted. With GraceTTLFraction set an entity
+// may instead stay silent for that fraction of the TTL its last push was
+// stored with, so that a push with a longer x-keepup-ttl isn't reported
+// missing early while a dead agent is still noticed before eviction; Grace
+// then applies to entities recorded without a TTL.
+type MissingEntitiesHandler struct {
+	Client           *redis.Client
+	Context          context.Context
+	ApiToken         string
+	Grace            map[string]time.Duration
+	GraceTTLFraction float64
+}
+
+type MissingEntitiesDocument struct {
+	Entities []SeenEntity `json:"entities"`
+}
+
+// Missing returns the silent entities of the given kinds, or of every
+// configured kind when none are given, oldest first.
+func (m *MissingEntitiesHandler) Missing(kinds ...string) ([]SeenEntity, error) {
+	if len(kinds) == 0 {
+		for kind := range m.Grace {
+			kinds = append(kinds, kind)
+		}
+	}
+
+	entities := []SeenEntity{}
+	for _, kind := range kinds {
+		grace, ok := m.Grace[kind]
+		if !ok {
+			continue
+		}
+		if m.GraceTTLFraction <= 0 {
+			stale, err := StaleEntities(m.Context, m.Client, kind, grace)
+			if err != nil {
+				return nil, err
+			}
+			entities = append(entities, stale...)
+			continue
+		}
+
+		seen, err := StaleEntities(m.Context, m.Client, kind, 0)
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		for _, entity := range seen {
+			entityGrace := grace
+			if entity.TTLSeconds > 0 {
+				entityGrace = time.Duration(float64(entity.TTLSeconds) * m.GraceTTLFraction * float64(time.Second))
+			}
+			if time.Unix(entity.LastSeen, 0).Add(entityGrace).Before(now) {
+				entities = append(entities, entity)
+			}
+		}
+	}
+	sort.Slice(entities, func(i, j int) bool {
+		return entities[i].LastSeen < entities[j].LastSeen
+	})
+	return entities, nil
+}
+
+func (m *MissingEntitiesHandler) Handler() http.HandlerFunc {
+	return withAuth(m.ApiToken, map[string]http.HandlerFunc{
+		"GET": m.handleListMissing,
+	})
+}
+
+func (m *MissingEntitiesHandler) handleListMissing(w http.ResponseWriter, r *http.Request) {
+	var kinds []string
+	if kind := r.URL.Query().Get("kind"); kind != "" {
+		kinds = append(kinds, kind)
+	}
+
+	entities, err := m.Missing(kinds...)
+	if err != nil {
+		log.Printf("Failed to list missing entities: %v", err)
+		writeError(w, r, err, "Failed to list missing entities")
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(MissingEntitiesDocument{Entities: entities}); err != nil {
+		writeError(w, r, err, "Failed to encode response")
+		return
+	}
+}
+
+// MissingWatcher periodically prunes the last seen index and raises
+// entity_missing notifications for entities that stopped reporting.
+type MissingWatcher struct {
+	Entities *MissingEntitiesHandler
+	Notifier *notify.Notifier
+	Interval time.Duration
+}
+
+func (m *MissingWatcher) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(m.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			m.Check()
+		}
+	}
+}
+
+func (m *MissingWatcher) Check() {
+	for kind := range m.Entities.Grace {
+		if err := pruneLastSeen(m.Entities.Context, m.Entities.Client, kind, lastSeenRetention); err != nil {
+			log.Printf("Can't prune last seen index for %s: %v", kind, err)
+		}
+	}
+
+	missing, err := m.Entities.Missing()
+	if err != nil {
+		log.Printf("Can't read last seen index: %v", err)
+		return
+	}
+	for _, entity := range missing {
+		m.Notifier.Notify(missingEvent(entity))
+	}
+}
+
+func missingEvent(entity SeenEntity) notify.Event {
+	lastSeen := time.Unix(entity.LastSeen, 0).UTC()
+	return notify.Event{
+		Kind:       notify.EventEntityMissing,
+		EntityKind: entity.Kind,
+		EntityID:   entity.ID,
+		Name:       entity.Name,
+		Team:       entity.Team,
+		DataCenter: entity.DataCenter,
+		LastSeen:   strconv.FormatInt(entity.LastSeen, 10),
+		Message:    fmt.Sprintf("%s %s (team %s) stopped reporting, last seen %s", entity.Kind, entity.Name, entity.Team, lastSeen.Format(time.RFC3339)),
+		OccurredAt: time.Now().UTC(),
+	}
+}
@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestStaleEntities_ReportsSilentEntities(t *testing.T) {
+	ctx := context.Background()
+	con := newTestClient(t)
+	id := uuid.New()
+
+	err := TouchLastSeen(ctx, con, SeenEntity{
+		Kind:     EntityKindCluster,
+		ID:       id,
+		Name:     "minikube",
+		LastSeen: time.Now().Add(-time.Hour).Unix(),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	stale, err := StaleEntities(ctx, con, EntityKindCluster, time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(stale) != 1 || stale[0].ID != id || stale[0].Name != "minikube" {
+		t.Fatalf("expected the silent cluster to be reported, got %+v", stale)
+	}
+
+	stale, err = StaleEntities(ctx, con, EntityKindCluster, 2*time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(stale) != 0 {
+		t.Errorf("expected nothing stale within the threshold, got %+v", stale)
+	}
+}
+
+func TestMissingEntitiesHandler_ListsOnlySilentEntities(t *testing.T) {
+	ctx := context.Background()
+	con := newTestClient(t)
+	m := &MissingEntitiesHandler{
+		Client:   con,
+		Context:  ctx,
+		ApiToken: "secret",
+		Grace: map[string]time.Duration{
+			EntityKindHost:    10 * time.Minute,
+			EntityKindCluster: 10 * time.Minute,
+		},
+	}
+
+	silent := SeenEntity{Kind: EntityKindHost, ID: uuid.New(), Name: "10.0.0.1", LastSeen: time.Now().Add(-time.Hour).Unix()}
+	alive := SeenEntity{Kind: EntityKindCluster, ID: uuid.New(), Name: "minikube", LastSeen: time.Now().Unix()}
+	for _, entity := range []SeenEntity{silent, alive} {
+		if err := TouchLastSeen(ctx, con, entity); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/missing-entities", nil)
+	req.Header.Set("x-api-token", "secret")
+	rec := httptest.NewRecorder()
+	m.Handler()(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+	var doc MissingEntitiesDocument
+	if err := json.NewDecoder(rec.Body).Decode(&doc); err != nil {
+		t.Fatalf("unexpected error decoding response: %v", err)
+	}
+	if len(doc.Entities) != 1 || doc.Entities[0].ID != silent.ID {
+		t.Fatalf("expected only the silent host to be listed, got %+v", doc.Entities)
+	}
+}
+
+func TestMissingEntities_GraceTTLFraction(t *testing.T) {
+	ctx := context.Background()
+	con := newTestClient(t)
+	m := &MissingEntitiesHandler{
+		Client:           con,
+		Context:          ctx,
+		Grace:            map[string]time.Duration{EntityKindHost: 10 * time.Minute},
+		GraceTTLFraction: 0.5,
+	}
+
+	hourAgo := time.Now().Add(-time.Hour).Unix()
+	longTTL := SeenEntity{Kind: EntityKindHost, ID: uuid.New(), Name: "10.0.0.1", LastSeen: hourAgo, TTLSeconds: 86400}
+	shortTTL := SeenEntity{Kind: EntityKindHost, ID: uuid.New(), Name: "10.0.0.2", LastSeen: hourAgo, TTLSeconds: 5400}
+	noTTL := SeenEntity{Kind: EntityKindHost, ID: uuid.New(), Name: "10.0.0.3", LastSeen: hourAgo}
+	for _, entity := range []SeenEntity{longTTL, shortTTL, noTTL} {
+		if err := TouchLastSeen(ctx, con, entity); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	missing, err := m.Missing()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ids := map[uuid.UUID]bool{}
+	for _, entity := range missing {
+		ids[entity.ID] = true
+	}
+	if len(missing) != 2 || !ids[shortTTL.ID] || !ids[noTTL.ID] {
+		t.Errorf("expected the hosts past half their TTL or the default grace, got %+v", missing)
+	}
+}
+
+func TestMissingEntities_ReportsHostsBeforeEviction(t *testing.T) {
+	ctx := context.Background()
+	con := newTestClient(t)
+	m := &MissingEntitiesHandler{
+		Client:           con,
+		Context:          ctx,
+		Grace:            map[string]time.Duration{EntityKindHost: time.Hour},
+		GraceTTLFraction: 0.5,
+	}
+
+	host := PackageVersions{IDPkg: uuid.New(), DataCenterPkg: "aaa", HostIPPkg: "10.0.0.1"}
+	if _, _, err := hostRepository.Put(host, ctx, con, 7200, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err := TouchLastSeen(ctx, con, SeenEntity{
+		Kind:       EntityKindHost,
+		ID:         host.IDPkg,
+		Name:       host.HostIPPkg,
+		LastSeen:   time.Now().Add(-90 * time.Minute).Unix(),
+		TTLSeconds: 7200,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	missing, err := m.Missing(EntityKindHost)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(missing) != 1 || missing[0].ID != host.IDPkg {
+		t.Fatalf("expected the silent host to be missing, got %+v", missing)
+	}
+	if _, _, err := hostRepository.Get(host.IDPkg, ctx, con); err != nil {
+		t.Errorf("expected the host record to still exist, got %v", err)
+	}
+}
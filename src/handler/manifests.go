@@ -0,0 +1,264 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Dependency manifests are uploaded as they are found in a service's
+// repository. Only the language runtime and a few key frameworks are taken
+// from them, each mapped onto its endoflife.date product; every other
+// dependency is ignored, so a lock file listing thousands of packages
+// yields a handful of entries.
+
+const (
+	DependencyRuntime   = "runtime"
+	DependencyFramework = "framework"
+)
+
+// manifestFormats maps the file name of a supported manifest onto its
+// ecosystem.
+var manifestFormats = map[string]string{
+	"go.mod":            "go",
+	"package-lock.json": "npm",
+	"requirements.txt":  "pypi",
+	"poetry.lock":       "pypi",
+	"composer.lock":     "composer",
+}
+
+// runtimeEOLProducts maps an ecosystem onto the runtime and its
+// endoflife.date product.
+var runtimeEOLProducts = map[string][2]string{
+	"go":       {"go", "go"},
+	"npm":      {"node", "nodejs"},
+	"pypi":     {"python", "python"},
+	"composer": {"php", "php"},
+}
+
+// frameworkEOLProducts maps the key frameworks of an ecosystem, by package
+// name, onto their endoflife.date product.
+var frameworkEOLProducts = map[string]map[string]string{
+	"npm": {
+		"react":         "react",
+		"vue":           "vue",
+		"@angular/core": "angular",
+		"next":          "nextjs",
+		"nuxt":          "nuxt",
+		"electron":      "electron",
+	},
+	"pypi": {
+		"django": "django",
+	},
+	"composer": {
+		"laravel/framework":   "laravel",
+		"symfony/symfony":     "symfony",
+		"symfony/http-kernel": "symfony",
+		"drupal/core":         "drupal",
+	},
+}
+
+var ErrInvalidManifest = errors.New("Invalid manifest")
+
+// manifestFormat returns the file name and ecosystem of a manifest path such
+// as frontend/package-lock.json.
+func manifestFormat(manifest string) (string, string, bool) {
+	name := path.Base(manifest)
+	ecosystem, ok := manifestFormats[name]
+	return name, ecosystem, ok
+}
+
+// parseManifest returns the runtime and key frameworks a manifest declares.
+func parseManifest(format string, data []byte) ([]ApplicationDependency, error) {
+	var runtime string
+	var frameworks map[string]string
+	var err error
+	switch format {
+	case "go.mod":
+		runtime = parseGoMod(data)
+	case "package-lock.json":
+		runtime, frameworks, err = parsePackageLock(data)
+	case "requirements.txt":
+		frameworks = parseRequirements(data)
+	case "poetry.lock":
+		runtime, frameworks = parsePoetryLock(data)
+	case "composer.lock":
+		runtime, frameworks, err = parseComposerLock(data)
+	default:
+		return nil, ErrInvalidManifest
+	}
+	if err != nil {
+		return nil, ErrInvalidManifest
+	}
+
+	ecosystem := manifestFormats[format]
+	var dependencies []ApplicationDependency
+	if version := constraintVersion(runtime); version != "" {
+		product := runtimeEOLProducts[ecosystem]
+		dependencies = append(dependencies, ApplicationDependency{Name: product[0], Kind: DependencyRuntime, Version: version, EOLProduct: product[1]})
+	}
+	for name, product := range frameworkEOLProducts[ecosystem] {
+		if version := constraintVersion(frameworks[name]); version != "" {
+			dependencies = append(dependencies, ApplicationDependency{Name: name, Kind: DependencyFramework, Version: version, EOLProduct: product})
+		}
+	}
+	sortDependencies(dependencies)
+	return dependencies, nil
+}
+
+// constraintVersion returns the version a version or constraint such as
+// v1.22.1, ^8.2, >=3.9,<4 or 3.11.* starts from, "" when there is none.
+func constraintVersion(constraint string) string {
+	start := strings.IndexAny(constraint, "0123456789")
+	if start < 0 {
+		return ""
+	}
+	end := start
+	for end < len(constraint) && (constraint[end] == '.' || constraint[end] >= '0' && constraint[end] <= '9') {
+		end++
+	}
+	return strings.TrimRight(constraint[start:end], ".")
+}
+
+// parseGoMod returns the toolchain a go.mod pins, else its go directive.
+func parseGoMod(data []byte) string {
+	var goVersion, toolchain string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "//")
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		switch fields[0] {
+		case "go":
+			goVersion = fields[1]
+		case "toolchain":
+			toolchain = strings.TrimPrefix(fields[1], "go")
+		}
+	}
+	if toolchain != "" && toolchain != "default" {
+		return toolchain
+	}
+	return goVersion
+}
+
+// parsePackageLock returns the node version the root package's engines
+// allow and the installed version of every top-level package, from a
+// lockfile of any version.
+func parsePackageLock(data []byte) (string, map[string]string, error) {
+	var lock struct {
+		Packages map[string]struct {
+			Version string          `json:"version"`
+			Engines json.RawMessage `json:"engines"`
+		} `json:"packages"`
+		Dependencies map[string]struct {
+			Version string `json:"version"`
+		} `json:"dependencies"`
+	}
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return "", nil, err
+	}
+
+	var engines map[string]string
+	// Old lockfiles may carry engines as a list, which names no versions.
+	_ = json.Unmarshal(lock.Packages[""].Engines, &engines)
+
+	versions := make(map[string]string)
+	for name, dependency := range lock.Dependencies {
+		versions[name] = dependency.Version
+	}
+	for key, pkg := range lock.Packages {
+		if name, ok := strings.CutPrefix(key, "node_modules/"); ok && !strings.Contains(name, "/node_modules/") {
+			versions[name] = pkg.Version
+		}
+	}
+	return engines["node"], versions, nil
+}
+
+// parseRequirements returns the version every requirement is pinned to, or
+// the lower bound of its constraint. Python itself is not declared there.
+func parseRequirements(data []byte) map[string]string {
+	versions := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == '-' {
+			continue
+		}
+		line, _, _ = strings.Cut(line, " #")
+		line, _, _ = strings.Cut(line, ";")
+		end := strings.IndexAny(line, "=<>!~[ ")
+		if end < 0 {
+			continue
+		}
+		versions[normalizePythonName(line[:end])] = line[end:]
+	}
+	return versions
+}
+
+// parsePoetryLock returns the python versions a poetry.lock was resolved
+// for and the version of every locked package.
+func parsePoetryLock(data []byte) (string, map[string]string) {
+	var python, section, name string
+	versions := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			section, name = line, ""
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		value, err := strconv.Unquote(strings.TrimSpace(value))
+		if err != nil {
+			continue
+		}
+		switch key = strings.TrimSpace(key); {
+		case section == "[metadata]" && key == "python-versions":
+			python = value
+		case section == "[[package]]" && key == "name":
+			name = normalizePythonName(value)
+		case section == "[[package]]" && key == "version" && name != "":
+			versions[name] = value
+		}
+	}
+	return python, versions
+}
+
+// normalizePythonName returns a distribution name as PyPI compares it.
+func normalizePythonName(name string) string {
+	return strings.NewReplacer("_", "-", ".", "-").Replace(strings.ToLower(strings.TrimSpace(name)))
+}
+
+// parseComposerLock returns the php versions a composer.lock requires and
+// the version of every non-dev package.
+func parseComposerLock(data []byte) (string, map[string]string, error) {
+	var lock struct {
+		Packages []struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"packages"`
+		Platform json.RawMessage `json:"platform"`
+	}
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return "", nil, err
+	}
+
+	var platform map[string]string
+	// Without platform requirements composer writes an empty list.
+	_ = json.Unmarshal(lock.Platform, &platform)
+
+	versions := make(map[string]string)
+	for _, pkg := range lock.Packages {
+		versions[pkg.Name] = pkg.Version
+	}
+	return platform["php"], versions, nil
+}
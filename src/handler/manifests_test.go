@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseManifest(t *testing.T) {
+	cases := []struct {
+		format string
+		data   string
+		want   []string // name@version of every dependency, in order
+	}{
+		{"go.mod", "module example.com/checkout\n\ngo 1.21 // minimum\n\nrequire github.com/google/uuid v1.6.0\n", []string{"go@1.21"}},
+		{"go.mod", "module example.com/checkout\n\ngo 1.21\ntoolchain go1.22.3\n", []string{"go@1.22.3"}},
+		{"package-lock.json", `{
+			"lockfileVersion": 3,
+			"packages": {
+				"": {"name": "web", "engines": {"node": ">=18.17.0"}},
+				"node_modules/react": {"version": "18.2.0"},
+				"node_modules/next": {"version": "14.1.4"},
+				"node_modules/left-pad": {"version": "1.3.0"},
+				"node_modules/next/node_modules/react": {"version": "17.0.2"}
+			}
+		}`, []string{"node@18.17.0", "next@14.1.4", "react@18.2.0"}},
+		{"package-lock.json", `{"lockfileVersion": 1, "dependencies": {"vue": {"version": "2.7.16"}}}`, []string{"vue@2.7.16"}},
+		{"requirements.txt", "# pinned\nDjango==4.2.11 ; python_version >= \"3.8\"\nrequests>=2.31\n-r base.txt\n", []string{"django@4.2.11"}},
+		{"poetry.lock", `[[package]]
+name = "Django"
+version = "5.0.3"
+description = "A high-level Python web framework"
+
+[package.dependencies]
+asgiref = ">=3.7.0,<4"
+
+[[package]]
+name = "asgiref"
+version = "3.8.1"
+
+[metadata]
+lock-version = "2.0"
+python-versions = "^3.11"
+`, []string{"python@3.11", "django@5.0.3"}},
+		{"composer.lock", `{
+			"packages": [{"name": "laravel/framework", "version": "v10.48.4"}, {"name": "monolog/monolog", "version": "3.5.0"}],
+			"packages-dev": [{"name": "symfony/http-kernel", "version": "v7.0.5"}],
+			"platform": {"php": "^8.1|^8.2"}
+		}`, []string{"php@8.1", "laravel/framework@10.48.4"}},
+		{"composer.lock", `{"packages": [], "platform": []}`, nil},
+	}
+	for _, tc := range cases {
+		dependencies, err := parseManifest(tc.format, []byte(tc.data))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.format, err)
+			continue
+		}
+		var got []string
+		for _, dependency := range dependencies {
+			got = append(got, dependency.Name+"@"+dependency.Version)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: expected %v, got %v", tc.format, tc.want, got)
+		}
+	}
+
+	if _, err := parseManifest("package-lock.json", []byte(`{"packages":`)); err != ErrInvalidManifest {
+		t.Errorf("expected ErrInvalidManifest, got %v", err)
+	}
+}
+
+func TestConstraintVersion(t *testing.T) {
+	cases := map[string]string{
+		"v1.22.1":    "1.22.1",
+		"^8.2":       "8.2",
+		">=3.9,<4.0": "3.9",
+		"3.11.*":     "3.11",
+		"~18 || ~20": "18",
+		"dev-main":   "",
+		"":           "",
+	}
+	for constraint, want := range cases {
+		if got := constraintVersion(constraint); got != want {
+			t.Errorf("%q: expected %q, got %q", constraint, want, got)
+		}
+	}
+}
@@ -1,14 +1,21 @@
 package handler
 
 import (
+	"bufio"
 	"context"
 	"crypto/subtle"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"keepup/src/notify"
 	"log"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
@@ -20,26 +27,177 @@ type PackageVersionsHandler struct {
 	Context         context.Context
 	ApiToken        string
 	TTL             int
+	MaxTTL          int
+	Strict          bool
+	Limits          BodyLimits
+	BatchLimits     BodyLimits
+	Notifier        *notify.Notifier
+	EOLWarning      time.Duration
 }
+
+// PackageDocument is the legacy package push: host metadata and packages
+// share the flat packages map, see hostMetaKeys.
 type PackageDocument struct {
+	Source   string            `json:"source,omitempty"`
+	Identity map[string]string `json:"identity,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	OS       *HostOS           `json:"os,omitempty"`
 	Packages map[string]string `json:"packages"`
 }
 
+// PackageDocumentV2 keeps the host metadata apart from the package list, so
+// no metadata key can be taken for a package.
+type PackageDocumentV2 struct {
+	Host     HostDocument      `json:"host"`
+	Source   string            `json:"source,omitempty" validate:"max=64,label"`
+	Identity map[string]string `json:"identity,omitempty" validate_keys:"max=64,label"`
+	Labels   map[string]string `json:"labels,omitempty" validate_keys:"max=64"`
+	Packages []PackageItem     `json:"packages"`
+}
+
+type HostDocument struct {
+	DataCenter string  `json:"data_center" validate:"required,max=64,label"`
+	HostIP     string  `json:"host_ip" validate:"required,ip"`
+	Team       string  `json:"team,omitempty" validate:"max=64,label"`
+	Hostname   string  `json:"hostname,omitempty" validate:"max=253,label"`
+	MachineID  string  `json:"machine_id,omitempty" validate:"max=64,label"`
+	OS         *HostOS `json:"os,omitempty"`
+}
+
+// PackageItem is one installed package. Source overrides the document's
+// source for this package.
+type PackageItem struct {
+	Name    string `json:"name" validate:"required,max=128,label"`
+	Version string `json:"version" validate:"required,max=128"`
+	Source  string `json:"source,omitempty" validate:"max=64,label"`
+	Arch    string `json:"arch,omitempty" validate:"max=32,label"`
+}
+
+// PackagePatchDocument is the body of PATCH /package-version: the PUT
+// document where null removes a package.
+type PackagePatchDocument struct {
+	Source   string             `json:"source,omitempty"`
+	Identity map[string]string  `json:"identity,omitempty"`
+	Labels   map[string]*string `json:"labels,omitempty"`
+	OS       *HostOS            `json:"os,omitempty"`
+	Packages map[string]*string `json:"packages"`
+}
+
 type ResponseDocument struct {
 	ID       uuid.UUID                `json:"id"`
 	Packages map[string]PackageDetail `json:"packages"`
+	OS       *HostOS                  `json:"os,omitempty"`
+	Sources  []string                 `json:"sources,omitempty"`
 }
 
 type IDDocumentPackage struct {
 	ID uuid.UUID `json:"id"`
 }
 
+// SBOMResponseDocument lists the components of an SBOM that were skipped
+// along with the ID they were stored under.
+type SBOMResponseDocument struct {
+	ID      uuid.UUID   `json:"id"`
+	Skipped []Violation `json:"skipped,omitempty"`
+}
+
+type BatchItemResult struct {
+	ID         *uuid.UUID  `json:"id,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	Code       string      `json:"code,omitempty"`
+	Violations []Violation `json:"violations,omitempty"`
+}
+
+type BatchResponseDocument struct {
+	Items []BatchItemResult `json:"items"`
+}
+
 type KubernetesClusterMiddleware struct {
 	Clusters *KubernetesClusters
 	Client   *redis.Client
 	Context  context.Context
 	ApiToken string
 	TTL      int
+	MaxTTL   int
+	Strict   bool
+	Limits   BodyLimits
+	Notifier *notify.Notifier
+}
+
+type ContainerImagesMiddleware struct {
+	Images   *ContainerImageInventories
+	Client   *redis.Client
+	Context  context.Context
+	ApiToken string
+	TTL      int
+	MaxTTL   int
+	Strict   bool
+	Limits   BodyLimits
+}
+
+type ContainerImagesDocument struct {
+	ContainerImages ContainerImages `json:"container_images"`
+}
+
+type IDContainerImagesDocument struct {
+	ID uuid.UUID `json:"id"`
+}
+
+type CertificatesMiddleware struct {
+	Certificates *CertificateInventories
+	Client       *redis.Client
+	Context      context.Context
+	ApiToken     string
+	TTL          int
+	MaxTTL       int
+	Strict       bool
+	Limits       BodyLimits
+}
+
+type CertificatesDocument struct {
+	Certificates Certificates `json:"certificate_inventory"`
+}
+
+type IDCertificatesDocument struct {
+	ID uuid.UUID `json:"id"`
+}
+
+type ApplicationDependenciesMiddleware struct {
+	Dependencies *ApplicationDependencyInventories
+	Client       *redis.Client
+	Context      context.Context
+	ApiToken     string
+	TTL          int
+	MaxTTL       int
+	Limits       BodyLimits
+}
+
+type ApplicationDependenciesDocument struct {
+	ApplicationDependencies ApplicationDependencies `json:"application_dependencies"`
+}
+
+type IDApplicationDependenciesDocument struct {
+	ID uuid.UUID `json:"id"`
+}
+
+// CustomDomainMiddleware serves one domain declared in CUSTOM_DOMAINS. TTL is
+// the domain's ttl_seconds, or TTL_SECONDS when it sets none.
+type CustomDomainMiddleware struct {
+	Records  *CustomRecords
+	Client   *redis.Client
+	Context  context.Context
+	ApiToken string
+	TTL      int
+	MaxTTL   int
+	Limits   BodyLimits
+}
+
+type CustomRecordDocument struct {
+	Record CustomRecord `json:"record"`
+}
+
+type IDCustomRecordDocument struct {
+	ID uuid.UUID `json:"id"`
 }
 
 type ClusterDocument struct {
@@ -50,32 +208,241 @@ type IDClusterDocument struct {
 	ID uuid.UUID `json:"id"`
 }
 
+var ErrInvalidTTL = errors.New("Invalid TTL")
+
 func FlushBufferOnShutdown(shutdownWaiter *sync.WaitGroup) {
 	// TODO: cleanup logic
 	shutdownWaiter.Done()
 }
 
-func methodNotAllowedResponse(w http.ResponseWriter) {
-	w.Header().Set("Content-Type", "text/plain")
-	w.WriteHeader(http.StatusMethodNotAllowed)
-	io.WriteString(w, "METHOD NOT ALLOWED")
+// requestTTL returns the record lifetime an agent asked for in the
+// x-keepup-ttl header, capped at maxTTL, or fallback when the header is
+// absent.
+func requestTTL(r *http.Request, fallback int, maxTTL int) (int, error) {
+	raw := r.Header.Get("x-keepup-ttl")
+	if raw == "" {
+		return fallback, nil
+	}
+	ttl, err := strconv.Atoi(raw)
+	if err != nil || ttl <= 0 {
+		return 0, ErrInvalidTTL
+	}
+	if maxTTL > 0 && ttl > maxTTL {
+		return maxTTL, nil
+	}
+	return ttl, nil
+}
+
+// lookupID reads the record id from the ?id= query parameter or, as older
+// agents do, from a {"id": ...} request body.
+func lookupID(w http.ResponseWriter, r *http.Request, limit int64) (uuid.UUID, error) {
+	if raw := r.URL.Query().Get("id"); raw != "" {
+		return uuid.Parse(raw)
+	}
+	var req IDDocumentPackage
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	err := json.NewDecoder(r.Body).Decode(&req)
+	return req.ID, err
+}
+
+// packagesFromDocument splits the flat packages map into host metadata and
+// package name -> installed version pairs.
+func packagesFromDocument(req PackageDocument) PackageVersions {
+	convertedPackages := make(map[string]PackageDetail)
+	for key, value := range req.Packages {
+		if !hostMetaKeys[key] {
+			convertedPackages[key] = PackageDetail{
+				CurrentVersion: value,
+			}
+		}
+	}
+
+	return PackageVersions{
+		DataCenterPkg: req.Packages["data_center"],
+		HostIPPkg:     req.Packages["host_ip"],
+		Team:          req.Packages["team"],
+		Hostname:      req.Packages["hostname"],
+		MachineID:     req.Packages["machine_id"],
+		Identity:      req.Identity,
+		Labels:        req.Labels,
+		OS:            req.OS,
+		Source:        req.Source,
+		Packages:      convertedPackages,
+	}
+}
+
+func packagesFromDocumentV2(req PackageDocumentV2) PackageVersions {
+	convertedPackages := make(map[string]PackageDetail)
+	for _, item := range req.Packages {
+		convertedPackages[item.Name] = PackageDetail{
+			CurrentVersion: item.Version,
+			Source:         item.Source,
+			Arch:           item.Arch,
+		}
+	}
+
+	return PackageVersions{
+		DataCenterPkg: req.Host.DataCenter,
+		HostIPPkg:     req.Host.HostIP,
+		Team:          req.Host.Team,
+		Hostname:      req.Host.Hostname,
+		MachineID:     req.Host.MachineID,
+		Identity:      req.Identity,
+		Labels:        req.Labels,
+		OS:            req.Host.OS,
+		Source:        req.Source,
+		Packages:      convertedPackages,
+	}
+}
+
+// duplicates reports packages listed more than once.
+func (req PackageDocumentV2) duplicates() []Violation {
+	var violations []Violation
+	seen := make(map[string]bool)
+	for i, item := range req.Packages {
+		if seen[item.Name] {
+			violations = append(violations, Violation{Field: fmt.Sprintf("packages[%d].name", i), Rule: "duplicate", Message: "is listed more than once"})
+		}
+		seen[item.Name] = true
+	}
+	return violations
+}
+
+// decodePackageDocument decodes a package push of either shape: a document
+// with a host object or a packages list is a PackageDocumentV2, anything
+// else the legacy PackageDocument. It returns the push and the decoded
+// document.
+func decodePackageDocument(data []byte) (PackageVersions, interface{}, error) {
+	var probe struct {
+		Host     json.RawMessage `json:"host"`
+		Packages json.RawMessage `json:"packages"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return PackageVersions{}, nil, err
+	}
+
+	if probe.Host == nil && !strings.HasPrefix(strings.TrimSpace(string(probe.Packages)), "[") {
+		var req PackageDocument
+		err := json.Unmarshal(data, &req)
+		return packagesFromDocument(req), req, err
+	}
+	var req PackageDocumentV2
+	err := json.Unmarshal(data, &req)
+	return packagesFromDocumentV2(req), req, err
+}
+
+// patchFromDocument splits a PATCH document like packagesFromDocument and
+// returns the patch together with the record shape used for validation.
+func patchFromDocument(req PackagePatchDocument) (PackagePatch, PackageVersions) {
+	value := func(key string) string {
+		if v := req.Packages[key]; v != nil {
+			return *v
+		}
+		return ""
+	}
+
+	patch := PackagePatch{
+		DataCenter: value("data_center"),
+		HostIP:     value("host_ip"),
+		Hostname:   value("hostname"),
+		MachineID:  value("machine_id"),
+		Identity:   req.Identity,
+		Labels:     req.Labels,
+		Team:       req.Packages["team"],
+		OS:         req.OS,
+		Source:     req.Source,
+		Packages:   make(map[string]*string),
+	}
+	shape := PackageVersions{
+		DataCenterPkg: patch.DataCenter,
+		HostIPPkg:     patch.HostIP,
+		Hostname:      patch.Hostname,
+		MachineID:     patch.MachineID,
+		Identity:      patch.Identity,
+		Labels:        mergeLabels(nil, req.Labels),
+		Team:          value("team"),
+		OS:            req.OS,
+		Source:        req.Source,
+		Packages:      make(map[string]PackageDetail),
+	}
+	for key, version := range req.Packages {
+		if !hostMetaKeys[key] {
+			patch.Packages[key] = version
+			shape.Packages[key] = PackageDetail{CurrentVersion: value(key)}
+		}
+	}
+	return patch, shape
+}
+
+// decodeBatch reads either a JSON array of documents or a stream of
+// newline-delimited documents and returns them undecoded, so that every item
+// can be validated on its own.
+func decodeBatch(body io.Reader) ([]json.RawMessage, error) {
+	reader := bufio.NewReader(body)
+	dec := json.NewDecoder(reader)
+
+	var items []json.RawMessage
+	first, err := peekNonSpace(reader)
+	if err != nil {
+		return nil, err
+	}
+	if first == '[' {
+		if err := dec.Decode(&items); err != nil {
+			return nil, err
+		}
+		return items, nil
+	}
+
+	for {
+		var item json.RawMessage
+		err := dec.Decode(&item)
+		if err == io.EOF {
+			return items, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+}
+
+func peekNonSpace(reader *bufio.Reader) (byte, error) {
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if b != ' ' && b != '\t' && b != '\r' && b != '\n' {
+			return b, reader.UnreadByte()
+		}
+	}
+}
+
+func methodNotAllowedResponse(w http.ResponseWriter, r *http.Request, methods map[string]http.HandlerFunc) {
+	allowed := make([]string, 0, len(methods))
+	for method := range methods {
+		allowed = append(allowed, method)
+	}
+	sort.Strings(allowed)
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeProblem(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, fmt.Sprintf("%s is not supported here", r.Method))
 }
 
-// withAuth checks the x-api-token header against apiToken, then dispatches
-// to the handler registered for the request method in methods. Every
-// dispatched handler responds with application/json.
+// withAuth assigns a request id, checks the x-api-token header against
+// apiToken, then dispatches to the handler registered for the request method
+// in methods. Every dispatched handler responds with application/json, or
+// application/problem+json on errors.
 func withAuth(apiToken string, methods map[string]http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		withRequestID(w, r)
 		token := r.Header.Get("x-api-token")
 		if subtle.ConstantTimeCompare([]byte(token), []byte(apiToken)) != 1 {
-			w.Header().Set("Content-Type", "text/plain")
-			w.WriteHeader(http.StatusForbidden)
-			io.WriteString(w, "FORBIDDEN")
+			writeProblem(w, r, http.StatusForbidden, CodeForbidden, "Missing or invalid x-api-token header")
 			return
 		}
 		handlerFunc, ok := methods[strings.ToUpper(r.Method)]
 		if !ok {
-			methodNotAllowedResponse(w)
+			methodNotAllowedResponse(w, r, methods)
 			return
 		}
 		w.Header().Set("Content-Type", "application/json")
@@ -84,151 +451,997 @@ func withAuth(apiToken string, methods map[string]http.HandlerFunc) http.Handler
 }
 
 func (p *PackageVersionsHandler) handleInsertPackages(w http.ResponseWriter, r *http.Request) {
-	var req PackageDocument
 	var res IDDocumentPackage
 
-	r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
-	err := json.NewDecoder(r.Body).Decode(&req)
+	body, err := requestBody(w, r, p.Limits.or(DefaultBodyLimits))
 	if err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		bodyError(w, r, err, "Invalid request payload")
 		return
 	}
-
-	cleanedPackages := make(map[string]string)
-	for key, value := range req.Packages {
-		if key != "host_ip" && key != "data_center" && key != "team" {
-			cleanedPackages[key] = value
-		}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		bodyError(w, r, err, "Invalid request payload")
+		return
+	}
+	pkg, req, err := decodePackageDocument(data)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidPayload, "Invalid request payload")
+		return
 	}
 
-	convertedPackages := make(map[string]PackageDetail)
-	for name, version := range cleanedPackages {
-		convertedPackages[name] = PackageDetail{
-			CurrentVersion: version,
-		}
+	if violations := p.checkPackages(data, req, pkg); len(violations) > 0 {
+		validationErrorResponse(w, r, violations)
+		return
 	}
 
-	pkg := PackageVersions{
-		DataCenterPkg: req.Packages["data_center"],
-		HostIPPkg:     req.Packages["host_ip"],
-		Team:          req.Packages["team"],
-		Packages:      convertedPackages,
+	ttl, err := requestTTL(r, p.TTL, p.MaxTTL)
+	if err != nil {
+		writeError(w, r, err, "Invalid x-keepup-ttl header")
+		return
 	}
 
-	ttl := p.TTL
+	prev := p.previous(pkg)
 
-	id, err := p.PackageVersions.Insert(pkg, p.Context, p.Client, func(packageName string) (string, string, error) {
+	id, etag, err := p.PackageVersions.InsertIfMatch(pkg, p.Context, p.Client, func(packageName string) (string, string, error) {
 		return queryEndOfLifeAPI(packageName, p.Context, p.Client)
-	}, ttl)
+	}, ttl, r.Header.Get("If-Match"))
 
+	if err == ErrPreconditionFailed || err == ErrWriteConflict {
+		writeError(w, r, err, "The host record was changed by another writer")
+		return
+	}
 	if err != nil {
 		log.Printf("Failed to insert packages: %v", err)
-		http.Error(w, "Failed to insert package data", http.StatusInternalServerError)
+		writeError(w, r, err, "Failed to insert package data")
 		return
 	}
+	p.afterInsert(id, pkg, prev, ttl)
+	w.Header().Set("ETag", etag)
 
 	res = IDDocumentPackage{ID: id}
 	err = json.NewEncoder(w).Encode(res)
 	if err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		writeError(w, r, err, "Failed to encode response")
 		return
 	}
 }
 
-func (p *PackageVersionsHandler) handleGetPackages(w http.ResponseWriter, r *http.Request) {
-	var req IDDocumentPackage
+func (p *PackageVersionsHandler) handleInsertPackagesBatch(w http.ResponseWriter, r *http.Request) {
+	body, err := requestBody(w, r, p.BatchLimits.or(DefaultBatchBodyLimits))
+	if err != nil {
+		bodyError(w, r, err, "Invalid request payload")
+		return
+	}
+	defer body.Close()
+	items, err := decodeBatch(body)
+	if err != nil {
+		bodyError(w, r, err, "Invalid request payload")
+		return
+	}
 
-	r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
-	err := json.NewDecoder(r.Body).Decode(&req)
+	ttl, err := requestTTL(r, p.TTL, p.MaxTTL)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err, "Invalid x-keepup-ttl header")
 		return
 	}
 
-	pkg, err := p.PackageVersions.Retrieve(req.ID, p.Context, p.Client)
-	if err == ErrIDNotFoundPackage {
-		http.Error(w, "Packages data not found", http.StatusNotFound)
+	res := BatchResponseDocument{Items: make([]BatchItemResult, len(items))}
+	var pkgs []PackageVersions
+	var positions []int
+	for i, item := range items {
+		pkg, req, err := decodePackageDocument(item)
+		if err != nil || emptyPush(pkg) {
+			res.Items[i].Error = "Invalid item payload"
+			res.Items[i].Code = CodeInvalidPayload
+			continue
+		}
+		if violations := p.checkPackages(item, req, pkg); len(violations) > 0 {
+			res.Items[i].Error = "Validation failed"
+			res.Items[i].Code = CodeValidationFailed
+			res.Items[i].Violations = violations
+			continue
+		}
+		pkgs = append(pkgs, pkg)
+		positions = append(positions, i)
+	}
+
+	prevs := make([]*PackageVersions, len(pkgs))
+	for i, pkg := range pkgs {
+		prevs[i] = p.previous(pkg)
+	}
+
+	// Hosts in one batch usually share most of their packages, so every
+	// package is looked up in the EOL cache only once.
+	type eolLookup struct {
+		latest, eol string
+		err         error
+	}
+	lookups := make(map[string]eolLookup)
+	ids, errs := p.PackageVersions.InsertBatch(pkgs, p.Context, p.Client, func(packageName string) (string, string, error) {
+		cached, ok := lookups[packageName]
+		if !ok {
+			cached.latest, cached.eol, cached.err = queryEndOfLifeAPI(packageName, p.Context, p.Client)
+			lookups[packageName] = cached
+		}
+		return cached.latest, cached.eol, cached.err
+	}, ttl)
+
+	for i, pos := range positions {
+		if errs[i] != nil {
+			log.Printf("Failed to insert packages for %s: %v", ids[i], errs[i])
+			res.Items[pos].Error = "Failed to insert package data"
+			res.Items[pos].Code = errorCode(errs[i])
+			continue
+		}
+		id := ids[i]
+		res.Items[pos].ID = &id
+		p.afterInsert(id, pkgs[i], prevs[i], ttl)
+	}
+
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		writeError(w, r, err, "Failed to encode response")
+		return
+	}
+}
+
+// handleInsertSBOM stores the components of a CycloneDX or SPDX document as
+// the packages of the host or artifact named in the query string.
+func (p *PackageVersionsHandler) handleInsertSBOM(w http.ResponseWriter, r *http.Request) {
+	target := sbomTargetFromQuery(r.URL.Query())
+
+	body, err := requestBody(w, r, p.BatchLimits.or(DefaultBatchBodyLimits))
+	if err != nil {
+		bodyError(w, r, err, "Invalid request payload")
+		return
+	}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		bodyError(w, r, err, "Invalid request payload")
+		return
+	}
+	components, err := decodeSBOM(data)
+	if err == ErrUnsupportedSBOM {
+		writeError(w, r, err, "Expected a CycloneDX or SPDX JSON document")
 		return
 	}
 	if err != nil {
-		http.Error(w, "Failed to retrieve packages data", http.StatusInternalServerError)
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidPayload, "Invalid request payload")
 		return
 	}
 
-	err = json.NewEncoder(w).Encode(ResponseDocument{
-		ID:       pkg.IDPkg,
-		Packages: pkg.Packages,
-	})
+	components, skipped := storableComponents(components)
+	pkg := target.packageVersions(components)
+	violations := target.violations()
+	if target.Artifact == "" {
+		violations = append(violations, p.PackageVersions.identityViolations(pkg)...)
+	}
+	if len(violations) > 0 {
+		validationErrorResponse(w, r, violations)
+		return
+	}
+
+	ttl, err := requestTTL(r, p.TTL, p.MaxTTL)
+	if err != nil {
+		writeError(w, r, err, "Invalid x-keepup-ttl header")
+		return
+	}
+
+	prev := p.previous(pkg)
+
+	id, etag, err := p.PackageVersions.InsertIfMatch(pkg, p.Context, p.Client, func(packageName string) (string, string, error) {
+		product := pkg.Packages[packageName].EOLProduct
+		if product == "" {
+			return "", "", errNoEOLProduct
+		}
+		return queryEndOfLifeAPI(product, p.Context, p.Client)
+	}, ttl, r.Header.Get("If-Match"))
+
+	if err == ErrPreconditionFailed || err == ErrWriteConflict {
+		writeError(w, r, err, "The host record was changed by another writer")
+		return
+	}
 	if err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		log.Printf("Failed to insert SBOM: %v", err)
+		writeError(w, r, err, "Failed to insert package data")
+		return
+	}
+	p.afterInsert(id, pkg, prev, ttl)
+	w.Header().Set("ETag", etag)
+
+	if err := json.NewEncoder(w).Encode(SBOMResponseDocument{ID: id, Skipped: skipped}); err != nil {
+		writeError(w, r, err, "Failed to encode response")
 		return
 	}
 }
 
-func (s *PackageVersionsHandler) Handler() http.HandlerFunc {
-	return withAuth(s.ApiToken, map[string]http.HandlerFunc{
-		"GET": s.handleGetPackages,
-		"PUT": s.handleInsertPackages,
-	})
+// emptyPush reports whether a batch item carries neither host nor packages.
+func emptyPush(pkg PackageVersions) bool {
+	return pkg.DataCenterPkg == "" && pkg.HostIPPkg == "" && len(pkg.Packages) == 0
 }
 
-func (s *KubernetesClusterMiddleware) Handler() http.HandlerFunc {
-	return withAuth(s.ApiToken, map[string]http.HandlerFunc{
-		"GET": s.handleGetClusterByID,
-		"PUT": s.handleInsertCluster,
-	})
+// checkPackages validates a host push, including the identity fields the
+// configured HOST_IDENTITY_FIELDS require. A v2 document is validated as
+// sent, so that violations carry its paths.
+func (p *PackageVersionsHandler) checkPackages(data []byte, shape interface{}, pkg PackageVersions) []Violation {
+	var violations []Violation
+	if doc, ok := shape.(PackageDocumentV2); ok {
+		violations = append(checkPayload(data, doc, doc, p.Strict), doc.duplicates()...)
+	} else {
+		violations = checkPayload(data, shape, pkg, p.Strict)
+	}
+	return append(violations, p.PackageVersions.identityViolations(pkg)...)
 }
 
-func (s *KubernetesClusterMiddleware) handleInsertCluster(w http.ResponseWriter, r *http.Request) {
-	var cluster KubernetesCluster
-	r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
-	body, err := io.ReadAll(r.Body)
+// previous returns the currently stored record for the host when
+// notifications are enabled, so that transitions can be detected.
+func (p *PackageVersionsHandler) previous(pkg PackageVersions) *PackageVersions {
+	if !p.Notifier.Enabled() {
+		return nil
+	}
+	old, err := p.PackageVersions.Retrieve(p.PackageVersions.HostID(pkg), p.Context, p.Client)
 	if err != nil {
-		log.Println("Failed to read request body:", err)
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return nil
+	}
+	return &old
+}
+
+// afterInsert records the host in the last seen index, with the TTL it was
+// stored with, and raises expiry notifications for packages that changed
+// state since prev. Artifacts don't report on their own, so they are never
+// missing.
+func (p *PackageVersionsHandler) afterInsert(id uuid.UUID, pkg PackageVersions, prev *PackageVersions, ttl int) {
+	if pkg.Artifact == "" {
+		err := TouchLastSeen(p.Context, p.Client, SeenEntity{
+			Kind:       EntityKindHost,
+			ID:         id,
+			Name:       hostDisplayName(pkg),
+			Team:       pkg.Team,
+			DataCenter: pkg.DataCenterPkg,
+			LastSeen:   time.Now().Unix(),
+			TTLSeconds: ttl,
+		})
+		if err != nil {
+			log.Printf("Can't update last seen for %s: %v", id, err)
+		}
+	}
+
+	if !p.Notifier.Enabled() {
 		return
 	}
-	// for debug
-	//log.Printf("Received JSON: %s", string(body))
-	if err := json.Unmarshal(body, &cluster); err != nil {
-		log.Println("Failed to parse JSON:", err)
-		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+	cur, err := p.PackageVersions.Retrieve(id, p.Context, p.Client)
+	if err != nil {
+		log.Printf("Can't reload packages %s: %v", id, err)
 		return
 	}
+	p.Notifier.Notify(packageEvents(prev, cur, p.EOLWarning, time.Now())...)
+}
+
+func hostDisplayName(pkg PackageVersions) string {
+	if pkg.Hostname != "" {
+		return pkg.Hostname
+	}
+	return pkg.HostIPPkg
+}
 
-	id, err := s.Clusters.InsertClusterData(cluster, s.Context, s.Client, s.TTL)
+func (p *PackageVersionsHandler) handleGetPackages(w http.ResponseWriter, r *http.Request) {
+	id, err := lookupID(w, r, p.Limits.or(DefaultBodyLimits).Max)
 	if err != nil {
-		log.Println("Failed to insert cluster:", err)
-		http.Error(w, "Failed to store data", http.StatusInternalServerError)
+		bodyError(w, r, err, err.Error())
 		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(IDClusterDocument{ID: id})
-	//log.Printf("Cluster stored with ID: %s", id)
+	pkg, etag, err := p.PackageVersions.RetrieveWithETag(id, p.Context, p.Client)
+	if err == ErrIDNotFoundPackage {
+		writeError(w, r, err, "Packages data not found")
+		return
+	}
+	if err != nil {
+		writeError(w, r, err, "Failed to retrieve packages data")
+		return
+	}
+	if notModified(w, r, etag) {
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(ResponseDocument{
+		ID:       pkg.IDPkg,
+		Packages: pkg.Packages,
+		OS:       pkg.OS,
+		Sources:  pkg.SourceNames(),
+	})
+	if err != nil {
+		writeError(w, r, err, "Failed to encode response")
+		return
+	}
 }
 
-func (s *KubernetesClusterMiddleware) handleGetClusterByID(w http.ResponseWriter, r *http.Request) {
-	var req IDClusterDocument
+func (p *PackageVersionsHandler) handlePatchPackages(w http.ResponseWriter, r *http.Request) {
+	var req PackagePatchDocument
 
-	err := json.NewDecoder(r.Body).Decode(&req)
+	body, err := requestBody(w, r, p.Limits.or(DefaultBodyLimits))
 	if err != nil {
-		http.Error(w, "Invalid JSON request", http.StatusBadRequest)
+		bodyError(w, r, err, "Invalid request payload")
 		return
 	}
-
-	cluster, err := s.Clusters.RetrieveCluster(req.ID, s.Context, s.Client)
-	if err == ErrClusterNotFound {
-		http.Error(w, "Cluster not found", http.StatusNotFound)
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		bodyError(w, r, err, "Invalid request payload")
 		return
-	} else if err != nil {
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+	if err := json.Unmarshal(data, &req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidPayload, "Invalid request payload")
 		return
 	}
 
-	res := ClusterDocument{Cluster: cluster}
-	if err := json.NewEncoder(w).Encode(res); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	patch, shape := patchFromDocument(req)
+	if violations := p.checkPackages(data, PackageDocument{}, shape); len(violations) > 0 {
+		validationErrorResponse(w, r, violations)
+		return
+	}
+
+	ttl, err := requestTTL(r, p.TTL, p.MaxTTL)
+	if err != nil {
+		writeError(w, r, err, "Invalid x-keepup-ttl header")
 		return
 	}
+
+	prev := p.previous(shape)
+
+	pkg, etag, err := p.PackageVersions.Patch(patch, p.Context, p.Client, func(packageName string) (string, string, error) {
+		return queryEndOfLifeAPI(packageName, p.Context, p.Client)
+	}, ttl, r.Header.Get("If-Match"))
+
+	switch err {
+	case nil:
+	case ErrIDNotFoundPackage:
+		writeError(w, r, err, "Packages data not found")
+		return
+	case ErrPreconditionFailed, ErrWriteConflict:
+		writeError(w, r, err, "The host record was changed by another writer")
+		return
+	default:
+		log.Printf("Failed to patch packages: %v", err)
+		writeError(w, r, err, "Failed to update package data")
+		return
+	}
+	p.afterInsert(pkg.IDPkg, pkg, prev, ttl)
+	w.Header().Set("ETag", etag)
+
+	if err := json.NewEncoder(w).Encode(IDDocumentPackage{ID: pkg.IDPkg}); err != nil {
+		writeError(w, r, err, "Failed to encode response")
+		return
+	}
+}
+
+func (p *PackageVersionsHandler) handleDeletePackages(w http.ResponseWriter, r *http.Request) {
+	id, err := lookupID(w, r, p.Limits.or(DefaultBodyLimits).Max)
+	if err != nil {
+		bodyError(w, r, err, err.Error())
+		return
+	}
+
+	err = p.PackageVersions.Delete(id, p.Context, p.Client, r.Header.Get("If-Match"))
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case ErrIDNotFoundPackage:
+		writeError(w, r, err, "Packages data not found")
+	case ErrPreconditionFailed:
+		writeError(w, r, err, "The host record was changed by another writer")
+	default:
+		log.Printf("Failed to delete packages: %v", err)
+		writeError(w, r, err, "Failed to delete packages data")
+	}
+}
+
+func (s *PackageVersionsHandler) Handler() http.HandlerFunc {
+	return withAuth(s.ApiToken, map[string]http.HandlerFunc{
+		"GET":    s.handleGetPackages,
+		"PUT":    s.handleInsertPackages,
+		"PATCH":  s.handlePatchPackages,
+		"DELETE": s.handleDeletePackages,
+	})
+}
+
+func (s *PackageVersionsHandler) BatchHandler() http.HandlerFunc {
+	return withAuth(s.ApiToken, map[string]http.HandlerFunc{
+		"PUT": s.handleInsertPackagesBatch,
+	})
+}
+
+func (s *PackageVersionsHandler) SBOMHandler() http.HandlerFunc {
+	return withAuth(s.ApiToken, map[string]http.HandlerFunc{
+		"PUT": s.handleInsertSBOM,
+	})
+}
+
+func (s *KubernetesClusterMiddleware) Handler() http.HandlerFunc {
+	return withAuth(s.ApiToken, map[string]http.HandlerFunc{
+		"GET":    s.handleGetClusterByID,
+		"PUT":    s.handleInsertCluster,
+		"PATCH":  s.handlePatchCluster,
+		"DELETE": s.handleDeleteCluster,
+	})
+}
+
+func (s *KubernetesClusterMiddleware) handleInsertCluster(w http.ResponseWriter, r *http.Request) {
+	var cluster KubernetesCluster
+	reader, err := requestBody(w, r, s.Limits.or(DefaultBodyLimits))
+	if err != nil {
+		bodyError(w, r, err, "Invalid request")
+		return
+	}
+	defer reader.Close()
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		log.Println("Failed to read request body:", err)
+		bodyError(w, r, err, "Invalid request")
+		return
+	}
+	// for debug
+	//log.Printf("Received JSON: %s", string(body))
+	if err := json.Unmarshal(body, &cluster); err != nil {
+		log.Println("Failed to parse JSON:", err)
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidPayload, "Invalid JSON format")
+		return
+	}
+	violations := append(checkPayload(body, cluster, cluster, s.Strict), chartViolations(cluster.HelmCharts)...)
+	if len(violations) > 0 {
+		validationErrorResponse(w, r, violations)
+		return
+	}
+
+	ttl, err := requestTTL(r, s.TTL, s.MaxTTL)
+	if err != nil {
+		writeError(w, r, err, "Invalid x-keepup-ttl header")
+		return
+	}
+
+	id, etag, err := s.Clusters.InsertClusterDataIfMatch(cluster, s.Context, s.Client, ttl, r.Header.Get("If-Match"))
+	if err == ErrPreconditionFailed {
+		writeError(w, r, err, "The cluster record was changed by another writer")
+		return
+	}
+	if err != nil {
+		log.Println("Failed to insert cluster:", err)
+		writeError(w, r, err, "Failed to store data")
+		return
+	}
+	err = TouchLastSeen(s.Context, s.Client, SeenEntity{
+		Kind:       EntityKindCluster,
+		ID:         id,
+		Name:       cluster.ClusterName,
+		Team:       cluster.Team,
+		LastSeen:   time.Now().Unix(),
+		TTLSeconds: ttl,
+	})
+	if err != nil {
+		log.Printf("Can't update last seen for %s: %v", id, err)
+	}
+
+	w.Header().Set("ETag", etag)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(IDClusterDocument{ID: id})
+	//log.Printf("Cluster stored with ID: %s", id)
+}
+
+func (s *KubernetesClusterMiddleware) handlePatchCluster(w http.ResponseWriter, r *http.Request) {
+	var patch ClusterPatch
+	reader, err := requestBody(w, r, s.Limits.or(DefaultBodyLimits))
+	if err != nil {
+		bodyError(w, r, err, "Invalid request")
+		return
+	}
+	defer reader.Close()
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		bodyError(w, r, err, "Invalid request")
+		return
+	}
+	if err := json.Unmarshal(body, &patch); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidPayload, "Invalid JSON format")
+		return
+	}
+	violations := checkPayload(body, patch, patch, s.Strict)
+	keys := make([]string, 0, len(patch.HelmCharts))
+	for key := range patch.HelmCharts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if _, _, ok := SplitChartKey(key); !ok {
+			violations = append(violations, Violation{
+				Field:   joinPath("helm_charts", key),
+				Rule:    "chart_key",
+				Message: "must be <namespace>/<release_name>",
+			})
+		}
+	}
+	if len(violations) > 0 {
+		validationErrorResponse(w, r, violations)
+		return
+	}
+
+	ttl, err := requestTTL(r, s.TTL, s.MaxTTL)
+	if err != nil {
+		writeError(w, r, err, "Invalid x-keepup-ttl header")
+		return
+	}
+
+	cluster, etag, err := s.Clusters.PatchCluster(patch, s.Context, s.Client, ttl, r.Header.Get("If-Match"))
+	switch err {
+	case nil:
+	case ErrClusterNotFound:
+		writeError(w, r, err, "Cluster not found")
+		return
+	case ErrPreconditionFailed, ErrWriteConflict:
+		writeError(w, r, err, "The cluster record was changed by another writer")
+		return
+	default:
+		log.Println("Failed to patch cluster:", err)
+		writeError(w, r, err, "Failed to store data")
+		return
+	}
+	err = TouchLastSeen(s.Context, s.Client, SeenEntity{
+		Kind:       EntityKindCluster,
+		ID:         cluster.ID,
+		Name:       cluster.ClusterName,
+		Team:       cluster.Team,
+		LastSeen:   time.Now().Unix(),
+		TTLSeconds: ttl,
+	})
+	if err != nil {
+		log.Printf("Can't update last seen for %s: %v", cluster.ID, err)
+	}
+
+	w.Header().Set("ETag", etag)
+	json.NewEncoder(w).Encode(IDClusterDocument{ID: cluster.ID})
+}
+
+func (s *KubernetesClusterMiddleware) handleGetClusterByID(w http.ResponseWriter, r *http.Request) {
+	id, err := lookupID(w, r, s.Limits.or(DefaultBodyLimits).Max)
+	if err != nil {
+		bodyError(w, r, err, "Invalid JSON request")
+		return
+	}
+
+	cluster, etag, err := s.Clusters.RetrieveClusterWithETag(id, s.Context, s.Client)
+	if err == ErrClusterNotFound {
+		writeError(w, r, err, "Cluster not found")
+		return
+	} else if err != nil {
+		writeError(w, r, err, "Internal Server Error")
+		return
+	}
+	if notModified(w, r, etag) {
+		return
+	}
+
+	res := ClusterDocument{Cluster: cluster}
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		writeError(w, r, err, "Failed to encode response")
+		return
+	}
+}
+
+func (s *KubernetesClusterMiddleware) handleDeleteCluster(w http.ResponseWriter, r *http.Request) {
+	id, err := lookupID(w, r, s.Limits.or(DefaultBodyLimits).Max)
+	if err != nil {
+		bodyError(w, r, err, "Invalid JSON request")
+		return
+	}
+
+	err = s.Clusters.DeleteCluster(id, s.Context, s.Client, r.Header.Get("If-Match"))
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case ErrClusterNotFound:
+		writeError(w, r, err, "Cluster not found")
+	case ErrPreconditionFailed:
+		writeError(w, r, err, "The cluster record was changed by another writer")
+	default:
+		log.Println("Failed to delete cluster:", err)
+		writeError(w, r, err, "Failed to delete cluster")
+	}
+}
+
+func (s *ContainerImagesMiddleware) Handler() http.HandlerFunc {
+	return withAuth(s.ApiToken, map[string]http.HandlerFunc{
+		"GET":    s.handleGetImages,
+		"PUT":    s.handleInsertImages,
+		"DELETE": s.handleDeleteImages,
+	})
+}
+
+func (s *ContainerImagesMiddleware) handleInsertImages(w http.ResponseWriter, r *http.Request) {
+	var images ContainerImages
+	reader, err := requestBody(w, r, s.Limits.or(DefaultBodyLimits))
+	if err != nil {
+		bodyError(w, r, err, "Invalid request")
+		return
+	}
+	defer reader.Close()
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		bodyError(w, r, err, "Invalid request")
+		return
+	}
+	if err := json.Unmarshal(body, &images); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidPayload, "Invalid JSON format")
+		return
+	}
+	violations := append(checkPayload(body, images, images, s.Strict), imageViolations(images, s.Images.HostIdentity)...)
+	if len(violations) > 0 {
+		validationErrorResponse(w, r, violations)
+		return
+	}
+
+	ttl, err := requestTTL(r, s.TTL, s.MaxTTL)
+	if err != nil {
+		writeError(w, r, err, "Invalid x-keepup-ttl header")
+		return
+	}
+
+	id, etag, err := s.Images.InsertIfMatch(images, s.Context, s.Client, ttl, r.Header.Get("If-Match"))
+	if err == ErrPreconditionFailed {
+		writeError(w, r, err, "The container images were changed by another writer")
+		return
+	}
+	if err != nil {
+		log.Println("Failed to insert container images:", err)
+		writeError(w, r, err, "Failed to store data")
+		return
+	}
+
+	w.Header().Set("ETag", etag)
+	json.NewEncoder(w).Encode(IDContainerImagesDocument{ID: id})
+}
+
+func (s *ContainerImagesMiddleware) handleGetImages(w http.ResponseWriter, r *http.Request) {
+	id, err := lookupID(w, r, s.Limits.or(DefaultBodyLimits).Max)
+	if err != nil {
+		bodyError(w, r, err, "Invalid JSON request")
+		return
+	}
+
+	images, etag, err := s.Images.RetrieveWithETag(id, s.Context, s.Client)
+	if err == ErrImagesNotFound {
+		writeError(w, r, err, "Container images not found")
+		return
+	} else if err != nil {
+		writeError(w, r, err, "Internal Server Error")
+		return
+	}
+	if notModified(w, r, etag) {
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(ContainerImagesDocument{ContainerImages: images}); err != nil {
+		writeError(w, r, err, "Failed to encode response")
+		return
+	}
+}
+
+func (s *ContainerImagesMiddleware) handleDeleteImages(w http.ResponseWriter, r *http.Request) {
+	id, err := lookupID(w, r, s.Limits.or(DefaultBodyLimits).Max)
+	if err != nil {
+		bodyError(w, r, err, "Invalid JSON request")
+		return
+	}
+
+	err = s.Images.Delete(id, s.Context, s.Client, r.Header.Get("If-Match"))
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case ErrImagesNotFound:
+		writeError(w, r, err, "Container images not found")
+	case ErrPreconditionFailed:
+		writeError(w, r, err, "The container images were changed by another writer")
+	default:
+		log.Println("Failed to delete container images:", err)
+		writeError(w, r, err, "Failed to delete container images")
+	}
+}
+
+func (s *CertificatesMiddleware) Handler() http.HandlerFunc {
+	return withAuth(s.ApiToken, map[string]http.HandlerFunc{
+		"GET":    s.handleGetCertificates,
+		"PUT":    s.handleInsertCertificates,
+		"DELETE": s.handleDeleteCertificates,
+	})
+}
+
+func (s *CertificatesMiddleware) handleInsertCertificates(w http.ResponseWriter, r *http.Request) {
+	var certs Certificates
+	reader, err := requestBody(w, r, s.Limits.or(DefaultBodyLimits))
+	if err != nil {
+		bodyError(w, r, err, "Invalid request")
+		return
+	}
+	defer reader.Close()
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		bodyError(w, r, err, "Invalid request")
+		return
+	}
+	if err := json.Unmarshal(body, &certs); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidPayload, "Invalid JSON format")
+		return
+	}
+	violations := append(checkPayload(body, certs, certs, s.Strict), certificateViolations(certs, s.Certificates.HostIdentity)...)
+	if len(violations) > 0 {
+		validationErrorResponse(w, r, violations)
+		return
+	}
+
+	ttl, err := requestTTL(r, s.TTL, s.MaxTTL)
+	if err != nil {
+		writeError(w, r, err, "Invalid x-keepup-ttl header")
+		return
+	}
+
+	id, etag, err := s.Certificates.InsertIfMatch(certs, s.Context, s.Client, ttl, r.Header.Get("If-Match"))
+	if err == ErrPreconditionFailed {
+		writeError(w, r, err, "The certificates were changed by another writer")
+		return
+	}
+	if err != nil {
+		log.Println("Failed to insert certificates:", err)
+		writeError(w, r, err, "Failed to store data")
+		return
+	}
+
+	w.Header().Set("ETag", etag)
+	json.NewEncoder(w).Encode(IDCertificatesDocument{ID: id})
+}
+
+func (s *CertificatesMiddleware) handleGetCertificates(w http.ResponseWriter, r *http.Request) {
+	id, err := lookupID(w, r, s.Limits.or(DefaultBodyLimits).Max)
+	if err != nil {
+		bodyError(w, r, err, "Invalid JSON request")
+		return
+	}
+
+	certs, etag, err := s.Certificates.RetrieveWithETag(id, s.Context, s.Client)
+	if err == ErrCertificatesNotFound {
+		writeError(w, r, err, "Certificates not found")
+		return
+	} else if err != nil {
+		writeError(w, r, err, "Internal Server Error")
+		return
+	}
+	if notModified(w, r, etag) {
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(CertificatesDocument{Certificates: certs}); err != nil {
+		writeError(w, r, err, "Failed to encode response")
+		return
+	}
+}
+
+func (s *CertificatesMiddleware) handleDeleteCertificates(w http.ResponseWriter, r *http.Request) {
+	id, err := lookupID(w, r, s.Limits.or(DefaultBodyLimits).Max)
+	if err != nil {
+		bodyError(w, r, err, "Invalid JSON request")
+		return
+	}
+
+	err = s.Certificates.Delete(id, s.Context, s.Client, r.Header.Get("If-Match"))
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case ErrCertificatesNotFound:
+		writeError(w, r, err, "Certificates not found")
+	case ErrPreconditionFailed:
+		writeError(w, r, err, "The certificates were changed by another writer")
+	default:
+		log.Println("Failed to delete certificates:", err)
+		writeError(w, r, err, "Failed to delete certificates")
+	}
+}
+
+func (s *ApplicationDependenciesMiddleware) Handler() http.HandlerFunc {
+	return withAuth(s.ApiToken, map[string]http.HandlerFunc{
+		"GET":    s.handleGetDependencies,
+		"PUT":    s.handleInsertDependencies,
+		"DELETE": s.handleDeleteDependencies,
+	})
+}
+
+// handleInsertDependencies stores the runtime and frameworks of the manifest
+// in the body, as the section of the service named in the query string.
+func (s *ApplicationDependenciesMiddleware) handleInsertDependencies(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	target := ManifestTarget{
+		Service:     query.Get("service"),
+		Environment: query.Get("environment"),
+		Team:        query.Get("team"),
+		Manifest:    query.Get("manifest"),
+	}
+
+	reader, err := requestBody(w, r, s.Limits.or(DefaultBodyLimits))
+	if err != nil {
+		bodyError(w, r, err, "Invalid request")
+		return
+	}
+	defer reader.Close()
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		bodyError(w, r, err, "Invalid request")
+		return
+	}
+	if violations := target.violations(); len(violations) > 0 {
+		validationErrorResponse(w, r, violations)
+		return
+	}
+	format, _, _ := manifestFormat(target.Manifest)
+	dependencies, err := parseManifest(format, body)
+	if err != nil {
+		writeError(w, r, err, fmt.Sprintf("Invalid %s", format))
+		return
+	}
+
+	ttl, err := requestTTL(r, s.TTL, s.MaxTTL)
+	if err != nil {
+		writeError(w, r, err, "Invalid x-keepup-ttl header")
+		return
+	}
+
+	id, etag, err := s.Dependencies.InsertIfMatch(target, dependencies, s.Context, s.Client, func(product string) (string, string, error) {
+		return queryEndOfLifeAPI(product, s.Context, s.Client)
+	}, ttl, r.Header.Get("If-Match"))
+	if err == ErrPreconditionFailed || err == ErrWriteConflict {
+		writeError(w, r, err, "The application dependencies were changed by another writer")
+		return
+	}
+	if err != nil {
+		log.Println("Failed to insert application dependencies:", err)
+		writeError(w, r, err, "Failed to store data")
+		return
+	}
+
+	w.Header().Set("ETag", etag)
+	json.NewEncoder(w).Encode(IDApplicationDependenciesDocument{ID: id})
+}
+
+func (s *ApplicationDependenciesMiddleware) handleGetDependencies(w http.ResponseWriter, r *http.Request) {
+	id, err := lookupID(w, r, s.Limits.or(DefaultBodyLimits).Max)
+	if err != nil {
+		bodyError(w, r, err, "Invalid JSON request")
+		return
+	}
+
+	app, etag, err := s.Dependencies.RetrieveWithETag(id, s.Context, s.Client)
+	if err == ErrDependenciesNotFound {
+		writeError(w, r, err, "Application dependencies not found")
+		return
+	} else if err != nil {
+		writeError(w, r, err, "Internal Server Error")
+		return
+	}
+	if notModified(w, r, etag) {
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(ApplicationDependenciesDocument{ApplicationDependencies: app}); err != nil {
+		writeError(w, r, err, "Failed to encode response")
+		return
+	}
+}
+
+func (s *ApplicationDependenciesMiddleware) handleDeleteDependencies(w http.ResponseWriter, r *http.Request) {
+	id, err := lookupID(w, r, s.Limits.or(DefaultBodyLimits).Max)
+	if err != nil {
+		bodyError(w, r, err, "Invalid JSON request")
+		return
+	}
+
+	err = s.Dependencies.Delete(id, s.Context, s.Client, r.Header.Get("If-Match"))
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case ErrDependenciesNotFound:
+		writeError(w, r, err, "Application dependencies not found")
+	case ErrPreconditionFailed:
+		writeError(w, r, err, "The application dependencies were changed by another writer")
+	default:
+		log.Println("Failed to delete application dependencies:", err)
+		writeError(w, r, err, "Failed to delete application dependencies")
+	}
+}
+
+func (s *CustomDomainMiddleware) Handler() http.HandlerFunc {
+	return withAuth(s.ApiToken, map[string]http.HandlerFunc{
+		"GET":    s.handleGetRecord,
+		"PUT":    s.handleInsertRecord,
+		"DELETE": s.handleDeleteRecord,
+	})
+}
+
+func (s *CustomDomainMiddleware) handleInsertRecord(w http.ResponseWriter, r *http.Request) {
+	var doc map[string]any
+	reader, err := requestBody(w, r, s.Limits.or(DefaultBodyLimits))
+	if err != nil {
+		bodyError(w, r, err, "Invalid request")
+		return
+	}
+	defer reader.Close()
+	if err := json.NewDecoder(reader).Decode(&doc); err != nil || doc == nil {
+		bodyError(w, r, err, "Invalid JSON format")
+		return
+	}
+	if violations := s.Records.Domain.violations(doc); len(violations) > 0 {
+		validationErrorResponse(w, r, violations)
+		return
+	}
+
+	ttl, err := requestTTL(r, s.TTL, s.MaxTTL)
+	if err != nil {
+		writeError(w, r, err, "Invalid x-keepup-ttl header")
+		return
+	}
+
+	id, etag, err := s.Records.InsertIfMatch(doc, s.Context, s.Client, ttl, r.Header.Get("If-Match"))
+	if err == ErrPreconditionFailed {
+		writeError(w, r, err, "The record was changed by another writer")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to insert %s record: %v", s.Records.Domain.Name, err)
+		writeError(w, r, err, "Failed to store data")
+		return
+	}
+
+	w.Header().Set("ETag", etag)
+	json.NewEncoder(w).Encode(IDCustomRecordDocument{ID: id})
+}
+
+func (s *CustomDomainMiddleware) handleGetRecord(w http.ResponseWriter, r *http.Request) {
+	id, err := lookupID(w, r, s.Limits.or(DefaultBodyLimits).Max)
+	if err != nil {
+		bodyError(w, r, err, "Invalid JSON request")
+		return
+	}
+
+	record, etag, err := s.Records.RetrieveWithETag(id, s.Context, s.Client)
+	if err == ErrCustomRecordNotFound {
+		writeError(w, r, err, "Record not found")
+		return
+	} else if err != nil {
+		writeError(w, r, err, "Internal Server Error")
+		return
+	}
+	if notModified(w, r, etag) {
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(CustomRecordDocument{Record: record}); err != nil {
+		writeError(w, r, err, "Failed to encode response")
+		return
+	}
+}
+
+func (s *CustomDomainMiddleware) handleDeleteRecord(w http.ResponseWriter, r *http.Request) {
+	id, err := lookupID(w, r, s.Limits.or(DefaultBodyLimits).Max)
+	if err != nil {
+		bodyError(w, r, err, "Invalid JSON request")
+		return
+	}
+
+	err = s.Records.Delete(id, s.Context, s.Client, r.Header.Get("If-Match"))
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case ErrCustomRecordNotFound:
+		writeError(w, r, err, "Record not found")
+	case ErrPreconditionFailed:
+		writeError(w, r, err, "The record was changed by another writer")
+	default:
+		log.Printf("Failed to delete %s record: %v", s.Records.Domain.Name, err)
+		writeError(w, r, err, "Failed to delete record")
+	}
 }
@@ -0,0 +1,224 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestRequestTTL(t *testing.T) {
+	cases := []struct {
+		header  string
+		want    int
+		wantErr bool
+	}{
+		{header: "", want: 300},
+		{header: "7200", want: 7200},
+		{header: "999999", want: 86400},
+		{header: "0", wantErr: true},
+		{header: "soon", wantErr: true},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodPut, "/", nil)
+		if tc.header != "" {
+			req.Header.Set("x-keepup-ttl", tc.header)
+		}
+		got, err := requestTTL(req, 300, 86400)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("header %q: expected an error", tc.header)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("header %q: expected %d, got %d (err %v)", tc.header, tc.want, got, err)
+		}
+	}
+}
+
+func TestHandleInsertCluster_HonoursTTLHeader(t *testing.T) {
+	ctx := context.Background()
+	con := newTestClient(t)
+	s := &KubernetesClusterMiddleware{
+		Clusters: &KubernetesClusters{Items: make(map[uuid.UUID]KubernetesCluster)},
+		Client:   con,
+		Context:  ctx,
+		ApiToken: "secret",
+		TTL:      300,
+		MaxTTL:   86400,
+	}
+
+	req := httptest.NewRequest(http.MethodPut, "/helm-cluster", strings.NewReader(`{"cluster_name":"minikube","kube_version":"1.30"}`))
+	req.Header.Set("x-api-token", "secret")
+	req.Header.Set("x-keepup-ttl", "3600")
+	rec := httptest.NewRecorder()
+	s.Handler()(rec, req)
+
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	ttl, err := con.TTL(ctx, clusterRepository.Key(UUIDFromClusterName("minikube"))).Result()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ttl != time.Hour {
+		t.Errorf("expected the record to live for 1h, got %s", ttl)
+	}
+}
+
+func TestHandleInsertPackagesBatch_ReportsPerItemResults(t *testing.T) {
+	ctx := context.Background()
+	con := newTestClient(t)
+	seedEOLCache(t, con, `{"redis":[{"cycle":"7.2","eol":false,"latest":"7.2.4"}]}`)
+	p := &PackageVersionsHandler{
+		PackageVersions: &PackageVersionss{Items: make(map[uuid.UUID]PackageVersions)},
+		Client:          con,
+		Context:         ctx,
+		ApiToken:        "secret",
+		TTL:             300,
+	}
+
+	bodies := map[string]string{
+		"array": `[
+			{"packages": {"redis": "7.0.15", "host_ip": "10.0.0.1", "data_center": "dc1"}},
+			{"packages": "not-a-map"},
+			{"packages": {"redis": "6.2.1", "host_ip": "10.0.0.2", "data_center": "dc1"}}
+		]`,
+		"ndjson": `{"packages": {"redis": "7.0.15", "host_ip": "10.0.0.1", "data_center": "dc1"}}
+{"packages": "not-a-map"}
+{"packages": {"redis": "6.2.1", "host_ip": "10.0.0.2", "data_center": "dc1"}}
+`,
+	}
+	for name, body := range bodies {
+		req := httptest.NewRequest(http.MethodPut, "/package-versions/batch", strings.NewReader(body))
+		req.Header.Set("x-api-token", "secret")
+		rec := httptest.NewRecorder()
+		p.BatchHandler()(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected status 200, got %d: %s", name, rec.Code, rec.Body.String())
+		}
+		var res BatchResponseDocument
+		if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+			t.Fatalf("%s: unexpected error decoding response: %v", name, err)
+		}
+		if len(res.Items) != 3 {
+			t.Fatalf("%s: expected 3 results, got %d", name, len(res.Items))
+		}
+		if res.Items[0].ID == nil || *res.Items[0].ID != UUIDFromDcAndIPPackage("dc1", "10.0.0.1") {
+			t.Errorf("%s: expected the first host to be stored, got %+v", name, res.Items[0])
+		}
+		if res.Items[1].ID != nil || res.Items[1].Error == "" {
+			t.Errorf("%s: expected the malformed item to fail on its own, got %+v", name, res.Items[1])
+		}
+		if res.Items[2].ID == nil {
+			t.Errorf("%s: expected the third host to be stored despite the failed item, got %+v", name, res.Items[2])
+		}
+	}
+}
+
+func TestHandlePatchCluster_ValidatesAndMerges(t *testing.T) {
+	s := newTestClusterMiddleware(t, BodyLimits{})
+	patch := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPatch, "/helm-cluster", strings.NewReader(body))
+		req.Header.Set("x-api-token", "secret")
+		rec := httptest.NewRecorder()
+		s.Handler()(rec, req)
+		return rec
+	}
+
+	if rec := patch(`{"cluster_name":"minikube","helm_charts":{"cache/redis":{"version":"18.2.0"}}}`); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown cluster, got %d", rec.Code)
+	}
+	putCluster(s, "", []byte(`{"cluster_name":"minikube","kube_version":"1.30","helm_charts":[{"chart_name":"redis","version":"18.1.0","namespace":"cache"}]}`))
+
+	rec := patch(`{"cluster_name":"minikube","helm_charts":{"redis":{"version":"18.2.0"},"cache/redis":{}}}`)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d: %s", rec.Code, rec.Body.String())
+	}
+	fields := map[string]bool{}
+	for _, v := range decodeProblem(t, rec).Violations {
+		fields[v.Field] = true
+	}
+	if !fields["helm_charts.redis"] || !fields["helm_charts.cache/redis.version"] {
+		t.Errorf("expected violations for the bad key and the missing version, got %v", fields)
+	}
+
+	rec = patch(`{"cluster_name":"minikube","helm_charts":{"cache/redis":null,"db/postgresql":{"version":"15.5.0"}}}`)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") == "" {
+		t.Fatalf("expected 200 with an ETag, got %d: %s", rec.Code, rec.Body.String())
+	}
+	stored, _ := s.Clusters.RetrieveCluster(UUIDFromClusterName("minikube"), s.Context, s.Client)
+	if len(stored.HelmCharts) != 1 || stored.HelmCharts[0].ChartName != "postgresql" || stored.KubeVersion != "1.30" {
+		t.Errorf("unexpected merged cluster %+v", stored)
+	}
+}
+
+func TestHandleInsertPackages_AcceptsV2Document(t *testing.T) {
+	ctx := context.Background()
+	con := newTestClient(t)
+	seedEOLCache(t, con, `{"redis":[{"cycle":"7.2","eol":false,"latest":"7.2.4"}],"nginx":[{"cycle":"1.25","eol":false,"latest":"1.25.5"}]}`)
+	p := &PackageVersionsHandler{
+		PackageVersions: &PackageVersionss{Items: make(map[uuid.UUID]PackageVersions)},
+		Client:          con,
+		Context:         ctx,
+		ApiToken:        "secret",
+		TTL:             300,
+	}
+	put := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/package-version", strings.NewReader(body))
+		req.Header.Set("x-api-token", "secret")
+		rec := httptest.NewRecorder()
+		p.Handler()(rec, req)
+		return rec
+	}
+
+	rec := put(`{
+		"host": {"data_center": "dc1", "host_ip": "10.0.0.1", "hostname": "web-1", "os": {"id": "debian", "version": "12"}},
+		"source": "dpkg",
+		"packages": [
+			{"name": "redis", "version": "7.0.15", "arch": "amd64"},
+			{"name": "nginx", "version": "1.25.3", "source": "containerd"}
+		]
+	}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	stored, err := p.PackageVersions.Retrieve(UUIDFromDcAndIPPackage("dc1", "10.0.0.1"), ctx, con)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(stored.Packages) != 2 || stored.Hostname != "web-1" {
+		t.Fatalf("expected two packages and the hostname, got %+v", stored)
+	}
+	if redis := stored.Packages["redis"]; redis.Source != "dpkg" || redis.Arch != "amd64" {
+		t.Errorf("expected redis from dpkg for amd64, got %+v", redis)
+	}
+	if stored.Packages["nginx"].Source != "containerd" {
+		t.Errorf("expected the item source to override the document source, got %+v", stored.Packages["nginx"])
+	}
+
+	rec = put(`{
+		"host": {"data_center": "dc1"},
+		"packages": [{"name": "redis", "version": "7.0.15"}, {"name": "redis", "version": ""}]
+	}`)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected status 422, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var problem Problem
+	json.NewDecoder(rec.Body).Decode(&problem)
+	fields := make(map[string]string)
+	for _, v := range problem.Violations {
+		fields[v.Field] = v.Rule
+	}
+	if fields["host.host_ip"] != "required" || fields["packages[1].version"] != "required" || fields["packages[1].name"] != "duplicate" {
+		t.Errorf("expected violations with v2 paths, got %+v", problem.Violations)
+	}
+}
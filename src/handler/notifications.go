@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"fmt"
+	"keepup/src/notify"
+	"strconv"
+	"time"
+)
+
+const eolDateLayout = "2006-01-02"
+
+// packageEvents compares the previously stored record of a host with the one
+// just written and returns the expiry transitions between them. prev is nil
+// when the host had no record yet.
+func packageEvents(prev *PackageVersions, cur PackageVersions, window time.Duration, now time.Time) []notify.Event {
+	var events []notify.Event
+
+	prevSeen := now
+	if prev != nil {
+		if ts, err := strconv.ParseInt(prev.UpdatedAt, 10, 64); err == nil {
+			prevSeen = time.Unix(ts, 0)
+		}
+	}
+
+	for name, detail := range cur.Packages {
+		var before *PackageDetail
+		if prev != nil {
+			if d, ok := prev.Packages[name]; ok {
+				before = &d
+			}
+		}
+
+		if detail.Expired && (before == nil || !before.Expired) {
+			events = append(events, packageEvent(notify.EventPackageExpired, cur, name, detail, now,
+				fmt.Sprintf("%s %s on %s (%s, team %s) is expired, newest is %s",
+					name, detail.CurrentVersion, cur.HostIPPkg, cur.DataCenterPkg, cur.Team, detail.NewestVersion)))
+		}
+
+		if eolWithin(detail, now, window) && (before == nil || !eolWithin(*before, prevSeen, window)) {
+			events = append(events, packageEvent(notify.EventPackageEOLApproaching, cur, name, detail, now,
+				fmt.Sprintf("%s %s on %s (%s, team %s) reaches end of life on %s",
+					name, detail.CurrentVersion, cur.HostIPPkg, cur.DataCenterPkg, cur.Team, detail.CurrentVersionEoF)))
+		}
+	}
+	return events
+}
+
+func packageEvent(kind notify.EventKind, pkg PackageVersions, name string, detail PackageDetail, now time.Time, message string) notify.Event {
+	return notify.Event{
+		Kind:       kind,
+		EntityKind: EntityKindHost,
+		EntityID:   pkg.IDPkg,
+		Name:       pkg.HostIPPkg,
+		Team:       pkg.Team,
+		DataCenter: pkg.DataCenterPkg,
+		Package:    name,
+		Version:    detail.CurrentVersion,
+		EOLDate:    detail.CurrentVersionEoF,
+		Message:    message,
+		OccurredAt: now.UTC(),
+	}
+}
+
+// eolWithin reports whether the package's EOL date lies in (at, at+window].
+// Dates that are already past are covered by the expired event instead.
+func eolWithin(detail PackageDetail, at time.Time, window time.Duration) bool {
+	eol, err := time.Parse(eolDateLayout, detail.CurrentVersionEoF)
+	if err != nil {
+		return false
+	}
+	return eol.After(at) && !eol.After(at.Add(window))
+}
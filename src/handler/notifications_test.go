@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"fmt"
+	"keepup/src/notify"
+	"testing"
+	"time"
+)
+
+func TestPackageEvents_ExpiredTransition(t *testing.T) {
+	now := time.Now()
+	prev := PackageVersions{Packages: map[string]PackageDetail{"redis": {CurrentVersion: "6.0"}}}
+	cur := PackageVersions{Packages: map[string]PackageDetail{"redis": {CurrentVersion: "6.0", Expired: true}}}
+
+	events := packageEvents(&prev, cur, 0, now)
+	if len(events) != 1 || events[0].Kind != notify.EventPackageExpired {
+		t.Fatalf("expected a single package_expired event, got %+v", events)
+	}
+
+	if events := packageEvents(&cur, cur, 0, now); len(events) != 0 {
+		t.Errorf("expected no event when the package was already expired, got %+v", events)
+	}
+}
+
+func TestPackageEvents_EOLApproachingOnlyWhenEnteringWindow(t *testing.T) {
+	now := time.Now()
+	window := 30 * 24 * time.Hour
+	eol := now.Add(10 * 24 * time.Hour).Format(eolDateLayout)
+	detail := PackageDetail{CurrentVersion: "7.0", CurrentVersionEoF: eol}
+
+	// Last push was 60 days ago, when the EOL date was still outside the window.
+	prev := PackageVersions{
+		UpdatedAt: fmt.Sprint(now.Add(-60 * 24 * time.Hour).Unix()),
+		Packages:  map[string]PackageDetail{"redis": detail},
+	}
+	cur := PackageVersions{Packages: map[string]PackageDetail{"redis": detail}}
+
+	events := packageEvents(&prev, cur, window, now)
+	if len(events) != 1 || events[0].Kind != notify.EventPackageEOLApproaching {
+		t.Fatalf("expected a single package_eol_approaching event, got %+v", events)
+	}
+
+	prev.UpdatedAt = fmt.Sprint(now.Add(-time.Hour).Unix())
+	if events := packageEvents(&prev, cur, window, now); len(events) != 0 {
+		t.Errorf("expected no event when the package was already inside the window, got %+v", events)
+	}
+}
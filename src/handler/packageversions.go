@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -15,26 +16,46 @@ import (
 	"github.com/redis/go-redis/v9"
 )
 
-const UUIDSuffix = "PACKAGE_UUID"
+const (
+	UUIDSuffix    = "PACKAGE_UUID"
+	hostKeyPrefix = "keepup:host:"
+)
 
 type PackageDetail struct {
-	CurrentVersion    string `json:"current_version"`
+	CurrentVersion    string `json:"current_version" validate:"max=128"`
 	CurrentVersionEoF string `json:"current_version_eof"`
 	NewestVersion     string `json:"newest_version"`
 	Expired           bool   `json:"expired"`
+	Source            string `json:"source,omitempty"`
+	Arch              string `json:"arch,omitempty"`
+	Purl              string `json:"purl,omitempty"`
+	Type              string `json:"type,omitempty"`
+	EOLProduct        string `json:"eol_product,omitempty"` // SBOM components only
 }
 
 type PackageVersions struct {
-	IDPkg         uuid.UUID                `json:"id"`
-	DataCenterPkg string                   `json:"data_center"`
-	HostIPPkg     string                   `json:"host_ip"`
-	Team          string                   `json:"team"`
-	UpdatedAt     string                   `json:"updated_at"`
-	Packages      map[string]PackageDetail `json:"packages"`
+	IDPkg         uuid.UUID                 `json:"id"`
+	DataCenterPkg string                    `json:"data_center" validate:"required,max=64,label"`
+	HostIPPkg     string                    `json:"host_ip" validate:"required,ip"`
+	Team          string                    `json:"team" validate:"max=64,label"`
+	Hostname      string                    `json:"hostname,omitempty" validate:"max=253,label"`
+	MachineID     string                    `json:"machine_id,omitempty" validate:"max=64,label"`
+	Artifact      string                    `json:"artifact,omitempty" validate:"max=255,label"` // SBOMs of artifacts only
+	Identity      map[string]string         `json:"identity,omitempty" validate_keys:"max=64,label"`
+	Labels        map[string]string         `json:"labels,omitempty" validate_keys:"max=64"`
+	UpdatedAt     string                    `json:"updated_at"`
+	Packages      map[string]PackageDetail  `json:"packages" validate_keys:"max=128,label"`
+	OS            *HostOS                   `json:"os,omitempty"`
+	Source        string                    `json:"source,omitempty" validate:"max=64,label"` // set on pushes only
+	Sources       map[string]SourcePackages `json:"sources,omitempty"`
 }
 
 type PackageVersionss struct {
-	Items map[uuid.UUID]PackageVersions
+	Items    map[uuid.UUID]PackageVersions
+	Identity []string // HOST_IDENTITY_FIELDS, DefaultHostIdentity when empty
+	// EOLEntries looks up the release cycles of an endoflife.date product;
+	// the host OS is not enriched when it is nil. See QueryEOLEntries.
+	EOLEntries func(ctx context.Context, con *redis.Client, product string) ([]EndOfLifeEntry, error)
 }
 
 type EOL string
@@ -70,8 +91,23 @@ var (
 	ErrInsertFailedPackage  = errors.New("Insert failed")
 	ErrMarshalFailedPackage = errors.New("Marshal failed")
 	ErrIDNotFoundPackage    = errors.New("ID not found")
+	ErrDeleteFailedPackage  = errors.New("Delete failed")
 )
 
+// hostRepository stores host records. The ID depends on
+// HOST_IDENTITY_FIELDS and is assigned by prepare or HostID.
+var hostRepository = Repository[PackageVersions]{
+	Name:     "hosts",
+	Prefix:   hostKeyPrefix,
+	Identity: func(pkg PackageVersions) uuid.UUID { return pkg.IDPkg },
+	Errors: RepositoryErrors{
+		NotFound:      ErrIDNotFoundPackage,
+		InsertFailed:  ErrInsertFailedPackage,
+		MarshalFailed: ErrMarshalFailedPackage,
+		DeleteFailed:  ErrDeleteFailedPackage,
+	},
+}
+
 func (c *PackageVersionss) Insert(
 	pkg PackageVersions,
 	ctx context.Context,
@@ -79,116 +115,323 @@ func (c *PackageVersionss) Insert(
 	queryFunc func(string) (string, string, error),
 	ttl int,
 ) (uuid.UUID, error) {
+	id, _, err := c.InsertIfMatch(pkg, ctx, con, queryFunc, ttl, "")
+	return id, err
+}
 
-	updatedPackages := make(map[string]PackageDetail)
+// InsertIfMatch is Insert guarded by an If-Match header value ("" for an
+// unconditional write). The push replaces the package set of its source
+// only; the packages of other sources are kept. It also returns the ETag of
+// the stored record.
+func (c *PackageVersionss) InsertIfMatch(
+	pkg PackageVersions,
+	ctx context.Context,
+	con *redis.Client,
+	queryFunc func(string) (string, string, error),
+	ttl int,
+	ifMatch string,
+) (uuid.UUID, string, error) {
+
+	pkg = c.prepare(pkg, ctx, con, queryFunc)
+	etag, err := hostRepository.Update(pkg.IDPkg, ctx, con, ttl, ifMatch, true, func(current []byte) ([]byte, error) {
+		_, data, err := mergeSource(current, pkg, ttl, time.Now())
+		return data, err
+	})
+	if err != nil {
+		return pkg.IDPkg, "", err
+	}
+	log.Printf("Creating %s (source %s): OK", pkg.IDPkg, sourceName(pkg.Source))
+	return pkg.IDPkg, etag, nil
+}
 
-	for name, versionDetail := range pkg.Packages {
-		if versionDetail.CurrentVersion == "unknown" || versionDetail.CurrentVersion == "" {
-			continue
+// InsertBatch enriches every host like Insert does and merges them all in a
+// single Redis transaction over the affected keys. The returned slices are
+// parallel to pkgs; a host that fails to serialize does not affect the
+// others.
+func (c *PackageVersionss) InsertBatch(
+	pkgs []PackageVersions,
+	ctx context.Context,
+	con *redis.Client,
+	queryFunc func(string) (string, string, error),
+	ttl int,
+) ([]uuid.UUID, []error) {
+
+	ids := make([]uuid.UUID, len(pkgs))
+	errs := make([]error, len(pkgs))
+	prepared := make([]PackageVersions, len(pkgs))
+	var keys []string
+	// Hosts in one batch mostly run the same OS.
+	batch := *c
+	batch.EOLEntries = memoizeEOLEntries(c.EOLEntries)
+	for i, pkg := range pkgs {
+		prepared[i] = batch.prepare(pkg, ctx, con, queryFunc)
+		ids[i] = prepared[i].IDPkg
+		keys = append(keys, hostRepository.Key(ids[i]))
+	}
+
+	write := func(tx *redis.Tx) error {
+		reads := make(map[string]*redis.StringCmd)
+		_, err := tx.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, key := range keys {
+				reads[key] = pipe.Get(ctx, key)
+			}
+			return nil
+		})
+		if err != nil && err != redis.Nil {
+			return err
 		}
 
-		currentVersion := extractMajorMinor(versionDetail.CurrentVersion)
-		latestVersion, eolDate, err := queryFunc(name)
-		if err != nil {
-			latestVersion = "unknown"
-		} else {
-			latestVersion = extractMajorMinor(latestVersion)
+		records := make(map[string][]byte)
+		for key, cmd := range reads {
+			if data, err := cmd.Bytes(); err == nil {
+				records[key] = data
+			}
+		}
+		now := time.Now()
+		for i, pkg := range prepared {
+			_, data, err := mergeSource(records[keys[i]], pkg, ttl, now)
+			errs[i] = err
+			if err == nil {
+				records[keys[i]] = data
+			}
 		}
 
-		if eolDate == "" {
-			eolDate = "false"
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, key := range keys {
+				if errs[i] == nil {
+					pipe.Set(ctx, key, records[key], time.Duration(ttl)*time.Second)
+				}
+			}
+			return nil
+		})
+		return err
+	}
+
+	var err error
+	for attempt := 1; attempt <= updateRetries; attempt++ {
+		if err = con.Watch(ctx, write, keys...); err != redis.TxFailedErr {
+			break
+		}
+	}
+	if err != nil {
+		log.Printf("Batch insert transaction failed: %v", err)
+		failed := ErrInsertFailedPackage
+		if err == redis.TxFailedErr {
+			failed = ErrWriteConflict
+		}
+		for i := range errs {
+			if errs[i] == nil {
+				errs[i] = failed
+			}
 		}
+	}
+	log.Printf("Creating %d hosts in batch", len(pkgs))
+	return ids, errs
+}
 
-		expired := isVersionExpired(currentVersion, latestVersion)
+// prepare enriches pkg with EOL data and assigns its ID.
+func (c *PackageVersionss) prepare(pkg PackageVersions, ctx context.Context, con *redis.Client, queryFunc func(string) (string, string, error)) PackageVersions {
+	updatedPackages := make(map[string]PackageDetail)
 
-		updatedPackages[name] = PackageDetail{
-			CurrentVersion:    currentVersion,
-			CurrentVersionEoF: eolDate,
-			NewestVersion:     latestVersion,
-			Expired:           expired,
+	for name, versionDetail := range pkg.Packages {
+		if !knownVersion(versionDetail.CurrentVersion) {
+			continue
 		}
+		detail := enrichPackage(name, versionDetail.CurrentVersion, queryFunc)
+		detail.Source = versionDetail.Source
+		detail.Arch = versionDetail.Arch
+		detail.Purl = versionDetail.Purl
+		detail.Type = versionDetail.Type
+		detail.EOLProduct = versionDetail.EOLProduct
+		updatedPackages[name] = detail
 	}
 
 	pkg.Packages = updatedPackages
-	pkg.IDPkg = UUIDFromDcAndIPPackage(pkg.DataCenterPkg, pkg.HostIPPkg)
-	pkg.UpdatedAt = fmt.Sprint(time.Now().Unix())
+	pkg.IDPkg = c.HostID(pkg)
+	c.enrichOS(ctx, con, pkg.OS)
+	return pkg
+}
 
-	data, err := json.Marshal(pkg)
-	if err != nil {
-		return pkg.IDPkg, ErrMarshalFailedPackage
-	}
+func knownVersion(version string) bool {
+	return version != "unknown" && version != ""
+}
 
-	var result string
-	result, err = con.Set(ctx, fmt.Sprint(pkg.IDPkg), data, time.Duration(ttl)*time.Second).Result()
+func enrichPackage(name string, version string, queryFunc func(string) (string, string, error)) PackageDetail {
+	currentVersion := extractMajorMinor(version)
+	latestVersion, eolDate, err := queryFunc(name)
 	if err != nil {
-		return pkg.IDPkg, ErrInsertFailedPackage
+		latestVersion = "unknown"
+	} else {
+		latestVersion = extractMajorMinor(latestVersion)
 	}
-	log.Printf("Creating %s: %s", pkg.IDPkg, result)
-	return pkg.IDPkg, nil
-}
 
-func (c *PackageVersionss) Retrieve(id uuid.UUID, ctx context.Context, con *redis.Client) (PackageVersions, error) {
-	result, err := con.Get(ctx, fmt.Sprint(id)).Result()
-	if err != nil {
-		return PackageVersions{}, ErrIDNotFoundPackage
+	if eolDate == "" {
+		eolDate = "false"
 	}
 
-	pkg := PackageVersions{}
-	err = json.Unmarshal([]byte(result), &pkg)
-	if err != nil {
-		return PackageVersions{}, ErrMarshalFailedPackage
+	return PackageDetail{
+		CurrentVersion:    currentVersion,
+		CurrentVersionEoF: eolDate,
+		NewestVersion:     latestVersion,
+		Expired:           isVersionExpired(currentVersion, latestVersion),
 	}
-	return pkg, nil
 }
 
-func (c *PackageVersionss) Scan(ctx context.Context, con *redis.Client) (PackageVersionss, error) {
-	pkgs := PackageVersionss{
-		Items: make(map[uuid.UUID]PackageVersions),
-	}
+// PackagePatch is a merge patch for the package set of one source of an
+// existing host record. A package mapped to nil (or to an unknown version)
+// is removed; any other is added or updated. Team and OS are only changed
+// when set, labels are merged like packages.
+type PackagePatch struct {
+	DataCenter string
+	HostIP     string
+	Hostname   string
+	MachineID  string
+	Identity   map[string]string
+	Labels     map[string]*string
+	Team       *string
+	OS         *HostOS
+	Source     string
+	Packages   map[string]*string
+}
 
-	var uids []uuid.UUID
-	var keys []string
-	iter := con.Scan(ctx, 0, "*", 0).Iterator()
-	for iter.Next(ctx) {
-		uid, err := uuid.Parse(iter.Val())
-		if err != nil {
-			continue
-		}
-		uids = append(uids, uid)
-		keys = append(keys, iter.Val())
-	}
-	if err := iter.Err(); err != nil {
-		log.Printf("Error scanning Redis keys: %v", err)
-		return pkgs, err
-	}
-	if len(keys) == 0 {
-		return pkgs, nil
+// Patch applies patch to the stored host record, guarded by an If-Match
+// header value ("" for none). Only packages whose version changed are
+// enriched again. It returns the merged record and its ETag.
+func (c *PackageVersionss) Patch(
+	patch PackagePatch,
+	ctx context.Context,
+	con *redis.Client,
+	queryFunc func(string) (string, string, error),
+	ttl int,
+	ifMatch string,
+) (PackageVersions, string, error) {
+
+	var pkg PackageVersions
+	id := c.HostID(PackageVersions{
+		DataCenterPkg: patch.DataCenter,
+		HostIPPkg:     patch.HostIP,
+		Hostname:      patch.Hostname,
+		MachineID:     patch.MachineID,
+		Identity:      patch.Identity,
+	})
+
+	// The lookups may go over the network, so they are done before the
+	// transaction, against the record as it is now.
+	current, err := con.Get(ctx, hostRepository.Key(id)).Bytes()
+	if err != nil {
+		return PackageVersions{}, "", ErrIDNotFoundPackage
 	}
-
-	values, err := con.MGet(ctx, keys...).Result()
+	stored, err := decodeHostRecord(current)
 	if err != nil {
-		log.Printf("Error fetching Redis keys: %v", err)
-		return pkgs, err
+		return PackageVersions{}, "", err
+	}
+	var hostOS *HostOS
+	if patch.OS != nil {
+		enrichedOS := *patch.OS
+		c.enrichOS(ctx, con, &enrichedOS)
+		hostOS = &enrichedOS
 	}
-	for i, val := range values {
-		if val == nil {
-			// Key expired between SCAN and MGET.
+	source := sourceName(patch.Source)
+	enriched := make(map[string]PackageDetail)
+	for name, version := range patch.Packages {
+		if version == nil || !knownVersion(*version) {
 			continue
 		}
-		str, ok := val.(string)
-		if !ok {
-			log.Printf("Unexpected value type for key %s", keys[i])
+		old, ok := stored.Sources[source].Packages[name]
+		if ok && old.CurrentVersion == extractMajorMinor(*version) {
+			enriched[name] = old
 			continue
 		}
-		var pkg PackageVersions
-		if err := json.Unmarshal([]byte(str), &pkg); err != nil {
-			log.Printf("Can't unmarshal package %s: %v", keys[i], err)
+		if !ok || old.EOLProduct == "" && old.Purl == "" {
+			enriched[name] = enrichPackage(name, *version, queryFunc)
 			continue
 		}
-		pkgs.Items[uids[i]] = pkg
+		// A package from an SBOM is looked up as the product its purl
+		// named, or not at all.
+		detail := enrichPackage(name, *version, func(string) (string, string, error) {
+			if old.EOLProduct == "" {
+				return "", "", errNoEOLProduct
+			}
+			return queryFunc(old.EOLProduct)
+		})
+		detail.EOLProduct, detail.Purl, detail.Type = old.EOLProduct, old.Purl, old.Type
+		enriched[name] = detail
+	}
+
+	etag, err := hostRepository.Update(id, ctx, con, ttl, ifMatch, false, func(current []byte) ([]byte, error) {
+		var err error
+		if pkg, err = decodeHostRecord(current); err != nil {
+			return nil, err
+		}
+		if patch.Team != nil {
+			pkg.Team = *patch.Team
+		}
+		if patch.Hostname != "" {
+			pkg.Hostname = patch.Hostname
+		}
+		if patch.MachineID != "" {
+			pkg.MachineID = patch.MachineID
+		}
+		pkg.Labels = mergeLabels(pkg.Labels, patch.Labels)
+		if hostOS != nil {
+			pkg.OS = hostOS
+		}
+
+		updatedAt := fmt.Sprint(time.Now().Unix())
+		packages := make(map[string]PackageDetail)
+		for name, detail := range pkg.Sources[source].Packages {
+			packages[name] = detail
+		}
+		for name, version := range patch.Packages {
+			detail, ok := enriched[name]
+			if !ok {
+				delete(packages, name)
+				continue
+			}
+			if old, ok := packages[name]; ok && old.CurrentVersion == extractMajorMinor(*version) {
+				continue
+			}
+			packages[name] = detail
+		}
+		pkg.Sources[source] = SourcePackages{Packages: packages, UpdatedAt: updatedAt}
+
+		var data []byte
+		pkg, data, err = finishHostRecord(pkg, source, updatedAt)
+		return data, err
+	})
+
+	if err != nil {
+		return PackageVersions{}, "", err
+	}
+	log.Printf("Patched %s: %d packages changed", id, len(patch.Packages))
+	return pkg, etag, nil
+}
+
+func (c *PackageVersionss) Retrieve(id uuid.UUID, ctx context.Context, con *redis.Client) (PackageVersions, error) {
+	pkg, _, err := c.RetrieveWithETag(id, ctx, con)
+	return pkg, err
+}
+
+func (c *PackageVersionss) RetrieveWithETag(id uuid.UUID, ctx context.Context, con *redis.Client) (PackageVersions, string, error) {
+	return hostRepository.Get(id, ctx, con)
+}
+
+// Delete removes a host record and its last seen entry, guarded by an
+// If-Match header value ("" for an unconditional delete).
+func (c *PackageVersionss) Delete(id uuid.UUID, ctx context.Context, con *redis.Client, ifMatch string) error {
+	if err := hostRepository.Delete(id, ctx, con, ifMatch); err != nil {
+		return err
 	}
+	if err := ForgetLastSeen(ctx, con, EntityKindHost, id); err != nil {
+		log.Printf("Can't remove last seen entry for %s: %v", id, err)
+	}
+	log.Printf("Deleted %s", id)
+	return nil
+}
 
-	return pkgs, nil
+func (c *PackageVersionss) Scan(ctx context.Context, con *redis.Client) (PackageVersionss, error) {
+	items, err := hostRepository.Scan(ctx, con)
+	return PackageVersionss{Items: items}, err
 }
 
 func UUIDFromDcAndIPPackage(dc string, ip string) uuid.UUID {
@@ -208,16 +451,25 @@ func extractMajorMinor(version string) string {
 	return segments[0]
 }
 
+// releaseCycles returns the endoflife.date release cycles a version such as
+// 6.2, 13-alpine, 22.04 or 6.1.0-18-amd64 may belong to, most specific
+// first. Versions that don't start with a digit, like latest, have none.
+func releaseCycles(version string) []string {
+	version, _, _ = strings.Cut(strings.TrimPrefix(version, "v"), "-")
+	if version == "" || version[0] < '0' || version[0] > '9' {
+		return nil
+	}
+	segments := strings.Split(version, ".")
+	if len(segments) == 1 {
+		return segments
+	}
+	return []string{segments[0] + "." + segments[1], segments[0]}
+}
+
 func queryEndOfLifeAPI(packageName string, ctx context.Context, con *redis.Client) (string, string, error) {
-	response, err := getEOLData(ctx, con, packageName)
+	response, err := QueryEOLEntries(ctx, con, packageName)
 	if err != nil {
-		if err := updateEOLCache(ctx, con); err != nil {
-			return "", "", fmt.Errorf("failed to update cache: %w", err)
-		}
-		response, err = getEOLData(ctx, con, packageName)
-		if err != nil {
-			return "", "", fmt.Errorf("failed to retrieve updated cache for %s: %w", packageName, err)
-		}
+		return "", "", err
 	}
 
 	latestVersion := "unknown"
@@ -239,41 +491,85 @@ func queryEndOfLifeAPI(packageName string, ctx context.Context, con *redis.Clien
 	return latestVersion, eolDate, nil
 }
 
-func updateEOLCache(ctx context.Context, con *redis.Client) error {
-	key := "eol_cache:all_packages"
-	ttl := 7 * 24 * time.Hour
-	//TODO: Handle all related packages.
-	//Option 1: Get all data from endoflife and store in redis.
-	//Option 2: Dynamicly resolve pacakge names, but should be checked fro eof api side.
-	supportedPackages := []string{"redis", "memcached", "mongodb", "mysql", "rabbitmq", "envoy", "debian", "postgresql", "elasticsearch", "php", "gitlab-runner", "linux"}
+const (
+	eolCacheKey = "eol_cache:all_packages"
+	eolCacheTTL = 7 * 24 * time.Hour
+	// eolFetchTimeout bounds how long a push waits for endoflife.date when
+	// the cache lacks a product.
+	eolFetchTimeout = 5 * time.Second
+)
 
-	cacheDocument := map[string]interface{}{
-		"package": map[string][]EndOfLifeEntry{},
+// eolAPIURL is the endoflife.date API URL of a product.
+var eolAPIURL = "https://endoflife.date/api/%s.json"
+
+// QueryEOLEntries returns the endoflife.date release cycles of product from
+// the cache. When the cache lacks a supported product, that product alone is
+// fetched within eolFetchTimeout and merged into the cache; other products
+// miss right away.
+func QueryEOLEntries(ctx context.Context, con *redis.Client, product string) ([]EndOfLifeEntry, error) {
+	response, err := getEOLData(ctx, con, product)
+	if err == nil || !slices.Contains(supportedPackages, product) {
+		return response, err
 	}
 
-	httpClient := &http.Client{Timeout: 10 * time.Second}
-	for _, packageName := range supportedPackages {
-		apiURL := fmt.Sprintf("https://endoflife.date/api/%s.json", packageName)
-		entries, err := fetchEOLEntries(httpClient, apiURL)
-		if err != nil {
-			continue
+	httpClient := &http.Client{Timeout: eolFetchTimeout}
+	entries, err := fetchEOLEntries(httpClient, fmt.Sprintf(eolAPIURL, product))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch EOL data for %s: %w", product, err)
+	}
+	if err := cacheEOLEntries(ctx, con, product, entries); err != nil {
+		log.Printf("Can't cache EOL data for %s: %v", product, err)
+	}
+	return entries, nil
+}
+
+// cacheEOLEntries adds the release cycles of product to the EOL cache,
+// keeping the expiry of an existing cache. Concurrent writers may drop each
+// other's product, which is fetched again on its next miss.
+func cacheEOLEntries(ctx context.Context, con *redis.Client, product string, entries []EndOfLifeEntry) error {
+	var cacheDocument struct {
+		Package map[string]json.RawMessage `json:"package"`
+	}
+	args := redis.SetArgs{TTL: eolCacheTTL}
+	cachedData, err := con.Get(ctx, eolCacheKey).Bytes()
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(cachedData, &cacheDocument); err != nil {
+			return fmt.Errorf("failed to parse cached data: %w", err)
 		}
-		cacheDocument["package"].(map[string][]EndOfLifeEntry)[packageName] = entries
+		args = redis.SetArgs{KeepTTL: true}
+	case err != redis.Nil:
+		return fmt.Errorf("failed to fetch cache: %w", err)
+	}
+	if cacheDocument.Package == nil {
+		cacheDocument.Package = map[string]json.RawMessage{}
 	}
 
+	raw, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("failed to marshal EOL data: %w", err)
+	}
+	cacheDocument.Package[product] = raw
 	data, err := json.Marshal(cacheDocument)
 	if err != nil {
 		return fmt.Errorf("failed to marshal updated cache: %w", err)
 	}
-
-	err = con.Set(ctx, key, data, ttl).Err()
-	if err != nil {
+	if err := con.SetArgs(ctx, eolCacheKey, data, args).Err(); err != nil {
 		return fmt.Errorf("failed to update cache in Redis: %w", err)
 	}
-
 	return nil
 }
 
+// supportedPackages are the endoflife.date products kept in the EOL cache.
+//
+// TODO: Handle all related packages.
+// Option 1: Get all data from endoflife and store in redis.
+// Option 2: Dynamicly resolve pacakge names, but should be checked fro eof api side.
+var supportedPackages = []string{"redis", "memcached", "mongodb", "mysql", "rabbitmq", "envoy", "debian", "postgresql", "elasticsearch", "php", "gitlab-runner", "linux",
+	"kubernetes", "amazon-eks", "azure-kubernetes-service", "google-kubernetes-engine",
+	"ubuntu", "rhel", "centos", "rocky-linux", "almalinux", "oracle-linux", "alpine-linux", "amazon-linux", "fedora", "sles", "opensuse",
+	"go", "nodejs", "python", "react", "vue", "angular", "nextjs", "nuxt", "electron", "django", "laravel", "symfony", "drupal"}
+
 func fetchEOLEntries(client *http.Client, url string) ([]EndOfLifeEntry, error) {
 	resp, err := client.Get(url)
 	if err != nil {
@@ -289,7 +585,7 @@ func fetchEOLEntries(client *http.Client, url string) ([]EndOfLifeEntry, error)
 }
 
 func getEOLData(ctx context.Context, con *redis.Client, packageName string) ([]EndOfLifeEntry, error) {
-	key := "eol_cache:all_packages"
+	key := eolCacheKey
 
 	ctxWithTimeout, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
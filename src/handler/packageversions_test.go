@@ -2,7 +2,11 @@ package handler
 
 import (
 	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -52,7 +56,7 @@ func TestPackageVersionsRetrieve_UnmarshalFailureOnCorruptData(t *testing.T) {
 	c := &PackageVersionss{Items: make(map[uuid.UUID]PackageVersions)}
 
 	id := uuid.New()
-	if err := con.Set(ctx, id.String(), "not-json", 0).Err(); err != nil {
+	if err := con.Set(ctx, hostRepository.Key(id), "not-json", 0).Err(); err != nil {
 		t.Fatalf("failed to seed corrupt value: %v", err)
 	}
 
@@ -100,7 +104,7 @@ func TestPackageVersionsScan_SkipsCorruptEntryWithoutFailingWholeScan(t *testing
 		t.Fatalf("unexpected error: %v", err)
 	}
 	corrupt := uuid.New()
-	if err := con.Set(ctx, corrupt.String(), "not-json", 0).Err(); err != nil {
+	if err := con.Set(ctx, hostRepository.Key(corrupt), "not-json", 0).Err(); err != nil {
 		t.Fatalf("failed to seed corrupt value: %v", err)
 	}
 
@@ -129,3 +133,174 @@ func TestPackageVersionsScan_EmptyDatabase(t *testing.T) {
 		t.Fatalf("expected no items in an empty database, got %d", len(result.Items))
 	}
 }
+
+func TestPackageVersionsInsertBatch_WritesEveryHost(t *testing.T) {
+	ctx := context.Background()
+	con := newTestClient(t)
+	c := &PackageVersionss{Items: make(map[uuid.UUID]PackageVersions)}
+
+	pkgs := []PackageVersions{
+		{DataCenterPkg: "dc1", HostIPPkg: "10.0.0.1", Packages: map[string]PackageDetail{"redis": {CurrentVersion: "7.0.15"}}},
+		{DataCenterPkg: "dc1", HostIPPkg: "10.0.0.2", Packages: map[string]PackageDetail{"redis": {CurrentVersion: "6.2.1"}}},
+	}
+
+	ids, errs := c.InsertBatch(pkgs, ctx, con, noopQuery, 60)
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("unexpected error for item %d: %v", i, err)
+		}
+	}
+	if ids[0] != UUIDFromDcAndIPPackage("dc1", "10.0.0.1") || ids[1] != UUIDFromDcAndIPPackage("dc1", "10.0.0.2") {
+		t.Fatalf("expected ids in request order, got %v", ids)
+	}
+
+	stored, err := c.Retrieve(ids[1], ctx, con)
+	if err != nil {
+		t.Fatalf("unexpected error retrieving batch item: %v", err)
+	}
+	if stored.Packages["redis"].CurrentVersion != "6.2" {
+		t.Errorf("expected enriched version %q, got %q", "6.2", stored.Packages["redis"].CurrentVersion)
+	}
+}
+
+func TestPackageVersionsPatch_MergesAndReenrichesOnlyChangedPackages(t *testing.T) {
+	ctx := context.Background()
+	con := newTestClient(t)
+	c := &PackageVersionss{Items: make(map[uuid.UUID]PackageVersions)}
+
+	_, err := c.Insert(PackageVersions{
+		DataCenterPkg: "dc1",
+		HostIPPkg:     "10.0.0.1",
+		Team:          "core",
+		Packages: map[string]PackageDetail{
+			"redis": {CurrentVersion: "7.0.15"},
+			"mysql": {CurrentVersion: "8.0.36"},
+		},
+	}, ctx, con, noopQuery, 60)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var queried []string
+	query := func(name string) (string, string, error) {
+		queried = append(queried, name)
+		return "6.8", "2030-01-01", nil
+	}
+	kernel, redis := "6.8.0-45", "7.0.11"
+	pkg, etag, err := c.Patch(PackagePatch{
+		DataCenter: "dc1",
+		HostIP:     "10.0.0.1",
+		Packages:   map[string]*string{"kernel": &kernel, "redis": &redis, "mysql": nil},
+	}, ctx, con, query, 60, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(queried) != 1 || queried[0] != "kernel" {
+		t.Errorf("expected only the new package to be enriched, queried %v", queried)
+	}
+	if _, ok := pkg.Packages["mysql"]; ok {
+		t.Errorf("expected mysql to be removed, got %+v", pkg.Packages)
+	}
+	if pkg.Packages["kernel"].NewestVersion != "6.8" || pkg.Packages["redis"].CurrentVersion != "7.0" {
+		t.Errorf("unexpected merged packages %+v", pkg.Packages)
+	}
+	if pkg.Team != "core" {
+		t.Errorf("expected team to be kept, got %q", pkg.Team)
+	}
+
+	stored, storedETag, _ := c.RetrieveWithETag(pkg.IDPkg, ctx, con)
+	if storedETag != etag || len(stored.Packages) != 2 {
+		t.Errorf("expected the merged record to be stored, got %+v (etag %s, want %s)", stored, storedETag, etag)
+	}
+}
+
+func TestPackageVersionsPatch_LooksUpSBOMPackagesByProduct(t *testing.T) {
+	ctx := context.Background()
+	con := newTestClient(t)
+	c := &PackageVersionss{Items: make(map[uuid.UUID]PackageVersions)}
+
+	_, err := c.Insert(PackageVersions{
+		DataCenterPkg: "dc1",
+		HostIPPkg:     "10.0.0.1",
+		Source:        SBOMSource,
+		Packages: map[string]PackageDetail{
+			"redis-server": {CurrentVersion: "7.0.15", EOLProduct: "redis", Purl: "pkg:deb/debian/redis-server@7.0.15", Type: "library"},
+			"left-pad":     {CurrentVersion: "1.3.0", Purl: "pkg:npm/left-pad@1.3.0", Type: "library"},
+		},
+	}, ctx, con, noopQuery, 60)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var queried []string
+	query := func(name string) (string, string, error) {
+		queried = append(queried, name)
+		return "7.2", "2030-01-01", nil
+	}
+	redis, leftPad := "7.2.4", "1.4.0"
+	pkg, _, err := c.Patch(PackagePatch{
+		DataCenter: "dc1",
+		HostIP:     "10.0.0.1",
+		Source:     SBOMSource,
+		Packages:   map[string]*string{"redis-server": &redis, "left-pad": &leftPad},
+	}, ctx, con, query, 60, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(queried) != 1 || queried[0] != "redis" {
+		t.Errorf("expected only the stored EOL product to be looked up, queried %v", queried)
+	}
+	if got := pkg.Sources[SBOMSource].Packages["redis-server"]; got.EOLProduct != "redis" || got.NewestVersion != "7.2" {
+		t.Errorf("expected redis-server to keep its EOL product, got %+v", got)
+	}
+	if got := pkg.Sources[SBOMSource].Packages["left-pad"]; got.EOLProduct != "" || got.NewestVersion != "unknown" {
+		t.Errorf("expected left-pad without EOL data, got %+v", got)
+	}
+}
+
+func TestPackageVersionsPatch_MissingHost(t *testing.T) {
+	c := &PackageVersionss{Items: make(map[uuid.UUID]PackageVersions)}
+	_, _, err := c.Patch(PackagePatch{DataCenter: "dc1", HostIP: "10.0.0.9"}, context.Background(), newTestClient(t), noopQuery, 60, "")
+	if err != ErrIDNotFoundPackage {
+		t.Errorf("expected ErrIDNotFoundPackage, got %v", err)
+	}
+}
+
+func TestQueryEOLEntries_FetchesMissingProducts(t *testing.T) {
+	ctx := context.Background()
+	con := newTestClient(t)
+	seedEOLCache(t, con, `{"redis":[{"cycle":"7.0","eol":"2099-07-29","latest":"7.0.15"}]}`)
+	con.Expire(ctx, eolCacheKey, time.Hour)
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Write([]byte(`[{"cycle":"16","eol":false,"latest":"16.4"}]`))
+	}))
+	defer srv.Close()
+	defer func(url string) { eolAPIURL = url }(eolAPIURL)
+	eolAPIURL = srv.URL + "/%s.json"
+
+	if _, err := QueryEOLEntries(ctx, con, "libc6"); err == nil || fetches.Load() != 0 {
+		t.Fatalf("expected an unsupported product to miss without a fetch, got %v after %d fetches", err, fetches.Load())
+	}
+	for range 2 {
+		entries, err := QueryEOLEntries(ctx, con, "postgresql")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(entries) != 1 || entries[0].Latest != "16.4" {
+			t.Errorf("expected the fetched entries, got %+v", entries)
+		}
+	}
+	if got := fetches.Load(); got != 1 {
+		t.Errorf("expected the product to be fetched once and then cached, got %d fetches", got)
+	}
+	if entries, err := QueryEOLEntries(ctx, con, "redis"); err != nil || len(entries) != 1 || entries[0].Latest != "7.0.15" {
+		t.Errorf("expected the cached products to be kept, got %+v, %v", entries, err)
+	}
+	if ttl := con.TTL(ctx, eolCacheKey).Val(); ttl <= 0 || ttl > time.Hour {
+		t.Errorf("expected the cache to keep its expiry, got %v", ttl)
+	}
+}
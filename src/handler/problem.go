@@ -0,0 +1,170 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+
+	"github.com/google/uuid"
+)
+
+// Errors are answered with RFC 7807 application/problem+json documents. Code
+// is stable and meant for programmatic handling; Detail is for humans and may
+// change between releases.
+
+const (
+	problemContentType = "application/problem+json"
+	problemTypePrefix  = "urn:keepup:error:"
+	requestIDHeader    = "X-Request-Id"
+)
+
+const (
+	CodeForbidden                = "forbidden"
+	CodeMethodNotAllowed         = "method_not_allowed"
+	CodeInvalidPayload           = "invalid_payload"
+	CodeUnsupportedSBOM          = "unsupported_sbom"
+	CodeInvalidManifest          = "invalid_manifest"
+	CodeValidationFailed         = "validation_failed"
+	CodeInvalidTTL               = "invalid_ttl"
+	CodeBodyTooLarge             = "body_too_large"
+	CodeUnsupportedEncoding      = "unsupported_encoding"
+	CodePreconditionFailed       = "precondition_failed"
+	CodeWriteConflict            = "write_conflict"
+	CodePackageNotFound          = "package_not_found"
+	CodePackageInsertFailed      = "package_insert_failed"
+	CodePackageCorrupt           = "package_marshal_failed"
+	CodePackageDeleteFailed      = "package_delete_failed"
+	CodeClusterNotFound          = "cluster_not_found"
+	CodeClusterInsertFailed      = "cluster_insert_failed"
+	CodeClusterCorrupt           = "cluster_marshal_failed"
+	CodeClusterDeleteFailed      = "cluster_delete_failed"
+	CodeImagesNotFound           = "container_images_not_found"
+	CodeImagesInsertFailed       = "container_images_insert_failed"
+	CodeImagesCorrupt            = "container_images_marshal_failed"
+	CodeImagesDeleteFailed       = "container_images_delete_failed"
+	CodeCertificatesNotFound     = "certificates_not_found"
+	CodeCertificatesInsertFailed = "certificates_insert_failed"
+	CodeCertificatesCorrupt      = "certificates_marshal_failed"
+	CodeCertificatesDeleteFailed = "certificates_delete_failed"
+	CodeDependenciesNotFound     = "application_dependencies_not_found"
+	CodeDependenciesInsertFailed = "application_dependencies_insert_failed"
+	CodeDependenciesCorrupt      = "application_dependencies_marshal_failed"
+	CodeDependenciesDeleteFailed = "application_dependencies_delete_failed"
+	CodeCustomRecordNotFound     = "custom_record_not_found"
+	CodeCustomRecordInsertFailed = "custom_record_insert_failed"
+	CodeCustomRecordCorrupt      = "custom_record_marshal_failed"
+	CodeCustomRecordDeleteFailed = "custom_record_delete_failed"
+	CodeInternal                 = "internal_error"
+)
+
+type Problem struct {
+	Type       string      `json:"type"`
+	Title      string      `json:"title"`
+	Status     int         `json:"status"`
+	Detail     string      `json:"detail,omitempty"`
+	Instance   string      `json:"instance,omitempty"`
+	Code       string      `json:"code"`
+	RequestID  string      `json:"request_id,omitempty"`
+	Violations []Violation `json:"violations,omitempty"`
+}
+
+type problemSpec struct {
+	status int
+	code   string
+}
+
+// problemSpecs maps the domain errors onto HTTP statuses and error codes.
+var problemSpecs = map[error]problemSpec{
+	ErrIDNotFoundPackage:         {http.StatusNotFound, CodePackageNotFound},
+	ErrInsertFailedPackage:       {http.StatusInternalServerError, CodePackageInsertFailed},
+	ErrMarshalFailedPackage:      {http.StatusInternalServerError, CodePackageCorrupt},
+	ErrDeleteFailedPackage:       {http.StatusInternalServerError, CodePackageDeleteFailed},
+	ErrClusterNotFound:           {http.StatusNotFound, CodeClusterNotFound},
+	ErrClusterInsertFailed:       {http.StatusInternalServerError, CodeClusterInsertFailed},
+	ErrClusterMarshalFailed:      {http.StatusInternalServerError, CodeClusterCorrupt},
+	ErrClusterDeleteFailed:       {http.StatusInternalServerError, CodeClusterDeleteFailed},
+	ErrImagesNotFound:            {http.StatusNotFound, CodeImagesNotFound},
+	ErrImagesInsertFailed:        {http.StatusInternalServerError, CodeImagesInsertFailed},
+	ErrImagesMarshalFailed:       {http.StatusInternalServerError, CodeImagesCorrupt},
+	ErrImagesDeleteFailed:        {http.StatusInternalServerError, CodeImagesDeleteFailed},
+	ErrCertificatesNotFound:      {http.StatusNotFound, CodeCertificatesNotFound},
+	ErrCertificatesInsertFailed:  {http.StatusInternalServerError, CodeCertificatesInsertFailed},
+	ErrCertificatesMarshalFailed: {http.StatusInternalServerError, CodeCertificatesCorrupt},
+	ErrCertificatesDeleteFailed:  {http.StatusInternalServerError, CodeCertificatesDeleteFailed},
+	ErrDependenciesNotFound:      {http.StatusNotFound, CodeDependenciesNotFound},
+	ErrDependenciesInsertFailed:  {http.StatusInternalServerError, CodeDependenciesInsertFailed},
+	ErrDependenciesMarshalFailed: {http.StatusInternalServerError, CodeDependenciesCorrupt},
+	ErrDependenciesDeleteFailed:  {http.StatusInternalServerError, CodeDependenciesDeleteFailed},
+	ErrCustomRecordNotFound:      {http.StatusNotFound, CodeCustomRecordNotFound},
+	ErrCustomRecordInsertFailed:  {http.StatusInternalServerError, CodeCustomRecordInsertFailed},
+	ErrCustomRecordMarshalFailed: {http.StatusInternalServerError, CodeCustomRecordCorrupt},
+	ErrCustomRecordDeleteFailed:  {http.StatusInternalServerError, CodeCustomRecordDeleteFailed},
+	ErrPreconditionFailed:        {http.StatusPreconditionFailed, CodePreconditionFailed},
+	ErrWriteConflict:             {http.StatusConflict, CodeWriteConflict},
+	ErrInvalidTTL:                {http.StatusBadRequest, CodeInvalidTTL},
+	ErrUnsupportedSBOM:           {http.StatusBadRequest, CodeUnsupportedSBOM},
+	ErrInvalidManifest:           {http.StatusBadRequest, CodeInvalidManifest},
+	ErrUnsupportedEncoding:       {http.StatusUnsupportedMediaType, CodeUnsupportedEncoding},
+	ErrBodyTooLarge:              {http.StatusRequestEntityTooLarge, CodeBodyTooLarge},
+}
+
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// withRequestID echoes a well-formed X-Request-Id header back to the client
+// or assigns a new one, so that errors can be correlated with logs.
+func withRequestID(w http.ResponseWriter, r *http.Request) string {
+	id := r.Header.Get(requestIDHeader)
+	if !validRequestID.MatchString(id) {
+		id = uuid.NewString()
+	}
+	w.Header().Set(requestIDHeader, id)
+	return id
+}
+
+func newProblem(r *http.Request, w http.ResponseWriter, status int, code string, detail string) Problem {
+	return Problem{
+		Type:      problemTypePrefix + code,
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Instance:  r.URL.Path,
+		Code:      code,
+		RequestID: w.Header().Get(requestIDHeader),
+	}
+}
+
+func writeProblemDocument(w http.ResponseWriter, problem Problem) {
+	w.Header().Set("Content-Type", problemContentType)
+	w.Header().Del("Content-Length")
+	w.WriteHeader(problem.Status)
+	json.NewEncoder(w).Encode(problem)
+}
+
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, code string, detail string) {
+	writeProblemDocument(w, newProblem(r, w, status, code, detail))
+}
+
+// writeError answers with the status and code registered for err in
+// problemSpecs, or with 500 internal_error for anything unexpected.
+func writeError(w http.ResponseWriter, r *http.Request, err error, detail string) {
+	status, code := http.StatusInternalServerError, CodeInternal
+	for target, spec := range problemSpecs {
+		if errors.Is(err, target) {
+			status, code = spec.status, spec.code
+			break
+		}
+	}
+	writeProblem(w, r, status, code, detail)
+}
+
+// errorCode returns the stable code for err, for places such as batch
+// results that report errors without a response of their own.
+func errorCode(err error) string {
+	for target, spec := range problemSpecs {
+		if errors.Is(err, target) {
+			return spec.code
+		}
+	}
+	return CodeInternal
+}
@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func decodeProblem(t *testing.T, rec *httptest.ResponseRecorder) Problem {
+	t.Helper()
+	if ct := rec.Header().Get("Content-Type"); ct != problemContentType {
+		t.Fatalf("expected content type %q, got %q", problemContentType, ct)
+	}
+	var problem Problem
+	if err := json.NewDecoder(rec.Body).Decode(&problem); err != nil {
+		t.Fatalf("unexpected error decoding problem: %v", err)
+	}
+	return problem
+}
+
+func TestWithAuth_ForbiddenIsProblemWithRequestID(t *testing.T) {
+	s := newTestClusterMiddleware(t, BodyLimits{})
+	req := httptest.NewRequest(http.MethodGet, "/helm-cluster", nil)
+	req.Header.Set(requestIDHeader, "agent-42")
+	rec := httptest.NewRecorder()
+	s.Handler()(rec, req)
+
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected status 403, got %d", rec.Code)
+	}
+	if rec.Header().Get(requestIDHeader) != "agent-42" {
+		t.Errorf("expected the request id to be echoed, got %q", rec.Header().Get(requestIDHeader))
+	}
+	problem := decodeProblem(t, rec)
+	if problem.Code != CodeForbidden || problem.Status != http.StatusForbidden || problem.RequestID != "agent-42" {
+		t.Errorf("unexpected problem document %+v", problem)
+	}
+}
+
+func TestWithAuth_MethodNotAllowedListsAllowedMethods(t *testing.T) {
+	s := newTestClusterMiddleware(t, BodyLimits{})
+	req := httptest.NewRequest(http.MethodPost, "/helm-cluster", nil)
+	req.Header.Set("x-api-token", "secret")
+	rec := httptest.NewRecorder()
+	s.Handler()(rec, req)
+
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected status 405, got %d", rec.Code)
+	}
+	if allow := rec.Header().Get("Allow"); allow != "DELETE, GET, PATCH, PUT" {
+		t.Errorf("expected Allow header %q, got %q", "DELETE, GET, PATCH, PUT", allow)
+	}
+	if problem := decodeProblem(t, rec); problem.Code != CodeMethodNotAllowed {
+		t.Errorf("expected code %q, got %q", CodeMethodNotAllowed, problem.Code)
+	}
+	if rec.Header().Get(requestIDHeader) == "" {
+		t.Error("expected a request id to be assigned")
+	}
+}
+
+func TestHandleGetPackages_NotFoundCode(t *testing.T) {
+	p := &PackageVersionsHandler{
+		PackageVersions: &PackageVersionss{Items: make(map[uuid.UUID]PackageVersions)},
+		Client:          newTestClient(t),
+		Context:         context.Background(),
+		ApiToken:        "secret",
+	}
+	req := httptest.NewRequest(http.MethodGet, "/package-version", strings.NewReader(`{"id":"`+uuid.NewString()+`"}`))
+	req.Header.Set("x-api-token", "secret")
+	rec := httptest.NewRecorder()
+	p.Handler()(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d", rec.Code)
+	}
+	if problem := decodeProblem(t, rec); problem.Code != CodePackageNotFound || problem.Instance != "/package-version" {
+		t.Errorf("unexpected problem document %+v", problem)
+	}
+}
@@ -0,0 +1,221 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// Every domain keeps its records as JSON documents under its key prefix
+// plus the record's ID. Repository holds that storage logic once, so the
+// domains only decide how a record is identified and built.
+
+// Repository stores the records of one domain.
+type Repository[T any] struct {
+	Name     string // plural, for log messages
+	Prefix   string // prepended to the ID to form the key
+	Identity func(T) uuid.UUID
+	TTL      int // seconds, for writes that name no TTL of their own
+	Errors   RepositoryErrors
+}
+
+// RepositoryErrors are what a Repository returns for its domain, so handlers
+// keep answering with the domain's problem codes.
+type RepositoryErrors struct {
+	NotFound      error
+	InsertFailed  error
+	MarshalFailed error
+	DeleteFailed  error
+}
+
+// Key returns the Redis key of the record with id.
+func (r Repository[T]) Key(id uuid.UUID) string {
+	return r.Prefix + id.String()
+}
+
+func (r Repository[T]) expiry(ttl int) time.Duration {
+	if ttl <= 0 {
+		ttl = r.TTL
+	}
+	return time.Duration(ttl) * time.Second
+}
+
+// Get returns the record with id and its ETag.
+func (r Repository[T]) Get(id uuid.UUID, ctx context.Context, con *redis.Client) (T, string, error) {
+	var item T
+	data, err := con.Get(ctx, r.Key(id)).Bytes()
+	if err != nil {
+		return item, "", r.Errors.NotFound
+	}
+	if err := json.Unmarshal(data, &item); err != nil {
+		var zero T
+		return zero, "", r.Errors.MarshalFailed
+	}
+	return item, ETag(data), nil
+}
+
+// Put replaces the record of item, guarded by an If-Match header value (""
+// for an unconditional write). It returns the ID and ETag of the stored
+// record.
+func (r Repository[T]) Put(item T, ctx context.Context, con *redis.Client, ttl int, ifMatch string) (uuid.UUID, string, error) {
+	id := r.Identity(item)
+	data, err := json.Marshal(item)
+	if err != nil {
+		return id, "", r.Errors.MarshalFailed
+	}
+
+	err = storeIfMatch(ctx, con, r.Key(id), ifMatch, data, r.expiry(ttl))
+	if err == ErrPreconditionFailed {
+		return id, "", err
+	}
+	if err != nil {
+		return id, "", r.Errors.InsertFailed
+	}
+	return id, ETag(data), nil
+}
+
+// Update rewrites the record with id with the result of update, which
+// receives the stored JSON, and returns the new ETag; see updateIfMatch.
+// Without create a missing record fails with Errors.NotFound, otherwise
+// update receives nil. The domain's own errors from update are passed on,
+// any other failure is Errors.InsertFailed.
+func (r Repository[T]) Update(
+	id uuid.UUID,
+	ctx context.Context,
+	con *redis.Client,
+	ttl int,
+	ifMatch string,
+	create bool,
+	update func([]byte) ([]byte, error),
+) (string, error) {
+	var missing error
+	if !create {
+		missing = r.Errors.NotFound
+	}
+	etag, err := updateIfMatch(ctx, con, r.Key(id), ifMatch, r.expiry(ttl), missing, update)
+	switch err {
+	case nil:
+		return etag, nil
+	case ErrPreconditionFailed, ErrWriteConflict, r.Errors.NotFound, r.Errors.MarshalFailed:
+		return "", err
+	default:
+		return "", r.Errors.InsertFailed
+	}
+}
+
+// Delete removes the record with id, guarded by an If-Match header value
+// ("" for an unconditional delete).
+func (r Repository[T]) Delete(id uuid.UUID, ctx context.Context, con *redis.Client, ifMatch string) error {
+	err := deleteIfMatch(ctx, con, r.Key(id), ifMatch)
+	switch {
+	case err == redis.Nil:
+		return r.Errors.NotFound
+	case err == ErrPreconditionFailed:
+		return err
+	case err != nil:
+		return r.Errors.DeleteFailed
+	}
+	return nil
+}
+
+// Scan returns every stored record by ID. Records that can't be decoded are
+// logged and left out.
+func (r Repository[T]) Scan(ctx context.Context, con *redis.Client) (map[uuid.UUID]T, error) {
+	items := make(map[uuid.UUID]T)
+
+	var ids []uuid.UUID
+	var keys []string
+	iter := con.Scan(ctx, 0, r.Prefix+"*", 0).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		id, err := uuid.Parse(strings.TrimPrefix(key, r.Prefix))
+		if err != nil {
+			log.Printf("Cannot parse UUID: %s, %v", key, err)
+			continue
+		}
+		ids = append(ids, id)
+		keys = append(keys, key)
+	}
+	if err := iter.Err(); err != nil {
+		log.Printf("Error scanning %s: %v", r.Name, err)
+		return items, err
+	}
+	if len(keys) == 0 {
+		return items, nil
+	}
+
+	values, err := con.MGet(ctx, keys...).Result()
+	if err != nil {
+		log.Printf("Error fetching %s: %v", r.Name, err)
+		return items, err
+	}
+	for i, val := range values {
+		if val == nil {
+			// Key expired between SCAN and MGET.
+			continue
+		}
+		str, ok := val.(string)
+		if !ok {
+			log.Printf("Unexpected value type for key %s", keys[i])
+			continue
+		}
+		var item T
+		if err := json.Unmarshal([]byte(str), &item); err != nil {
+			log.Printf("Can't unmarshal %s %s: %v", r.Name, keys[i], err)
+			continue
+		}
+		items[ids[i]] = item
+	}
+
+	return items, nil
+}
+
+// MigrateLegacyKeys moves the host and cluster records stored under their
+// bare ID, before the two domains had a prefix, to their repository's key.
+// The TTL is kept; a record already written under the new key wins. It is
+// run once at startup.
+func MigrateLegacyKeys(ctx context.Context, con *redis.Client) error {
+	moved := 0
+	iter := con.Scan(ctx, 0, "*", 0).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		id, err := uuid.Parse(key)
+		if err != nil {
+			continue
+		}
+		data, err := con.Get(ctx, key).Bytes()
+		if err != nil {
+			// Expired since the scan.
+			continue
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(data, &fields); err != nil {
+			log.Printf("Can't migrate %s: %v", key, err)
+			continue
+		}
+		target := hostRepository.Key(id)
+		if _, ok := fields["cluster_name"]; ok {
+			target = clusterRepository.Key(id)
+		}
+		renamed, err := con.RenameNX(ctx, key, target).Result()
+		if err != nil {
+			return err
+		}
+		if !renamed {
+			con.Del(ctx, key)
+		}
+		moved++
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	if moved > 0 {
+		log.Printf("Moved %d host and cluster records to prefixed keys", moved)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type testRecord struct {
+	Name string `json:"name"`
+}
+
+var (
+	errTestNotFound      = errors.New("not found")
+	errTestInsertFailed  = errors.New("insert failed")
+	errTestMarshalFailed = errors.New("marshal failed")
+	errTestDeleteFailed  = errors.New("delete failed")
+)
+
+func testRepository(prefix string) Repository[testRecord] {
+	return Repository[testRecord]{
+		Name:     "test records",
+		Prefix:   prefix,
+		Identity: func(r testRecord) uuid.UUID { return uuid.NewSHA1(uuid.NameSpaceDNS, []byte(r.Name)) },
+		TTL:      60,
+		Errors: RepositoryErrors{
+			NotFound:      errTestNotFound,
+			InsertFailed:  errTestInsertFailed,
+			MarshalFailed: errTestMarshalFailed,
+			DeleteFailed:  errTestDeleteFailed,
+		},
+	}
+}
+
+func TestRepository_PutGetDelete(t *testing.T) {
+	ctx := context.Background()
+	con := newTestClient(t)
+	repo := testRepository("keepup:test:")
+
+	id, etag, err := repo.Put(testRecord{Name: "a"}, ctx, con, 0, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ttl := con.TTL(ctx, "keepup:test:"+id.String()).Val(); ttl <= 0 || ttl > 60*time.Second {
+		t.Errorf("expected the repository's TTL, got %v", ttl)
+	}
+
+	record, got, err := repo.Get(id, ctx, con)
+	if err != nil || record.Name != "a" || got != etag {
+		t.Fatalf("expected record a with ETag %s, got %+v %s %v", etag, record, got, err)
+	}
+
+	if _, _, err := repo.Put(testRecord{Name: "a"}, ctx, con, 0, `"stale"`); err != ErrPreconditionFailed {
+		t.Errorf("expected ErrPreconditionFailed, got %v", err)
+	}
+	if _, err := repo.Update(uuid.New(), ctx, con, 0, "", false, nil); err != errTestNotFound {
+		t.Errorf("expected the domain's not found error, got %v", err)
+	}
+
+	if err := repo.Delete(id, ctx, con, etag); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, _, err := repo.Get(id, ctx, con); err != errTestNotFound {
+		t.Errorf("expected the domain's not found error, got %v", err)
+	}
+	if err := repo.Delete(id, ctx, con, ""); err != errTestNotFound {
+		t.Errorf("expected the domain's not found error, got %v", err)
+	}
+}
+
+func TestRepository_ScanStaysInsideItsPrefix(t *testing.T) {
+	ctx := context.Background()
+	con := newTestClient(t)
+	legacy := testRepository("")
+	prefixed := testRepository("keepup:test:")
+
+	bare, _, _ := legacy.Put(testRecord{Name: "bare"}, ctx, con, 0, "")
+	own, _, _ := prefixed.Put(testRecord{Name: "own"}, ctx, con, 0, "")
+	seedEOLCache(t, con, `{}`)
+	corrupt := uuid.New()
+	if err := con.Set(ctx, "keepup:test:"+corrupt.String(), "not-json", 0).Err(); err != nil {
+		t.Fatalf("failed to seed corrupt value: %v", err)
+	}
+
+	items, err := legacy.Scan(ctx, con)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(items) != 1 || items[bare].Name != "bare" {
+		t.Errorf("expected only the bare record, got %+v", items)
+	}
+
+	items, err = prefixed.Scan(ctx, con)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(items) != 1 || items[own].Name != "own" {
+		t.Errorf("expected only the prefixed record, got %+v", items)
+	}
+}
+
+func TestMigrateLegacyKeys_MovesHostsAndClusters(t *testing.T) {
+	ctx := context.Background()
+	con := newTestClient(t)
+	host, cluster, newer := uuid.New(), uuid.New(), uuid.New()
+	con.Set(ctx, host.String(), `{"data_center":"dc1","host_ip":"10.0.0.1"}`, time.Minute)
+	con.Set(ctx, cluster.String(), `{"cluster_name":"minikube"}`, 0)
+	con.Set(ctx, newer.String(), `{"data_center":"dc1","host_ip":"10.0.0.2"}`, 0)
+	con.Set(ctx, hostRepository.Key(newer), `{"data_center":"dc1","host_ip":"10.0.0.3"}`, 0)
+
+	if err := MigrateLegacyKeys(ctx, con); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, _, err := hostRepository.Get(host, ctx, con); err != nil || got.HostIPPkg != "10.0.0.1" {
+		t.Errorf("expected the host under its prefix, got %+v (err %v)", got, err)
+	}
+	if ttl := con.TTL(ctx, hostRepository.Key(host)).Val(); ttl <= 0 {
+		t.Errorf("expected the TTL to be kept, got %v", ttl)
+	}
+	if got, _, err := clusterRepository.Get(cluster, ctx, con); err != nil || got.ClusterName != "minikube" {
+		t.Errorf("expected the cluster under its prefix, got %+v (err %v)", got, err)
+	}
+	if got, _, _ := hostRepository.Get(newer, ctx, con); got.HostIPPkg != "10.0.0.3" {
+		t.Errorf("expected the prefixed record to win, got %+v", got)
+	}
+	if n := con.Exists(ctx, host.String(), cluster.String(), newer.String()).Val(); n != 0 {
+		t.Errorf("expected no bare keys left, %d remain", n)
+	}
+}
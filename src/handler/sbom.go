@@ -0,0 +1,301 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// SBOMs produced by build pipelines are ingested as they are, in CycloneDX
+// or SPDX JSON. Their components become the packages of a host, or of an
+// artifact such as a container image that runs on no particular host,
+// under their own source. Only components whose purl names a supported
+// endoflife.date product are looked up; the others are stored without EOL
+// data, so that an SBOM listing hundreds of libraries causes no lookups.
+
+const (
+	SBOMSource         = "sbom"
+	artifactUUIDSuffix = "ARTIFACT_UUID"
+)
+
+// purlProductAliases maps package names that differ from their
+// endoflife.date product onto it.
+var purlProductAliases = map[string]string{
+	"redis-server":           "redis",
+	"postgres":               "postgresql",
+	"mysql-server":           "mysql",
+	"mysql-community-server": "mysql",
+	"mongo":                  "mongodb",
+	"mongodb-org":            "mongodb",
+	"mongodb-org-server":     "mongodb",
+	"rabbitmq-server":        "rabbitmq",
+	"php-cli":                "php",
+	"php-fpm":                "php",
+}
+
+// serverEOLProducts are the products system packages and images are looked
+// up as; libraries of the same name, such as the npm redis client, are not.
+var serverEOLProducts = []string{"redis", "memcached", "mongodb", "mysql", "rabbitmq", "envoy", "postgresql", "elasticsearch", "php", "gitlab-runner"}
+
+// purlServerTypes are the purl types of system packages and images.
+var purlServerTypes = map[string]bool{"deb": true, "rpm": true, "apk": true, "docker": true, "generic": true}
+
+// purlEcosystems maps the purl types of language packages onto the
+// ecosystem of manifests, whose runtime and framework products they use.
+var purlEcosystems = map[string]string{"npm": "npm", "pypi": "pypi", "composer": "composer", "golang": "go"}
+
+var (
+	ErrUnsupportedSBOM = errors.New("Unsupported SBOM format")
+	errNoEOLProduct    = errors.New("No EOL product")
+)
+
+// SBOMComponent is a component of an SBOM, whichever its format.
+type SBOMComponent struct {
+	Name    string `json:"name" validate:"required,max=128,label"`
+	Version string `json:"version" validate:"max=128"`
+	Purl    string `json:"purl" validate:"max=2048"`
+	Type    string `json:"type" validate:"max=32,label"`
+	path    string // JSON path in the document, for violations
+}
+
+// SBOMTarget names what an SBOM describes, from the query string: a host by
+// data_center and host_ip, or an artifact.
+type SBOMTarget struct {
+	DataCenter string `json:"data_center" validate:"max=64,label"`
+	HostIP     string `json:"host_ip" validate:"ip"`
+	Hostname   string `json:"hostname" validate:"max=253,label"`
+	MachineID  string `json:"machine_id" validate:"max=64,label"`
+	Team       string `json:"team" validate:"max=64,label"`
+	Artifact   string `json:"artifact" validate:"max=255,label"`
+	Source     string `json:"source" validate:"max=64,label"`
+}
+
+type cycloneDXDocument struct {
+	BOMFormat  string               `json:"bomFormat"`
+	Components []cycloneDXComponent `json:"components"`
+}
+
+type cycloneDXComponent struct {
+	Type       string               `json:"type"`
+	Group      string               `json:"group"`
+	Name       string               `json:"name"`
+	Version    string               `json:"version"`
+	Purl       string               `json:"purl"`
+	Components []cycloneDXComponent `json:"components"`
+}
+
+type spdxDocument struct {
+	SPDXVersion string        `json:"spdxVersion"`
+	Packages    []spdxPackage `json:"packages"`
+}
+
+type spdxPackage struct {
+	Name                  string `json:"name"`
+	VersionInfo           string `json:"versionInfo"`
+	PrimaryPackagePurpose string `json:"primaryPackagePurpose"`
+	ExternalRefs          []struct {
+		ReferenceType    string `json:"referenceType"`
+		ReferenceLocator string `json:"referenceLocator"`
+	} `json:"externalRefs"`
+}
+
+func UUIDFromArtifact(artifact string) uuid.UUID {
+	return uuid.NewSHA1(uuid.NameSpaceDNS, []byte(fmt.Sprintf("%s-%s", artifact, artifactUUIDSuffix)))
+}
+
+// decodeSBOM returns the components of a CycloneDX or SPDX JSON document,
+// nested CycloneDX components included.
+func decodeSBOM(data []byte) ([]SBOMComponent, error) {
+	var probe struct {
+		BOMFormat   string `json:"bomFormat"`
+		SPDXVersion string `json:"spdxVersion"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, err
+	}
+
+	var components []SBOMComponent
+	switch {
+	case probe.BOMFormat == "CycloneDX":
+		var doc cycloneDXDocument
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+		components = flattenCycloneDX(doc.Components, "components", components)
+	case strings.HasPrefix(probe.SPDXVersion, "SPDX-"):
+		var doc spdxDocument
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+		for i, pkg := range doc.Packages {
+			component := SBOMComponent{
+				Name:    strings.TrimPrefix(pkg.Name, "@"),
+				Version: pkg.VersionInfo,
+				Type:    strings.ToLower(pkg.PrimaryPackagePurpose),
+				path:    fmt.Sprintf("packages[%d]", i),
+			}
+			for _, ref := range pkg.ExternalRefs {
+				if ref.ReferenceType == "purl" {
+					component.Purl = ref.ReferenceLocator
+					break
+				}
+			}
+			components = append(components, component)
+		}
+	default:
+		return nil, ErrUnsupportedSBOM
+	}
+	return components, nil
+}
+
+func flattenCycloneDX(components []cycloneDXComponent, path string, out []SBOMComponent) []SBOMComponent {
+	for i, c := range components {
+		name := strings.TrimPrefix(c.Name, "@")
+		if c.Group != "" {
+			name = strings.TrimPrefix(c.Group, "@") + "/" + name
+		}
+		componentPath := fmt.Sprintf("%s[%d]", path, i)
+		out = append(out, SBOMComponent{
+			Name:    name,
+			Version: c.Version,
+			Purl:    c.Purl,
+			Type:    strings.ToLower(c.Type),
+			path:    componentPath,
+		})
+		out = flattenCycloneDX(c.Components, componentPath+".components", out)
+	}
+	return out
+}
+
+// purlProduct returns the endoflife.date product a package URL such as
+// pkg:deb/debian/redis-server@5:7.0.15-1~deb12u1?arch=amd64 names. System
+// packages and images may name a server, language packages their
+// ecosystem's runtime or one of its key frameworks.
+func purlProduct(purl string) (string, bool) {
+	rest, ok := strings.CutPrefix(purl, "pkg:")
+	if !ok {
+		return "", false
+	}
+	rest, _, _ = strings.Cut(rest, "#")
+	rest, _, _ = strings.Cut(rest, "?")
+	// A namespace's @, as in npm scopes, is percent-encoded.
+	rest, _, _ = strings.Cut(rest, "@")
+	purlType, rest, ok := strings.Cut(rest, "/")
+	if !ok {
+		return "", false
+	}
+	if unescaped, err := url.PathUnescape(rest); err == nil {
+		rest = unescaped
+	}
+	rest = strings.ToLower(rest)
+	name := rest[strings.LastIndex(rest, "/")+1:]
+
+	switch purlType = strings.ToLower(purlType); {
+	case purlServerTypes[purlType]:
+		if product, ok := purlProductAliases[name]; ok {
+			return product, true
+		}
+		for _, product := range serverEOLProducts {
+			if product == name {
+				return product, true
+			}
+		}
+	case purlEcosystems[purlType] != "":
+		ecosystem := purlEcosystems[purlType]
+		runtime := runtimeEOLProducts[ecosystem]
+		// Go's standard library stands for the toolchain in Go SBOMs.
+		if rest == runtime[0] || ecosystem == "go" && rest == "stdlib" {
+			return runtime[1], true
+		}
+		// Frameworks are known by their full name, namespace included.
+		if product, ok := frameworkEOLProducts[ecosystem][rest]; ok {
+			return product, true
+		}
+	}
+	return "", false
+}
+
+func sbomTargetFromQuery(query url.Values) SBOMTarget {
+	return SBOMTarget{
+		DataCenter: query.Get("data_center"),
+		HostIP:     query.Get("host_ip"),
+		Hostname:   query.Get("hostname"),
+		MachineID:  query.Get("machine_id"),
+		Team:       query.Get("team"),
+		Artifact:   query.Get("artifact"),
+		Source:     query.Get("source"),
+	}
+}
+
+// violations reports a target that names neither or both of a host and an
+// artifact.
+func (target SBOMTarget) violations() []Violation {
+	violations := validate(target)
+	host := target.DataCenter != "" || target.HostIP != ""
+	switch {
+	case target.Artifact != "" && host:
+		violations = append(violations, Violation{Field: "artifact", Rule: "owner", Message: "can't be combined with data_center and host_ip"})
+	case target.Artifact == "" && (target.DataCenter == "" || target.HostIP == ""):
+		violations = append(violations, Violation{Field: "artifact", Rule: "owner", Message: "is required unless data_center and host_ip are set"})
+	}
+	return violations
+}
+
+// storableComponents returns the components that can be stored as packages,
+// and why the others, such as a name with spaces, are skipped. Pipelines
+// can't edit the SBOMs they generate, so one odd component doesn't reject
+// the rest.
+func storableComponents(components []SBOMComponent) ([]SBOMComponent, []Violation) {
+	var storable []SBOMComponent
+	var skipped []Violation
+	for _, component := range components {
+		violations := validate(component)
+		if len(violations) == 0 {
+			storable = append(storable, component)
+			continue
+		}
+		for _, violation := range violations {
+			violation.Field = joinPath(component.path, violation.Field)
+			skipped = append(skipped, violation)
+		}
+	}
+	return storable, skipped
+}
+
+// packageVersions returns the push the SBOM amounts to. A name listed with
+// several versions keeps every version after the first as
+// "<name>@<version>"; components without a version are left out.
+func (target SBOMTarget) packageVersions(components []SBOMComponent) PackageVersions {
+	source := target.Source
+	if source == "" {
+		source = SBOMSource
+	}
+	packages := make(map[string]PackageDetail)
+	for _, component := range components {
+		if !knownVersion(component.Version) {
+			continue
+		}
+		name := component.Name
+		if _, ok := packages[name]; ok {
+			name += "@" + component.Version
+		}
+		detail := PackageDetail{CurrentVersion: component.Version, Purl: component.Purl, Type: component.Type}
+		detail.EOLProduct, _ = purlProduct(component.Purl)
+		packages[name] = detail
+	}
+
+	return PackageVersions{
+		DataCenterPkg: target.DataCenter,
+		HostIPPkg:     target.HostIP,
+		Hostname:      target.Hostname,
+		MachineID:     target.MachineID,
+		Team:          target.Team,
+		Artifact:      target.Artifact,
+		Source:        source,
+		Packages:      packages,
+	}
+}
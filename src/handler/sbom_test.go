@@ -0,0 +1,148 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestDecodeSBOM(t *testing.T) {
+	components, err := decodeSBOM([]byte(`{
+		"bomFormat": "CycloneDX",
+		"specVersion": "1.5",
+		"components": [
+			{"type": "application", "name": "api", "version": "1.4.2", "components": [
+				{"type": "library", "group": "@babel", "name": "core", "version": "7.24.0", "purl": "pkg:npm/%40babel/core@7.24.0"}
+			]},
+			{"type": "Library", "name": "redis-server", "version": "5:7.0.15-1~deb12u1", "purl": "pkg:deb/debian/redis-server@5:7.0.15-1~deb12u1?arch=amd64"}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []SBOMComponent{
+		{Name: "api", Version: "1.4.2", Type: "application", path: "components[0]"},
+		{Name: "babel/core", Version: "7.24.0", Purl: "pkg:npm/%40babel/core@7.24.0", Type: "library", path: "components[0].components[0]"},
+		{Name: "redis-server", Version: "5:7.0.15-1~deb12u1", Purl: "pkg:deb/debian/redis-server@5:7.0.15-1~deb12u1?arch=amd64", Type: "library", path: "components[1]"},
+	}
+	if len(components) != len(expected) {
+		t.Fatalf("expected %d components, got %+v", len(expected), components)
+	}
+	for i, want := range expected {
+		if components[i] != want {
+			t.Errorf("component %d: expected %+v, got %+v", i, want, components[i])
+		}
+	}
+
+	components, err = decodeSBOM([]byte(`{
+		"spdxVersion": "SPDX-2.3",
+		"packages": [
+			{"name": "openssl", "versionInfo": "3.0.13", "primaryPackagePurpose": "LIBRARY",
+			 "externalRefs": [{"referenceType": "cpe23Type", "referenceLocator": "cpe:2.3:a:openssl:openssl:3.0.13"},
+			                  {"referenceType": "purl", "referenceLocator": "pkg:generic/openssl@3.0.13"}]}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(components) != 1 || components[0].Purl != "pkg:generic/openssl@3.0.13" || components[0].Type != "library" || components[0].path != "packages[0]" {
+		t.Errorf("unexpected SPDX components: %+v", components)
+	}
+
+	if _, err := decodeSBOM([]byte(`{"packages": []}`)); err != ErrUnsupportedSBOM {
+		t.Errorf("expected ErrUnsupportedSBOM, got %v", err)
+	}
+}
+
+func TestPurlProduct(t *testing.T) {
+	cases := map[string]string{
+		"pkg:deb/debian/redis-server@5:7.0.15-1~deb12u1?arch=amd64": "redis",
+		"pkg:docker/library/postgres@16.2":                          "postgresql",
+		"pkg:generic/mongodb@7.0.6":                                 "mongodb",
+		"pkg:npm/%40babel/core@7.24.0":                              "",
+		"pkg:pypi/requests@2.31.0":                                  "",
+		"pkg:npm/%40angular/core@17.3.0":                            "angular",
+		"pkg:pypi/Django@4.2.11":                                    "django",
+		"pkg:composer/laravel/framework@10.48.4":                    "laravel",
+		"pkg:golang/stdlib@1.22.1":                                  "go",
+		"pkg:npm/redis@4.6.13":                                      "",
+		"pkg:deb/debian/react@18.2.0":                               "",
+		"redis@7.2.4":                                               "",
+	}
+	for purl, want := range cases {
+		product, ok := purlProduct(purl)
+		if product != want || ok != (want != "") {
+			t.Errorf("%s: expected %q, got %q/%t", purl, want, product, ok)
+		}
+	}
+}
+
+func TestHandleInsertSBOM_StoresArtifact(t *testing.T) {
+	ctx := context.Background()
+	con := newTestClient(t)
+	// Only redis is seeded: a lookup of any other supported product would
+	// fetch it from endoflife.date.
+	seedEOLCache(t, con, `{"redis":[{"cycle":"7.0","eol":"2099-07-29","latest":"7.0.15"}]}`)
+	p := &PackageVersionsHandler{
+		PackageVersions: &PackageVersionss{Items: make(map[uuid.UUID]PackageVersions)},
+		Client:          con,
+		Context:         ctx,
+		ApiToken:        "secret",
+		TTL:             300,
+	}
+	put := func(query, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/sbom?"+query, strings.NewReader(body))
+		req.Header.Set("x-api-token", "secret")
+		rec := httptest.NewRecorder()
+		p.SBOMHandler()(rec, req)
+		return rec
+	}
+
+	rec := put("artifact=registry.example.com/api:1.4.2", `{
+		"bomFormat": "CycloneDX",
+		"components": [
+			{"type": "library", "name": "redis-server", "version": "7.0.15", "purl": "pkg:deb/debian/redis-server@7.0.15"},
+			{"type": "library", "name": "left-pad", "version": "1.3.0", "purl": "pkg:npm/left-pad@1.3.0"},
+			{"type": "library", "name": "left-pad", "version": "1.1.0", "purl": "pkg:npm/left-pad@1.1.0"},
+			{"type": "library", "name": "Visual C++ (x64)", "version": "14.38"}
+		]
+	}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var res SBOMResponseDocument
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatalf("unexpected error decoding response: %v", err)
+	}
+	if len(res.Skipped) != 1 || res.Skipped[0].Field != "components[3].name" {
+		t.Errorf("expected the component with an invalid name to be skipped, got %+v", res.Skipped)
+	}
+
+	stored, err := p.PackageVersions.Retrieve(UUIDFromArtifact("registry.example.com/api:1.4.2"), ctx, con)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := stored.Sources[SBOMSource]; stored.Artifact != "registry.example.com/api:1.4.2" || !ok {
+		t.Errorf("expected the artifact under source %q, got %+v", SBOMSource, stored)
+	}
+	redis := stored.Packages["redis-server"]
+	if redis.EOLProduct != "redis" || redis.CurrentVersionEoF != "2099-07-29" {
+		t.Errorf("expected redis-server to be enriched as redis, got %+v", redis)
+	}
+	if pkg := stored.Packages["left-pad"]; pkg.EOLProduct != "" || pkg.NewestVersion != "unknown" || pkg.Purl != "pkg:npm/left-pad@1.3.0" {
+		t.Errorf("expected left-pad without EOL data, got %+v", pkg)
+	}
+	if _, ok := stored.Packages["left-pad@1.1.0"]; !ok {
+		t.Errorf("expected the second left-pad version to be kept, got %+v", stored.Packages)
+	}
+
+	rec = put("data_center=dc1", `{"spdxVersion": "SPDX-2.3", "packages": []}`)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status 422 without a host or artifact, got %d", rec.Code)
+	}
+}
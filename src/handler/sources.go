@@ -0,0 +1,169 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Several agents may report packages for the same host, e.g. a dpkg agent
+// and a container runtime agent. Each push names its source; the host record
+// keeps every source's package set in Sources and the merged view in
+// Packages, where each entry carries the source it came from. When two
+// sources report the same package the most recent push wins.
+
+const DefaultSource = "default"
+
+type SourcePackages struct {
+	Packages  map[string]PackageDetail `json:"packages"`
+	UpdatedAt string                   `json:"updated_at"`
+}
+
+// sourceName returns the source a push is recorded under.
+func sourceName(source string) string {
+	if source == "" {
+		return DefaultSource
+	}
+	return source
+}
+
+// decodeHostRecord unmarshals a stored host record, or returns an empty one
+// for nil. Records written before sources existed are read as a single
+// default source.
+func decodeHostRecord(data []byte) (PackageVersions, error) {
+	var pkg PackageVersions
+	var err error
+	if data != nil {
+		if err = json.Unmarshal(data, &pkg); err != nil {
+			pkg, err = PackageVersions{}, ErrMarshalFailedPackage
+		}
+	}
+	if len(pkg.Sources) == 0 {
+		pkg.Sources = make(map[string]SourcePackages)
+		if len(pkg.Packages) > 0 {
+			pkg.Sources[DefaultSource] = SourcePackages{Packages: pkg.Packages, UpdatedAt: pkg.UpdatedAt}
+		}
+	}
+	return pkg, err
+}
+
+// mergeSource records the enriched push pkg as its source's package set in
+// the stored record current (nil when there is none) and returns the updated
+// record and its serialized form. Packages naming their own source are
+// merged into that source's set instead, which keeps the rest of its
+// packages. Other sources that have not pushed within ttl seconds are
+// dropped.
+func mergeSource(current []byte, pkg PackageVersions, ttl int, now time.Time) (PackageVersions, []byte, error) {
+	stored, err := decodeHostRecord(current)
+	if err != nil {
+		log.Printf("Replacing corrupt host record %s: %v", pkg.IDPkg, err)
+	}
+
+	source := sourceName(pkg.Source)
+	updatedAt := fmt.Sprint(now.Unix())
+	pushed := map[string]map[string]PackageDetail{source: {}}
+	for name, detail := range pkg.Packages {
+		packageSource := source
+		if detail.Source != "" {
+			packageSource = detail.Source
+		}
+		if pushed[packageSource] == nil {
+			pushed[packageSource] = make(map[string]PackageDetail)
+			for kept, keptDetail := range stored.Sources[packageSource].Packages {
+				pushed[packageSource][kept] = keptDetail
+			}
+		}
+		detail.Source = ""
+		pushed[packageSource][name] = detail
+	}
+	for name, packages := range pushed {
+		stored.Sources[name] = SourcePackages{Packages: packages, UpdatedAt: updatedAt}
+	}
+	for name, other := range stored.Sources {
+		if seen, err := strconv.ParseInt(other.UpdatedAt, 10, 64); pushed[name] == nil && (err != nil || seen+int64(ttl) < now.Unix()) {
+			delete(stored.Sources, name)
+		}
+	}
+
+	stored.IDPkg = pkg.IDPkg
+	stored.DataCenterPkg = pkg.DataCenterPkg
+	stored.HostIPPkg = pkg.HostIPPkg
+	stored.Artifact = pkg.Artifact
+	if pkg.Team != "" {
+		stored.Team = pkg.Team
+	}
+	if pkg.Hostname != "" {
+		stored.Hostname = pkg.Hostname
+	}
+	if pkg.MachineID != "" {
+		stored.MachineID = pkg.MachineID
+	}
+	if len(pkg.Identity) > 0 {
+		stored.Identity = pkg.Identity
+	}
+	if pkg.Labels != nil {
+		stored.Labels = pkg.Labels
+	}
+	if pkg.OS != nil {
+		stored.OS = pkg.OS
+	}
+	return finishHostRecord(stored, source, updatedAt)
+}
+
+// finishHostRecord recomputes the merged package view after a write by
+// source and serializes pkg.
+func finishHostRecord(pkg PackageVersions, source string, updatedAt string) (PackageVersions, []byte, error) {
+	pkg.Source = ""
+	pkg.UpdatedAt = updatedAt
+	pkg.Packages = mergedPackages(pkg.Sources, source)
+
+	data, err := json.Marshal(pkg)
+	if err != nil {
+		return pkg, nil, ErrMarshalFailedPackage
+	}
+	return pkg, data, nil
+}
+
+// mergedPackages flattens the sources into one package map, letting the most
+// recently updated source win and tagging every entry with its source.
+// Within the same second, latest (the source just written) wins.
+func mergedPackages(sources map[string]SourcePackages, latest string) map[string]PackageDetail {
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, _ := strconv.ParseInt(sources[names[i]].UpdatedAt, 10, 64)
+		b, _ := strconv.ParseInt(sources[names[j]].UpdatedAt, 10, 64)
+		switch {
+		case a != b:
+			return a < b
+		case names[i] == latest || names[j] == latest:
+			return names[j] == latest
+		default:
+			return names[i] < names[j]
+		}
+	})
+
+	merged := make(map[string]PackageDetail)
+	for _, name := range names {
+		for pkgName, detail := range sources[name].Packages {
+			detail.Source = name
+			merged[pkgName] = detail
+		}
+	}
+	return merged
+}
+
+// SourceNames returns the sources that contributed to pkg, sorted.
+func (pkg PackageVersions) SourceNames() []string {
+	names := make([]string, 0, len(pkg.Sources))
+	for name := range pkg.Sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
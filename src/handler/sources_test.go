@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func hostPush(source string, packages map[string]string) PackageVersions {
+	pkg := PackageVersions{
+		DataCenterPkg: "dc1",
+		HostIPPkg:     "10.0.0.1",
+		Source:        source,
+		Packages:      make(map[string]PackageDetail),
+	}
+	for name, version := range packages {
+		pkg.Packages[name] = PackageDetail{CurrentVersion: version}
+	}
+	return pkg
+}
+
+func TestPackageVersionsInsert_KeepsEverySource(t *testing.T) {
+	ctx := context.Background()
+	con := newTestClient(t)
+	c := &PackageVersionss{Items: make(map[uuid.UUID]PackageVersions)}
+
+	if _, err := c.Insert(hostPush("dpkg", map[string]string{"openssl": "3.0.11", "redis": "7.0.15"}), ctx, con, noopQuery, 60); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	id, err := c.Insert(hostPush("containerd", map[string]string{"nginx": "1.25.3", "redis": "7.2.4"}), ctx, con, noopQuery, 60)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	stored, err := c.Retrieve(id, ctx, con)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := stored.SourceNames(); len(got) != 2 || got[0] != "containerd" || got[1] != "dpkg" {
+		t.Errorf("expected both sources, got %v", got)
+	}
+	want := map[string]string{"openssl": "dpkg", "nginx": "containerd", "redis": "containerd"}
+	if len(stored.Packages) != len(want) {
+		t.Fatalf("expected %d merged packages, got %+v", len(want), stored.Packages)
+	}
+	for name, source := range want {
+		if stored.Packages[name].Source != source {
+			t.Errorf("%s: expected source %q, got %q", name, source, stored.Packages[name].Source)
+		}
+	}
+	if stored.Packages["redis"].CurrentVersion != "7.2" {
+		t.Errorf("expected the latest push to win for redis, got %s", stored.Packages["redis"].CurrentVersion)
+	}
+
+	// A new push of one source replaces only that source's packages.
+	if _, err := c.Insert(hostPush("dpkg", map[string]string{"openssl": "3.0.13"}), ctx, con, noopQuery, 60); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stored, _ = c.Retrieve(id, ctx, con)
+	if _, ok := stored.Packages["nginx"]; !ok || len(stored.Packages) != 3 {
+		t.Errorf("expected containerd packages to be kept, got %+v", stored.Packages)
+	}
+}
+
+func TestMergeSource_ReadsLegacyRecordsAndDropsStaleSources(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	legacy, _ := json.Marshal(PackageVersions{
+		DataCenterPkg: "dc1",
+		HostIPPkg:     "10.0.0.1",
+		UpdatedAt:     "1699999990",
+		Packages:      map[string]PackageDetail{"redis": {CurrentVersion: "7.0"}},
+	})
+
+	merged, data, err := mergeSource(legacy, hostPush("containerd", nil), 60, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if merged.Packages["redis"].Source != DefaultSource {
+		t.Errorf("expected the legacy packages to become the default source, got %+v", merged.Packages)
+	}
+
+	merged, _, _ = mergeSource(data, hostPush("containerd", nil), 60, now.Add(2*time.Minute))
+	if _, ok := merged.Sources[DefaultSource]; ok {
+		t.Errorf("expected the stale default source to be dropped, got %v", merged.SourceNames())
+	}
+}
+
+func TestMergeSource_PerItemSourceKeepsThatSourcesOtherPackages(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	_, data, _ := mergeSource(nil, hostPush("containerd", map[string]string{"nginx": "1.25", "etcd": "3.5"}), 60, now)
+
+	push := hostPush("dpkg", map[string]string{"openssl": "3.0"})
+	push.Packages["redis"] = PackageDetail{CurrentVersion: "7.2", Source: "containerd"}
+	merged, _, err := mergeSource(data, push, 60, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := merged.Sources["containerd"].Packages; len(got) != 3 {
+		t.Errorf("expected redis merged into the containerd packages, got %+v", got)
+	}
+	if merged.Packages["openssl"].Source != "dpkg" || merged.Packages["redis"].Source != "containerd" {
+		t.Errorf("expected each package tagged with its source, got %+v", merged.Packages)
+	}
+}
+
+func TestPackageVersionsInsertBatch_MergesSourcesOfTheSameHost(t *testing.T) {
+	ctx := context.Background()
+	con := newTestClient(t)
+	c := &PackageVersionss{Items: make(map[uuid.UUID]PackageVersions)}
+
+	ids, errs := c.InsertBatch([]PackageVersions{
+		hostPush("dpkg", map[string]string{"openssl": "3.0.11"}),
+		hostPush("containerd", map[string]string{"nginx": "1.25.3"}),
+	}, ctx, con, noopQuery, 60)
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("item %d: unexpected error: %v", i, err)
+		}
+	}
+
+	stored, err := c.Retrieve(ids[0], ctx, con)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(stored.Packages) != 2 || len(stored.Sources) != 2 {
+		t.Errorf("expected both sources to be merged, got %+v", stored)
+	}
+}
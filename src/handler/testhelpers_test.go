@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"context"
 	"testing"
 
 	"github.com/alicebob/miniredis/v2"
@@ -12,3 +13,13 @@ func newTestClient(t *testing.T) *redis.Client {
 	mr := miniredis.RunT(t)
 	return redis.NewClient(&redis.Options{Addr: mr.Addr()})
 }
+
+// seedEOLCache stores an endoflife.date cache document so that lookups for
+// the packages it contains never reach the network.
+func seedEOLCache(t *testing.T, con *redis.Client, packages string) {
+	t.Helper()
+	doc := `{"package":` + packages + `}`
+	if err := con.Set(context.Background(), "eol_cache:all_packages", doc, 0).Err(); err != nil {
+		t.Fatalf("failed to seed eol cache: %v", err)
+	}
+}
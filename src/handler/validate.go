@@ -0,0 +1,239 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Ingestion payloads are validated declaratively through struct tags:
+//
+//	validate:"required,ip,max=64,label,url,rfc3339,oneof=a|b"
+//	validate_keys:"max=128,label"  (map fields: rules for every key)
+//
+// Format rules skip empty values; combine them with required when a field
+// must be present. Nested structs, slices and map values are walked and
+// reported with their JSON path, e.g. helm_charts[1].version.
+
+var labelSafe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.:/@+~-]*$`)
+
+type Violation struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// validate returns every violation found in v, in field order.
+func validate(v interface{}) []Violation {
+	var violations []Violation
+	validateValue(reflect.ValueOf(v), "", &violations)
+	return violations
+}
+
+func validateValue(v reflect.Value, path string, out *[]Violation) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			validateValue(v.Elem(), path, out)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			fieldPath := joinPath(path, jsonName(field))
+			value := v.Field(i)
+			if rules, ok := field.Tag.Lookup("validate"); ok {
+				checkRules(value, fieldPath, rules, out)
+			}
+			if rules, ok := field.Tag.Lookup("validate_keys"); ok && value.Kind() == reflect.Map {
+				for _, key := range sortedKeys(value) {
+					checkRules(key, joinPath(fieldPath, key.String()), rules, out)
+				}
+			}
+			validateValue(value, fieldPath, out)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			validateValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i), out)
+		}
+	case reflect.Map:
+		for _, key := range sortedKeys(v) {
+			validateValue(v.MapIndex(key), joinPath(path, key.String()), out)
+		}
+	}
+}
+
+func checkRules(v reflect.Value, path string, rules string, out *[]Violation) {
+	for _, rule := range strings.Split(rules, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		if msg := checkRule(v, name, arg); msg != "" {
+			*out = append(*out, Violation{Field: path, Rule: name, Message: msg})
+		}
+	}
+}
+
+func checkRule(v reflect.Value, name string, arg string) string {
+	if name == "required" {
+		if v.IsZero() || (v.Kind() == reflect.String && strings.TrimSpace(v.String()) == "") {
+			return "is required"
+		}
+		return ""
+	}
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.String || v.String() == "" {
+		return ""
+	}
+
+	str := v.String()
+	switch name {
+	case "ip":
+		if net.ParseIP(str) == nil {
+			return fmt.Sprintf("%q is not a valid IP address", str)
+		}
+	case "max":
+		limit, _ := strconv.Atoi(arg)
+		if utf8.RuneCountInString(str) > limit {
+			return fmt.Sprintf("must be at most %d characters long", limit)
+		}
+	case "label":
+		if !labelSafe.MatchString(str) {
+			return fmt.Sprintf("%q may only contain letters, digits and _.:/@+~-", str)
+		}
+	case "oneof":
+		for _, allowed := range strings.Split(arg, "|") {
+			if str == allowed {
+				return ""
+			}
+		}
+		return fmt.Sprintf("must be one of %s", strings.ReplaceAll(arg, "|", ", "))
+	case "rfc3339":
+		if _, err := time.Parse(time.RFC3339, str); err != nil {
+			return fmt.Sprintf("%q is not an RFC 3339 timestamp", str)
+		}
+	case "url":
+		location, err := url.Parse(str)
+		if err != nil || (location.Scheme != "http" && location.Scheme != "https" && location.Scheme != "file") {
+			return fmt.Sprintf("%q is not an http, https or file URL", str)
+		}
+	}
+	return ""
+}
+
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+func joinPath(path string, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func sortedKeys(m reflect.Value) []reflect.Value {
+	keys := m.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+	})
+	return keys
+}
+
+// unknownFields reports every JSON object key in data that has no
+// counterpart in the type of v, for strict mode. Unlike
+// json.Decoder.DisallowUnknownFields it does not stop at the first one.
+func unknownFields(data []byte, v interface{}) []Violation {
+	var violations []Violation
+	walkUnknown(data, reflect.TypeOf(v), "", &violations)
+	return violations
+}
+
+func walkUnknown(data json.RawMessage, t reflect.Type, path string, out *[]Violation) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(data, &object); err != nil {
+			return
+		}
+		fields := make(map[string]reflect.Type)
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).IsExported() {
+				fields[jsonName(t.Field(i))] = t.Field(i).Type
+			}
+		}
+		for _, key := range objectKeys(object) {
+			fieldType, ok := fields[key]
+			if !ok {
+				*out = append(*out, Violation{Field: joinPath(path, key), Rule: "unknown", Message: "is not a known field"})
+				continue
+			}
+			walkUnknown(object[key], fieldType, joinPath(path, key), out)
+		}
+	case reflect.Slice, reflect.Array:
+		var items []json.RawMessage
+		if err := json.Unmarshal(data, &items); err != nil {
+			return
+		}
+		for i, item := range items {
+			walkUnknown(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i), out)
+		}
+	case reflect.Map:
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(data, &object); err != nil {
+			return
+		}
+		for _, key := range objectKeys(object) {
+			walkUnknown(object[key], t.Elem(), joinPath(path, key), out)
+		}
+	}
+}
+
+func objectKeys(object map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// checkPayload validates v and, in strict mode, also reports the fields of
+// the raw document data that the request shape does not define.
+func checkPayload(data []byte, shape interface{}, v interface{}, strict bool) []Violation {
+	var violations []Violation
+	if strict {
+		violations = unknownFields(data, shape)
+	}
+	return append(violations, validate(v)...)
+}
+
+// validationErrorResponse answers with 422 and the full list of violations.
+func validationErrorResponse(w http.ResponseWriter, r *http.Request, violations []Violation) {
+	problem := newProblem(r, w, http.StatusUnprocessableEntity, CodeValidationFailed, "Validation failed")
+	problem.Violations = violations
+	writeProblemDocument(w, problem)
+}
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func violationFields(violations []Violation) []string {
+	var fields []string
+	for _, v := range violations {
+		fields = append(fields, v.Field+":"+v.Rule)
+	}
+	return fields
+}
+
+func TestValidate_ReportsEveryClusterViolation(t *testing.T) {
+	cluster := KubernetesCluster{
+		Team: "platform team",
+		HelmCharts: []HelmChartData{
+			{ChartName: "redis", Version: "18.1.5", Namespace: "database"},
+			{ChartName: "keepup", Namespace: "monitoring"},
+		},
+	}
+
+	got := strings.Join(violationFields(validate(cluster)), " ")
+	want := "cluster_name:required kube_version:required team:label helm_charts[1].version:required"
+	if got != want {
+		t.Errorf("expected violations %q, got %q", want, got)
+	}
+}
+
+func TestValidate_PackageHostIdentity(t *testing.T) {
+	pkg := packagesFromDocument(PackageDocument{Packages: map[string]string{
+		"host_ip": "101.122.418.4",
+		"redis":   "5:7.0.15-1~deb12u1",
+		"bad pkg": "1.0",
+	}})
+
+	got := strings.Join(violationFields(validate(pkg)), " ")
+	want := "data_center:required host_ip:ip packages.bad pkg:label"
+	if got != want {
+		t.Errorf("expected violations %q, got %q", want, got)
+	}
+}
+
+func TestUnknownFields_ListsAllUnknownKeys(t *testing.T) {
+	data := []byte(`{"cluster_name":"minikube","colour":"blue","helm_charts":[{"chart_name":"redis","chart_url":"x"}]}`)
+
+	got := strings.Join(violationFields(unknownFields(data, KubernetesCluster{})), " ")
+	want := "colour:unknown helm_charts[0].chart_url:unknown"
+	if got != want {
+		t.Errorf("expected violations %q, got %q", want, got)
+	}
+}
+
+func TestHandleInsertCluster_StrictModeReturns422(t *testing.T) {
+	s := &KubernetesClusterMiddleware{
+		Clusters: &KubernetesClusters{Items: make(map[uuid.UUID]KubernetesCluster)},
+		Client:   newTestClient(t),
+		Context:  context.Background(),
+		ApiToken: "secret",
+		TTL:      60,
+		Strict:   true,
+	}
+
+	req := httptest.NewRequest(http.MethodPut, "/helm-cluster", strings.NewReader(`{"cluster_name":"","kube_version":"1.30","extra":true}`))
+	req.Header.Set("x-api-token", "secret")
+	rec := httptest.NewRecorder()
+	s.Handler()(rec, req)
+
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected status 422, got %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != problemContentType {
+		t.Errorf("expected content type %q, got %q", problemContentType, ct)
+	}
+	var doc Problem
+	if err := json.NewDecoder(rec.Body).Decode(&doc); err != nil {
+		t.Fatalf("unexpected error decoding response: %v", err)
+	}
+	got := strings.Join(violationFields(doc.Violations), " ")
+	if got != "extra:unknown cluster_name:required" {
+		t.Errorf("expected both the unknown field and the missing name, got %q", got)
+	}
+}
@@ -3,9 +3,11 @@ package main
 import (
 	"context"
 	"fmt"
+	"keepup/src/api"
 	"keepup/src/config"
 	"keepup/src/handler"
 	"keepup/src/metrics"
+	"keepup/src/notify"
 	"log"
 	"net/http"
 	"os"
@@ -23,11 +25,17 @@ import (
 )
 
 var (
-	server             *http.Server
-	shutdownWaiter     sync.WaitGroup
-	PackageHandler     *handler.PackageVersionsHandler
-	kubeClusterHandler *handler.KubernetesClusterMiddleware
-	buildVersion       string
+	server              *http.Server
+	shutdownWaiter      sync.WaitGroup
+	PackageHandler      *handler.PackageVersionsHandler
+	kubeClusterHandler  *handler.KubernetesClusterMiddleware
+	imagesHandler       *handler.ContainerImagesMiddleware
+	dependenciesHandler *handler.ApplicationDependenciesMiddleware
+	certificatesHandler *handler.CertificatesMiddleware
+	customHandlers      []*handler.CustomDomainMiddleware
+	missingHandler      *handler.MissingEntitiesHandler
+	notifier            *notify.Notifier
+	buildVersion        string
 )
 
 func main() {
@@ -41,42 +49,216 @@ func main() {
 		Addr: fmt.Sprintf("%s:%s", config.GetConfig().REDIS_ADDR, config.GetConfig().REDIS_PORT),
 		DB:   db,
 	})
+	if err := handler.MigrateLegacyKeys(ctx, con); err != nil {
+		log.Fatalf("Can't migrate host and cluster keys: %v", err)
+	}
 
 	ttlSeconds, err := strconv.Atoi(config.GetConfig().TTL_SECONDS)
 	if err != nil {
 		log.Fatalf("Can't configure TTL_SECONDS: %v", err)
 	}
 
+	packageTTL := optionalInt("PACKAGE_TTL_SECONDS", config.GetConfig().PACKAGE_TTL_SECONDS, ttlSeconds)
+	helmTTL := optionalInt("HELM_TTL_SECONDS", config.GetConfig().HELM_TTL_SECONDS, ttlSeconds)
+	imageTTL := optionalInt("IMAGE_TTL_SECONDS", config.GetConfig().IMAGE_TTL_SECONDS, ttlSeconds)
+	dependencyTTL := optionalInt("DEPENDENCY_TTL_SECONDS", config.GetConfig().DEPENDENCY_TTL_SECONDS, ttlSeconds)
+	certificateTTL := optionalInt("CERTIFICATE_TTL_SECONDS", config.GetConfig().CERTIFICATE_TTL_SECONDS, ttlSeconds)
+	maxTTL := optionalInt("MAX_TTL_SECONDS", config.GetConfig().MAX_TTL_SECONDS, 0)
+	bodyLimits := handler.BodyLimits{
+		Max:             int64(optionalInt("MAX_BODY_BYTES", config.GetConfig().MAX_BODY_BYTES, 0)),
+		MaxDecompressed: int64(optionalInt("MAX_DECOMPRESSED_BODY_BYTES", config.GetConfig().MAX_DECOMPRESSED_BODY_BYTES, 0)),
+	}
+	maxBatchBody := int64(optionalInt("MAX_BATCH_BODY_BYTES", config.GetConfig().MAX_BATCH_BODY_BYTES, 0))
+	batchLimits := handler.BodyLimits{Max: maxBatchBody, MaxDecompressed: maxBatchBody}
+
+	strict, err := strconv.ParseBool(config.GetConfig().STRICT_VALIDATION)
+	if err != nil {
+		log.Fatalf("Can't configure STRICT_VALIDATION: %v", err)
+	}
+
+	hostIdentity, err := handler.ParseHostIdentity(config.GetConfig().HOST_IDENTITY_FIELDS)
+	if err != nil {
+		log.Fatalf("Can't configure HOST_IDENTITY_FIELDS: %v", err)
+	}
+
+	chartRepositories, err := handler.ParseChartRepositories(config.GetConfig().CHART_REPOSITORIES)
+	if err != nil {
+		log.Fatalf("Can't configure CHART_REPOSITORIES: %v", err)
+	}
+
+	notifier = configureNotifier(ctx, con)
+	eolWarningDays, err := strconv.Atoi(config.GetConfig().EOL_WARNING_DAYS)
+	if err != nil {
+		log.Fatalf("Can't configure EOL_WARNING_DAYS: %v", err)
+	}
+
 	PackageHandler = &handler.PackageVersionsHandler{
 		PackageVersions: &handler.PackageVersionss{
-			Items: make(map[uuid.UUID]handler.PackageVersions),
+			Items:      make(map[uuid.UUID]handler.PackageVersions),
+			Identity:   hostIdentity,
+			EOLEntries: handler.QueryEOLEntries,
 		},
+		Client:      con,
+		Context:     ctx,
+		ApiToken:    config.GetConfig().API_TOKEN,
+		TTL:         packageTTL,
+		MaxTTL:      maxTTL,
+		Strict:      strict,
+		Limits:      bodyLimits,
+		BatchLimits: batchLimits,
+		Notifier:    notifier,
+		EOLWarning:  time.Duration(eolWarningDays) * 24 * time.Hour,
+	}
+
+	kubeClusterHandler = &handler.KubernetesClusterMiddleware{
+		Clusters: &handler.KubernetesClusters{
+			Items:         make(map[uuid.UUID]handler.KubernetesCluster),
+			ChartVersions: chartRepositories.Query,
+			EOLEntries:    handler.QueryEOLEntries,
+		},
+		Context:  ctx,
 		Client:   con,
+		ApiToken: config.GetConfig().API_TOKEN,
+		TTL:      helmTTL,
+		MaxTTL:   maxTTL,
+		Strict:   strict,
+		Limits:   bodyLimits,
+		Notifier: notifier,
+	}
+
+	imagesHandler = &handler.ContainerImagesMiddleware{
+		Images: &handler.ContainerImageInventories{
+			Items:        make(map[uuid.UUID]handler.ContainerImages),
+			HostIdentity: hostIdentity,
+			EOLEntries:   handler.QueryEOLEntries,
+		},
 		Context:  ctx,
+		Client:   con,
 		ApiToken: config.GetConfig().API_TOKEN,
-		TTL:      ttlSeconds,
+		TTL:      imageTTL,
+		MaxTTL:   maxTTL,
+		Strict:   strict,
+		Limits:   bodyLimits,
 	}
 
-	kubeClusterHandler = &handler.KubernetesClusterMiddleware{
-		Clusters: &handler.KubernetesClusters{
-			Items: make(map[uuid.UUID]handler.KubernetesCluster),
+	certificatesHandler = &handler.CertificatesMiddleware{
+		Certificates: &handler.CertificateInventories{
+			Items:        make(map[uuid.UUID]handler.Certificates),
+			HostIdentity: hostIdentity,
 		},
 		Context:  ctx,
 		Client:   con,
 		ApiToken: config.GetConfig().API_TOKEN,
-		TTL:      ttlSeconds,
+		TTL:      certificateTTL,
+		MaxTTL:   maxTTL,
+		Strict:   strict,
+		Limits:   bodyLimits,
+	}
+
+	dependenciesHandler = &handler.ApplicationDependenciesMiddleware{
+		Dependencies: &handler.ApplicationDependencyInventories{
+			Items: make(map[uuid.UUID]handler.ApplicationDependencies),
+		},
+		Context:  ctx,
+		Client:   con,
+		ApiToken: config.GetConfig().API_TOKEN,
+		TTL:      dependencyTTL,
+		MaxTTL:   maxTTL,
+		Limits:   bodyLimits,
+	}
+
+	customDomains, err := handler.ParseCustomDomains(config.GetConfig().CUSTOM_DOMAINS)
+	if err != nil {
+		log.Fatalf("Can't configure CUSTOM_DOMAINS: %v", err)
+	}
+	for _, domain := range customDomains {
+		customTTL := ttlSeconds
+		if domain.TTLSeconds > 0 {
+			customTTL = domain.TTLSeconds
+		}
+		customHandlers = append(customHandlers, &handler.CustomDomainMiddleware{
+			Records: &handler.CustomRecords{
+				Domain: domain,
+				Items:  make(map[uuid.UUID]handler.CustomRecord),
+			},
+			Context:  ctx,
+			Client:   con,
+			ApiToken: config.GetConfig().API_TOKEN,
+			TTL:      customTTL,
+			MaxTTL:   maxTTL,
+			Limits:   bodyLimits,
+		})
+	}
+
+	missingGrace := config.GetConfig().MISSING_GRACE_SECONDS
+	missingHandler = &handler.MissingEntitiesHandler{
+		Client:   con,
+		Context:  ctx,
+		ApiToken: config.GetConfig().API_TOKEN,
+		Grace: map[string]time.Duration{
+			handler.EntityKindHost:    time.Duration(optionalInt("MISSING_GRACE_SECONDS", missingGrace, packageTTL/2)) * time.Second,
+			handler.EntityKindCluster: time.Duration(optionalInt("MISSING_GRACE_SECONDS", missingGrace, helmTTL/2)) * time.Second,
+		},
+	}
+	if missingGrace == "" {
+		// Without an explicit grace an entity is missing once half of the
+		// TTL its last push was stored with has passed, well before the
+		// record is evicted.
+		missingHandler.GraceTTLFraction = 0.5
+	}
+
+	watcher := &handler.MissingWatcher{
+		Entities: missingHandler,
+		Notifier: notifier,
+		Interval: time.Minute,
+	}
+	go watcher.Run(make(chan struct{}))
+
+	metricLabels, err := metrics.ParseExportedLabels(config.GetConfig().METRIC_LABELS)
+	if err != nil {
+		log.Fatalf("Can't configure METRIC_LABELS: %v", err)
 	}
 
 	packageCollector := metrics.PackageVersionsCollector{
 		PackageInfo: PackageHandler,
+		Labels:      metricLabels,
 	}
 
 	HelmCollector := metrics.KubernetesClusterCollector{
 		ClusterInfo: kubeClusterHandler,
+		Labels:      metricLabels,
+	}
+
+	imageCollector := metrics.ContainerImageCollector{
+		ImageInfo: imagesHandler,
+		Labels:    metricLabels,
+	}
+
+	certificateCollector := metrics.CertificateCollector{
+		CertificateInfo: certificatesHandler,
+		Labels:          metricLabels,
+	}
+
+	dependencyCollector := metrics.ApplicationDependencyCollector{
+		DependencyInfo: dependenciesHandler,
+	}
+
+	missingCollector := metrics.MissingEntitiesCollector{
+		Entities: missingHandler,
 	}
 
 	prometheus.MustRegister(packageCollector)
 	prometheus.MustRegister(HelmCollector)
+	prometheus.MustRegister(imageCollector)
+	prometheus.MustRegister(certificateCollector)
+	prometheus.MustRegister(dependencyCollector)
+	prometheus.MustRegister(missingCollector)
+	for _, custom := range customHandlers {
+		// A custom domain may be named like a built-in metric.
+		if err := prometheus.Register(metrics.CustomDomainCollector{DomainInfo: custom}); err != nil {
+			log.Fatalf("Can't configure CUSTOM_DOMAINS: %s: %v", custom.Records.Domain.Name, err)
+		}
+	}
 
 	shutdownWaiter.Add(1)
 	configureServer()
@@ -87,6 +269,41 @@ func main() {
 	log.Println("Exiting server")
 }
 
+// optionalInt parses an optional numeric setting, returning fallback when it
+// is empty.
+func optionalInt(name string, raw string, fallback int) int {
+	if raw == "" {
+		return fallback
+	}
+	seconds, err := strconv.Atoi(raw)
+	if err != nil {
+		log.Fatalf("Can't configure %s: %v", name, err)
+	}
+	return seconds
+}
+
+func configureNotifier(ctx context.Context, con *redis.Client) *notify.Notifier {
+	targets, err := notify.ParseTargets(config.GetConfig().WEBHOOK_TARGETS)
+	if err != nil {
+		log.Fatalf("Can't configure WEBHOOK_TARGETS: %v", err)
+	}
+	dedupSeconds, err := strconv.Atoi(config.GetConfig().WEBHOOK_DEDUP_SECONDS)
+	if err != nil {
+		log.Fatalf("Can't configure WEBHOOK_DEDUP_SECONDS: %v", err)
+	}
+	if len(targets) > 0 {
+		log.Printf("Sending notifications to %d webhook target(s).", len(targets))
+	}
+	return &notify.Notifier{
+		Targets:    targets,
+		Client:     con,
+		Context:    ctx,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+		DedupTTL:   time.Duration(dedupSeconds) * time.Second,
+		Backoff:    time.Second,
+	}
+}
+
 func configureServer() {
 	log.Printf("Creating server on port %s.", config.GetConfig().LISTEN_PORT)
 	server = &http.Server{
@@ -97,6 +314,7 @@ func configureServer() {
 	}
 	server.RegisterOnShutdown(func() {
 		log.Println("Shutting down server.")
+		notifier.Wait()
 		handler.FlushBufferOnShutdown(&shutdownWaiter)
 	})
 }
@@ -109,8 +327,15 @@ func initSignalHandler() {
 
 func initRouting() {
 	http.Handle("/metrics", promhttp.Handler())
-	http.HandleFunc("/package-version", PackageHandler.Handler())
-	http.HandleFunc("/helm-cluster", kubeClusterHandler.Handler())
+	api.Handlers{
+		Packages:     PackageHandler,
+		Clusters:     kubeClusterHandler,
+		Images:       imagesHandler,
+		Dependencies: dependenciesHandler,
+		Certificates: certificatesHandler,
+		Missing:      missingHandler,
+		Custom:       customHandlers,
+	}.Register(http.DefaultServeMux)
 	http.HandleFunc("/healthcheck", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("OK"))
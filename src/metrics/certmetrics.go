@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"fmt"
+	"keepup/src/handler"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	IDCertificates         = "id"
+	CertificateOwnerKind   = "owner_kind"
+	CertificateClusterName = "cluster_name"
+	CertificateDataCenter  = "data_center"
+	CertificateHostIP      = "host_ip"
+	CertificateTeam        = "team"
+	CertificateLocation    = "location"
+	CertificateSubject     = "subject"
+	CertificateSANs        = "sans"
+	CertificateIssuer      = "issuer"
+	CertificateSerial      = "serial"
+
+	certificateMetricLabels = []string{
+		IDCertificates,
+		CertificateOwnerKind,
+		CertificateClusterName,
+		CertificateDataCenter,
+		CertificateHostIP,
+		CertificateTeam,
+		CertificateLocation,
+		CertificateSubject,
+		CertificateSANs,
+		CertificateIssuer,
+		CertificateSerial,
+	}
+)
+
+type CertificateCollector struct {
+	CertificateInfo *handler.CertificatesMiddleware
+	Labels          ExportedLabels
+}
+
+func (cc CertificateCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(cc, ch)
+}
+
+func (cc CertificateCollector) Collect(ch chan<- prometheus.Metric) {
+	inventories, err := cc.CertificateInfo.Certificates.Scan(cc.CertificateInfo.Context, cc.CertificateInfo.Client)
+	if err != nil {
+		log.Printf("Failed to scan certificates: %v", err)
+		return
+	}
+
+	desc := cc.Labels.desc("certificate_expiry_timestamp_seconds", "Unix time at which a TLS certificate expires", certificateMetricLabels)
+	for id, inventory := range inventories.Items {
+		labels := cc.Labels.Values(inventory.Labels)
+		for _, cert := range inventory.Certificates {
+			notAfter, err := time.Parse(time.RFC3339, cert.NotAfter)
+			if err != nil {
+				log.Printf("Can't parse not_after %q of certificate %s: %v", cert.NotAfter, cert.Subject, err)
+				continue
+			}
+			values := []string{
+				fmt.Sprint(id),
+				inventory.OwnerKind,
+				inventory.ClusterName,
+				inventory.DataCenter,
+				inventory.HostIP,
+				inventory.Team,
+				cert.Location,
+				cert.Subject,
+				strings.Join(cert.SANs, ","),
+				cert.Issuer,
+				cert.Serial,
+			}
+			ch <- prometheus.MustNewConstMetric(
+				desc,
+				prometheus.GaugeValue,
+				float64(notAfter.Unix()),
+				append(values, labels...)...,
+			)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"fmt"
+	"keepup/src/handler"
+	"log"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// CustomDomainCollector exports a domain declared in CUSTOM_DOMAINS as
+// <name>_info, with one series per entry of each record's item list. Its
+// labels are id, the identity fields and the configured labels.
+type CustomDomainCollector struct {
+	DomainInfo *handler.CustomDomainMiddleware
+}
+
+func (cc CustomDomainCollector) desc() *prometheus.Desc {
+	domain := cc.DomainInfo.Records.Domain
+	labels := append([]string{"id"}, domain.Identity...)
+	return prometheus.NewDesc(
+		domain.Name+"_info",
+		fmt.Sprintf("Information about the %s pushed to %s", domain.Items, domain.Path),
+		append(labels, domain.LabelNames()...),
+		nil,
+	)
+}
+
+func (cc CustomDomainCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cc.desc()
+}
+
+func (cc CustomDomainCollector) Collect(ch chan<- prometheus.Metric) {
+	domain := cc.DomainInfo.Records.Domain
+	records, err := cc.DomainInfo.Records.Scan(cc.DomainInfo.Context, cc.DomainInfo.Client)
+	if err != nil {
+		log.Printf("Failed to scan %s records: %v", domain.Name, err)
+		return
+	}
+
+	desc := cc.desc()
+	// Items that only differ in fields no label is read from would be the
+	// same series.
+	seen := make(map[string]bool)
+	for id, record := range records.Items {
+		identity := append([]string{fmt.Sprint(id)}, domain.IdentityValues(record.Document)...)
+		for _, item := range domain.ItemList(record.Document) {
+			values := append([]string{}, identity...)
+			for _, label := range domain.LabelNames() {
+				values = append(values, domain.LabelValue(record.Document, item, label))
+			}
+			key := strings.Join(values, "\x00")
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 1, values...)
+		}
+	}
+}
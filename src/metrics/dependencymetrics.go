@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"fmt"
+	"keepup/src/handler"
+	"log"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	IDDependencies              = "id"
+	DependencyService           = "service"
+	DependencyEnvironment       = "environment"
+	DependencyTeam              = "team"
+	DependencyManifest          = "manifest"
+	DependencyEcosystem         = "ecosystem"
+	DependencyKind              = "kind"
+	DependencyName              = "dependency"
+	DependencyVersion           = "version"
+	DependencyEOLProduct        = "eol_product"
+	DependencyCurrentVersion    = "current_version"
+	DependencyCurrentVersionEoF = "current_version_eof"
+	DependencyNewestVersion     = "newest_version"
+	DependencyExpired           = "expired"
+
+	applicationDependencyMetricLabels = []string{
+		IDDependencies,
+		DependencyService,
+		DependencyEnvironment,
+		DependencyTeam,
+		DependencyManifest,
+		DependencyEcosystem,
+		DependencyKind,
+		DependencyName,
+		DependencyVersion,
+		DependencyEOLProduct,
+		DependencyCurrentVersion,
+		DependencyCurrentVersionEoF,
+		DependencyNewestVersion,
+		DependencyExpired,
+	}
+)
+
+type ApplicationDependencyCollector struct {
+	DependencyInfo *handler.ApplicationDependenciesMiddleware
+}
+
+func (dc ApplicationDependencyCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(dc, ch)
+}
+
+func (dc ApplicationDependencyCollector) Collect(ch chan<- prometheus.Metric) {
+	inventories, err := dc.DependencyInfo.Dependencies.Scan(dc.DependencyInfo.Context, dc.DependencyInfo.Client)
+	if err != nil {
+		log.Printf("Failed to scan application dependencies: %v", err)
+		return
+	}
+
+	desc := prometheus.NewDesc("application_dependency_info", "Language runtimes and key frameworks of services", applicationDependencyMetricLabels, nil)
+	for id, app := range inventories.Items {
+		for manifest, section := range app.Manifests {
+			for _, dependency := range section.Dependencies {
+				ch <- prometheus.MustNewConstMetric(
+					desc,
+					prometheus.GaugeValue,
+					1.0,
+					fmt.Sprint(id),
+					app.Service,
+					app.Environment,
+					app.Team,
+					manifest,
+					section.Ecosystem,
+					dependency.Kind,
+					dependency.Name,
+					dependency.Version,
+					dependency.EOLProduct,
+					dependency.CurrentVersion,
+					dependency.CurrentVersionEoF,
+					dependency.NewestVersion,
+					fmt.Sprintf("%t", dependency.Expired),
+				)
+			}
+		}
+	}
+}
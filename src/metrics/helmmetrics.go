@@ -4,10 +4,14 @@ import (
 	"fmt"
 	"keepup/src/handler"
 	"log"
+	"math"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+const eolDateLayout = "2006-01-02"
+
 var (
 	IDCluster              = "id"
 	ClusterName            = "cluster_name"
@@ -15,26 +19,84 @@ var (
 	ChartName              = "chart_name"
 	ChartVersion           = "chart_version"
 	ChartNamespace         = "chart_namespace"
+	ReleaseName            = "release_name"
+	AppVersion             = "app_version"
+	ReleaseStatus          = "status"
 	Teamcluster            = "team"
+	Projectcluster         = "project"
+	Environmentcluster     = "environment"
+	Regioncluster          = "region"
+	Providercluster        = "provider"
+	ChartLatestVersion     = "chart_latest_version"
+	ChartOutdated          = "chart_outdated"
+	KubeEOLProduct         = "eol_product"
+	KubeEOLDate            = "eol_date"
+	KubeLatestVersion      = "kube_latest_version"
 	HelmReleaseMetricValue = float64(1)
 
-	kubernetesClusterMetricDesc = prometheus.NewDesc(
-		"kubernetes_cluster_info",
-		"Information about Kubernetes clusters and installed Helm charts",
+	kubernetesClusterMetricLabels = []string{
+		IDCluster,
+		ClusterName,
+		KubeVersion,
+		ChartName,
+		ChartVersion,
+		ChartNamespace,
+		Teamcluster,
+		Projectcluster,
+		Environmentcluster,
+		Regioncluster,
+		Providercluster,
+		ChartLatestVersion,
+		ChartOutdated,
+		ReleaseName,
+		AppVersion,
+	}
+
+	helmReleaseStatusMetricDesc = prometheus.NewDesc(
+		"helm_release_status",
+		"Status of a Helm release: 1 when deployed, 0 otherwise",
+		[]string{
+			IDCluster,
+			ClusterName,
+			ChartNamespace,
+			ReleaseName,
+			ChartName,
+			ChartVersion,
+			ReleaseStatus,
+		}, nil,
+	)
+
+	kubernetesEOLMetricDesc = prometheus.NewDesc(
+		"kubernetes_cluster_eol_days_remaining",
+		"Days until the end of life of a cluster's Kubernetes version, negative once it has passed",
 		[]string{
 			IDCluster,
 			ClusterName,
 			KubeVersion,
+			KubeLatestVersion,
+			KubeEOLProduct,
+			KubeEOLDate,
+		}, nil,
+	)
+
+	chartVersionsBehindMetricDesc = prometheus.NewDesc(
+		"helm_chart_versions_behind",
+		"Number of releases of a chart's repository newer than the deployed version",
+		[]string{
+			IDCluster,
+			ClusterName,
 			ChartName,
 			ChartVersion,
 			ChartNamespace,
-			Teamcluster,
+			ReleaseName,
+			ChartLatestVersion,
 		}, nil,
 	)
 )
 
 type KubernetesClusterCollector struct {
 	ClusterInfo *handler.KubernetesClusterMiddleware
+	Labels      ExportedLabels
 }
 
 func (kc KubernetesClusterCollector) Describe(ch chan<- *prometheus.Desc) {
@@ -49,13 +111,32 @@ func (kc KubernetesClusterCollector) Collect(ch chan<- prometheus.Metric) {
 		return
 	}
 
+	desc := kc.Labels.desc("kubernetes_cluster_info", "Information about Kubernetes clusters and installed Helm charts", kubernetesClusterMetricLabels)
+	now := time.Now()
 	for id, cluster := range clusters.Items {
-
-		for _, chart := range cluster.HelmCharts {
+		labels := kc.Labels.Values(cluster.Labels)
+		if eol, err := time.Parse(eolDateLayout, cluster.KubeVersionEoF); err == nil {
 			ch <- prometheus.MustNewConstMetric(
-				kubernetesClusterMetricDesc,
+				kubernetesEOLMetricDesc,
 				prometheus.GaugeValue,
-				1.0,
+				math.Floor(eol.Sub(now).Hours()/24),
+				fmt.Sprint(id),
+				cluster.ClusterName,
+				cluster.KubeVersion,
+				cluster.KubeLatestVersion,
+				cluster.KubeEOLProduct,
+				cluster.KubeVersionEoF,
+			)
+		}
+
+		for _, chart := range cluster.HelmCharts {
+			// Charts without a repository lookup have no latest version,
+			// and whether they are outdated is unknown.
+			outdated := ""
+			if chart.LatestVersion != "" {
+				outdated = fmt.Sprintf("%t", chart.Outdated)
+			}
+			values := []string{
 				fmt.Sprint(id),
 				cluster.ClusterName,
 				cluster.KubeVersion,
@@ -63,7 +144,55 @@ func (kc KubernetesClusterCollector) Collect(ch chan<- prometheus.Metric) {
 				chart.Version,
 				chart.Namespace,
 				cluster.Team,
+				cluster.Project,
+				cluster.Environment,
+				cluster.Region,
+				cluster.Provider,
+				chart.LatestVersion,
+				outdated,
+				chart.ReleaseName,
+				chart.AppVersion,
+			}
+			ch <- prometheus.MustNewConstMetric(
+				desc,
+				prometheus.GaugeValue,
+				1.0,
+				append(values, labels...)...,
 			)
+
+			if chart.LatestVersion != "" {
+				ch <- prometheus.MustNewConstMetric(
+					chartVersionsBehindMetricDesc,
+					prometheus.GaugeValue,
+					float64(chart.VersionsBehind),
+					fmt.Sprint(id),
+					cluster.ClusterName,
+					chart.ChartName,
+					chart.Version,
+					chart.Namespace,
+					chart.ReleaseName,
+					chart.LatestVersion,
+				)
+			}
+
+			if chart.Status != "" {
+				deployed := 0.0
+				if chart.Status == "deployed" {
+					deployed = 1.0
+				}
+				ch <- prometheus.MustNewConstMetric(
+					helmReleaseStatusMetricDesc,
+					prometheus.GaugeValue,
+					deployed,
+					fmt.Sprint(id),
+					cluster.ClusterName,
+					chart.Namespace,
+					chart.ReleaseName,
+					chart.ChartName,
+					chart.Version,
+					chart.Status,
+				)
+			}
 		}
 	}
 }
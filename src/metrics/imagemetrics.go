@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"fmt"
+	"keepup/src/handler"
+	"log"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	IDImages               = "id"
+	ImageOwnerKind         = "owner_kind"
+	ImageClusterName       = "cluster_name"
+	ImageDataCenter        = "data_center"
+	ImageHostIP            = "host_ip"
+	ImageTeam              = "team"
+	ImageNamespace         = "namespace"
+	ImageWorkload          = "workload"
+	ImageContainer         = "container"
+	ImageRepository        = "repository"
+	ImageTag               = "tag"
+	ImageDigest            = "digest"
+	ImageEOLProduct        = "eol_product"
+	ImageCurrentVersion    = "current_version"
+	ImageCurrentVersionEoF = "current_version_eol"
+	ImageNewestVersion     = "newest_version"
+	ImageExpired           = "expired"
+
+	containerImageMetricLabels = []string{
+		IDImages,
+		ImageOwnerKind,
+		ImageClusterName,
+		ImageDataCenter,
+		ImageHostIP,
+		ImageTeam,
+		ImageNamespace,
+		ImageWorkload,
+		ImageContainer,
+		ImageRepository,
+		ImageTag,
+		ImageDigest,
+		ImageEOLProduct,
+		ImageCurrentVersion,
+		ImageCurrentVersionEoF,
+		ImageNewestVersion,
+		ImageExpired,
+	}
+)
+
+type ContainerImageCollector struct {
+	ImageInfo *handler.ContainerImagesMiddleware
+	Labels    ExportedLabels
+}
+
+func (ic ContainerImageCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(ic, ch)
+}
+
+func (ic ContainerImageCollector) Collect(ch chan<- prometheus.Metric) {
+	inventories, err := ic.ImageInfo.Images.Scan(ic.ImageInfo.Context, ic.ImageInfo.Client)
+	if err != nil {
+		log.Printf("Failed to scan container images: %v", err)
+		return
+	}
+
+	desc := ic.Labels.desc("container_image_info", "Container images running on clusters and hosts", containerImageMetricLabels)
+	for id, inventory := range inventories.Items {
+		labels := ic.Labels.Values(inventory.Labels)
+		for _, image := range inventory.Images {
+			// Images that map onto no product have unknown expiry.
+			expired := ""
+			if image.EOLProduct != "" {
+				expired = fmt.Sprintf("%t", image.Expired)
+			}
+			values := []string{
+				fmt.Sprint(id),
+				inventory.OwnerKind,
+				inventory.ClusterName,
+				inventory.DataCenter,
+				inventory.HostIP,
+				inventory.Team,
+				image.Namespace,
+				image.Workload,
+				image.Container,
+				image.Repository,
+				image.Tag,
+				image.Digest,
+				image.EOLProduct,
+				image.CurrentVersion,
+				image.CurrentVersionEoF,
+				image.NewestVersion,
+				expired,
+			}
+			ch <- prometheus.MustNewConstMetric(
+				desc,
+				prometheus.GaugeValue,
+				1.0,
+				append(values, labels...)...,
+			)
+		}
+	}
+}
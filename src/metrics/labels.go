@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Agents may attach any labels to hosts and clusters, but only the ones
+// allow-listed with METRIC_LABELS are exported, so a typo in an agent can't
+// blow up the metrics' cardinality. Exported labels are prefixed with
+// "label_" to keep them apart from the fixed label set.
+
+const labelPrefix = "label_"
+
+var invalidLabelChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+type ExportedLabels struct {
+	keys  []string
+	names []string
+}
+
+// ParseExportedLabels parses a comma separated METRIC_LABELS value.
+func ParseExportedLabels(raw string) (ExportedLabels, error) {
+	var labels ExportedLabels
+	if strings.TrimSpace(raw) == "" {
+		return labels, nil
+	}
+
+	seen := make(map[string]string)
+	for _, key := range strings.Split(raw, ",") {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			return ExportedLabels{}, fmt.Errorf("empty label in %q", raw)
+		}
+		name := LabelName(key)
+		if other, ok := seen[name]; ok {
+			return ExportedLabels{}, fmt.Errorf("labels %q and %q are both exported as %s", other, key, name)
+		}
+		seen[name] = key
+		labels.keys = append(labels.keys, key)
+		labels.names = append(labels.names, name)
+	}
+	return labels, nil
+}
+
+// LabelName returns the Prometheus label name a user-defined label is
+// exported as.
+func LabelName(key string) string {
+	return labelPrefix + invalidLabelChars.ReplaceAllString(key, "_")
+}
+
+// Names returns the exported label names, in METRIC_LABELS order.
+func (l ExportedLabels) Names() []string {
+	return l.names
+}
+
+// Values returns the values of the exported labels in labels, "" for the
+// ones not set.
+func (l ExportedLabels) Values(labels map[string]string) []string {
+	values := make([]string, len(l.keys))
+	for i, key := range l.keys {
+		values[i] = labels[key]
+	}
+	return values
+}
+
+// desc returns the description of an info metric with the fixed labels
+// followed by the exported ones.
+func (l ExportedLabels) desc(name string, help string, fixed []string) *prometheus.Desc {
+	labels := make([]string, 0, len(fixed)+len(l.names))
+	labels = append(labels, fixed...)
+	return prometheus.NewDesc(name, help, append(labels, l.names...), nil)
+}
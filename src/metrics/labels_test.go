@@ -0,0 +1,35 @@
+package metrics
+
+import "testing"
+
+func TestParseExportedLabels_SanitizesNames(t *testing.T) {
+	labels, err := ParseExportedLabels("environment, cost-center,team.owner")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []string{"label_environment", "label_cost_center", "label_team_owner"}
+	names := labels.Names()
+	if len(names) != len(want) {
+		t.Fatalf("expected %v, got %v", want, names)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("label %d: expected %s, got %s", i, want[i], names[i])
+		}
+	}
+
+	values := labels.Values(map[string]string{"cost-center": "cc-42", "ignored": "x"})
+	if len(values) != 3 || values[0] != "" || values[1] != "cc-42" || values[2] != "" {
+		t.Errorf("expected only cost-center to be set, got %q", values)
+	}
+}
+
+func TestParseExportedLabels_RejectsCollisions(t *testing.T) {
+	if _, err := ParseExportedLabels("cost-center,cost_center"); err == nil {
+		t.Errorf("expected labels that sanitize to the same name to be rejected")
+	}
+	if labels, err := ParseExportedLabels(""); err != nil || len(labels.Names()) != 0 {
+		t.Errorf("expected no labels, got %v (err %v)", labels.Names(), err)
+	}
+}
@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"fmt"
+	"keepup/src/handler"
+	"log"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	MissingKind = "kind"
+	MissingID   = "id"
+	MissingName = "name"
+	MissingTeam = "team"
+
+	entityMissingMetricDesc = prometheus.NewDesc(
+		"keepup_entity_missing",
+		"Hosts and clusters that have not reported within their grace period",
+		[]string{
+			MissingKind,
+			MissingID,
+			MissingName,
+			MissingTeam,
+		}, nil,
+	)
+)
+
+type MissingEntitiesCollector struct {
+	Entities *handler.MissingEntitiesHandler
+}
+
+func (mc MissingEntitiesCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(mc, ch)
+}
+
+func (mc MissingEntitiesCollector) Collect(ch chan<- prometheus.Metric) {
+	missing, err := mc.Entities.Missing()
+	if err != nil {
+		log.Printf("Failed to list missing entities: %v", err)
+		return
+	}
+
+	for _, entity := range missing {
+		ch <- prometheus.MustNewConstMetric(
+			entityMissingMetricDesc,
+			prometheus.GaugeValue,
+			1.0,
+			entity.Kind,
+			fmt.Sprint(entity.ID),
+			entity.Name,
+			entity.Team,
+		)
+	}
+}
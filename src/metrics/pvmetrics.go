@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"keepup/src/handler"
 	"log"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -18,26 +19,70 @@ var (
 	DataCenterpkg     = "data_center"
 	HostIPpkg         = "host_ip"
 	Teampkg           = "team"
+	Sourcepkg         = "source"
+	Hostnamepkg       = "hostname"
+	Artifactpkg       = "artifact"
+	OSID              = "os_id"
+	OSVersion         = "os_version"
+	OSCodename        = "os_codename"
+	OSKernel          = "kernel"
+	OSArch            = "arch"
+	OSEOLProduct      = "eol_product"
+	OSVersionEoF      = "os_version_eol"
+	OSExpired         = "os_expired"
+	OSKernelCycle     = "kernel_cycle"
+	OSKernelEoF       = "kernel_eol"
+	OSKernelExpired   = "kernel_expired"
 
-	packageMetricDesc = prometheus.NewDesc(
-		"package_version_info",
-		"Metrics for package versions",
+	packageMetricLabels = []string{
+		IDPkg,
+		PackageName,
+		CurrentVersion,
+		CurrentVersionEoF,
+		NewestVersion,
+		Expired,
+		DataCenterpkg,
+		HostIPpkg,
+		Teampkg,
+		Sourcepkg,
+		Hostnamepkg,
+		Artifactpkg,
+	}
+
+	osMetricLabels = []string{
+		IDPkg,
+		DataCenterpkg,
+		HostIPpkg,
+		Teampkg,
+		Hostnamepkg,
+		OSID,
+		OSVersion,
+		OSCodename,
+		OSKernel,
+		OSArch,
+		OSEOLProduct,
+		OSVersionEoF,
+		OSExpired,
+		OSKernelCycle,
+		OSKernelEoF,
+		OSKernelExpired,
+	}
+
+	hostBootTimeMetricDesc = prometheus.NewDesc(
+		"host_boot_time_seconds",
+		"Unix time of a host's last boot",
 		[]string{
 			IDPkg,
-			PackageName,
-			CurrentVersion,
-			CurrentVersionEoF,
-			NewestVersion,
-			Expired,
 			DataCenterpkg,
 			HostIPpkg,
-			Teampkg,
+			Hostnamepkg,
 		}, nil,
 	)
 )
 
 type PackageVersionsCollector struct {
 	PackageInfo *handler.PackageVersionsHandler
+	Labels      ExportedLabels
 }
 
 func (pc PackageVersionsCollector) Describe(ch chan<- *prometheus.Desc) {
@@ -51,12 +96,15 @@ func (pc PackageVersionsCollector) Collect(ch chan<- prometheus.Metric) {
 		return
 	}
 
+	desc := pc.Labels.desc("package_version_info", "Metrics for package versions", packageMetricLabels)
+	osDesc := pc.Labels.desc("os_info", "Operating system and kernel of hosts", osMetricLabels)
 	for id, pkgs := range pkgss.Items {
+		labels := pc.Labels.Values(pkgs.Labels)
+		if pkgs.OS != nil {
+			pc.collectOS(ch, osDesc, fmt.Sprint(id), pkgs, labels)
+		}
 		for packageName, details := range pkgs.Packages {
-			ch <- prometheus.MustNewConstMetric(
-				packageMetricDesc,
-				prometheus.GaugeValue,
-				1.0,
+			values := []string{
 				fmt.Sprint(id),
 				packageName,
 				details.CurrentVersion,
@@ -66,7 +114,64 @@ func (pc PackageVersionsCollector) Collect(ch chan<- prometheus.Metric) {
 				pkgs.DataCenterPkg,
 				pkgs.HostIPPkg,
 				pkgs.Team,
+				details.Source,
+				pkgs.Hostname,
+				pkgs.Artifact,
+			}
+			ch <- prometheus.MustNewConstMetric(
+				desc,
+				prometheus.GaugeValue,
+				1.0,
+				append(values, labels...)...,
 			)
 		}
 	}
 }
+
+func (pc PackageVersionsCollector) collectOS(ch chan<- prometheus.Metric, desc *prometheus.Desc, id string, pkgs handler.PackageVersions, labels []string) {
+	os := pkgs.OS
+	// Distributions and kernels without EOL data have unknown expiry.
+	expired, kernelExpired := "", ""
+	if os.EOLProduct != "" {
+		expired = fmt.Sprintf("%t", os.Expired)
+	}
+	if os.KernelCycle != "" {
+		kernelExpired = fmt.Sprintf("%t", os.KernelExpired)
+	}
+	values := []string{
+		id,
+		pkgs.DataCenterPkg,
+		pkgs.HostIPPkg,
+		pkgs.Team,
+		pkgs.Hostname,
+		os.ID,
+		os.Version,
+		os.Codename,
+		os.Kernel,
+		os.Arch,
+		os.EOLProduct,
+		os.VersionEoF,
+		expired,
+		os.KernelCycle,
+		os.KernelEoF,
+		kernelExpired,
+	}
+	ch <- prometheus.MustNewConstMetric(
+		desc,
+		prometheus.GaugeValue,
+		1.0,
+		append(values, labels...)...,
+	)
+
+	if bootTime, err := time.Parse(time.RFC3339, os.BootTime); err == nil {
+		ch <- prometheus.MustNewConstMetric(
+			hostBootTimeMetricDesc,
+			prometheus.GaugeValue,
+			float64(bootTime.Unix()),
+			id,
+			pkgs.DataCenterPkg,
+			pkgs.HostIPPkg,
+			pkgs.Hostname,
+		)
+	}
+}
@@ -0,0 +1,265 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+type EventKind string
+
+const (
+	EventPackageExpired        EventKind = "package_expired"
+	EventPackageEOLApproaching EventKind = "package_eol_approaching"
+	EventEntityMissing         EventKind = "entity_missing"
+)
+
+const (
+	FormatJSON  = "json"
+	FormatSlack = "slack"
+	FormatTeams = "teams"
+)
+
+const dedupKeyPrefix = "keepup:notify:"
+
+// Event describes a single state transition worth telling somebody about.
+// Package, Version and EOLDate are only set for package events, LastSeen
+// only for entity_missing.
+type Event struct {
+	Kind       EventKind `json:"event"`
+	EntityKind string    `json:"entity_kind"`
+	EntityID   uuid.UUID `json:"entity_id"`
+	Name       string    `json:"name"`
+	Team       string    `json:"team"`
+	DataCenter string    `json:"data_center,omitempty"`
+	Package    string    `json:"package,omitempty"`
+	Version    string    `json:"version,omitempty"`
+	EOLDate    string    `json:"eol_date,omitempty"`
+	LastSeen   string    `json:"last_seen,omitempty"`
+	Message    string    `json:"message"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// Target is a webhook endpoint. Empty filter lists match everything.
+type Target struct {
+	Name        string      `json:"name"`
+	URL         string      `json:"url"`
+	Format      string      `json:"format"`
+	Teams       []string    `json:"teams"`
+	DataCenters []string    `json:"data_centers"`
+	Events      []EventKind `json:"events"`
+	MaxRetries  int         `json:"max_retries"`
+}
+
+var (
+	ErrInvalidTarget   = errors.New("Invalid webhook target")
+	ErrDeliveryFailed  = errors.New("Webhook delivery failed")
+	ErrPermanentFailed = errors.New("Webhook rejected notification")
+)
+
+// defaultMaxRetries applies to targets that don't set max_retries; an
+// explicit 0 disables retries.
+const defaultMaxRetries = 3
+
+// ParseTargets decodes the WEBHOOK_TARGETS JSON array and fills defaults.
+func ParseTargets(raw string) ([]Target, error) {
+	var decoded []struct {
+		Target
+		MaxRetries *int `json:"max_retries"`
+	}
+	if err := json.Unmarshal([]byte(raw), &decoded); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTarget, err)
+	}
+	targets := make([]Target, len(decoded))
+	for i := range decoded {
+		t := &targets[i]
+		*t = decoded[i].Target
+		if t.URL == "" {
+			return nil, fmt.Errorf("%w: target %d has no url", ErrInvalidTarget, i)
+		}
+		if t.Format == "" {
+			t.Format = FormatJSON
+		}
+		if t.Format != FormatJSON && t.Format != FormatSlack && t.Format != FormatTeams {
+			return nil, fmt.Errorf("%w: unknown format %q", ErrInvalidTarget, t.Format)
+		}
+		if t.Name == "" {
+			t.Name = t.URL
+		}
+		switch retries := decoded[i].MaxRetries; {
+		case retries == nil:
+			t.MaxRetries = defaultMaxRetries
+		case *retries < 0:
+			return nil, fmt.Errorf("%w: target %d: max_retries can't be negative", ErrInvalidTarget, i)
+		default:
+			t.MaxRetries = *retries
+		}
+	}
+	return targets, nil
+}
+
+// Matches reports whether the target's filters accept the event.
+func (t Target) Matches(e Event) bool {
+	if len(t.Events) > 0 && !slices.Contains(t.Events, e.Kind) {
+		return false
+	}
+	if len(t.Teams) > 0 && !slices.Contains(t.Teams, e.Team) {
+		return false
+	}
+	if len(t.DataCenters) > 0 && !slices.Contains(t.DataCenters, e.DataCenter) {
+		return false
+	}
+	return true
+}
+
+// Notifier fans events out to the configured targets. Deliveries run in the
+// background; a Redis SET NX claim makes sure that only one replica sends a
+// given event to a given target within DedupTTL.
+type Notifier struct {
+	Targets    []Target
+	Client     *redis.Client
+	Context    context.Context
+	HTTPClient *http.Client
+	DedupTTL   time.Duration
+	Backoff    time.Duration
+
+	pending sync.WaitGroup
+}
+
+func (n *Notifier) Enabled() bool {
+	return n != nil && len(n.Targets) > 0
+}
+
+func (n *Notifier) Notify(events ...Event) {
+	if !n.Enabled() {
+		return
+	}
+	for _, e := range events {
+		for _, t := range n.Targets {
+			if !t.Matches(e) {
+				continue
+			}
+			claimed, err := n.claim(t, e)
+			if err != nil {
+				log.Printf("Can't claim notification %s for %s: %v", e.Kind, e.EntityID, err)
+				continue
+			}
+			if !claimed {
+				continue
+			}
+			n.pending.Add(1)
+			go func(t Target, e Event) {
+				defer n.pending.Done()
+				if err := n.Send(t, e); err != nil {
+					log.Printf("Failed to notify %s about %s for %s: %v", t.Name, e.Kind, e.EntityID, err)
+					// Let the next detection of the event try again.
+					n.release(t, e)
+				}
+			}(t, e)
+		}
+	}
+}
+
+// Wait blocks until every in-flight delivery has finished.
+func (n *Notifier) Wait() {
+	if n == nil {
+		return
+	}
+	n.pending.Wait()
+}
+
+// Send delivers a single event to a target, retrying with exponential
+// backoff on network errors, 429 and 5xx responses.
+func (n *Notifier) Send(t Target, e Event) error {
+	body, err := Payload(t.Format, e)
+	if err != nil {
+		return err
+	}
+
+	httpClient := n.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	delay := n.Backoff
+	for attempt := 0; ; attempt++ {
+		err = post(httpClient, t.URL, body)
+		if err == nil || errors.Is(err, ErrPermanentFailed) || attempt >= t.MaxRetries {
+			return err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func post(httpClient *http.Client, url string, body []byte) error {
+	resp, err := httpClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrDeliveryFailed, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("%w: status %d", ErrDeliveryFailed, resp.StatusCode)
+	default:
+		return fmt.Errorf("%w: status %d", ErrPermanentFailed, resp.StatusCode)
+	}
+}
+
+// Payload renders the event in the wire format expected by the target.
+func Payload(format string, e Event) ([]byte, error) {
+	switch format {
+	case FormatSlack:
+		return json.Marshal(map[string]string{
+			"text": e.Message,
+		})
+	case FormatTeams:
+		return json.Marshal(map[string]string{
+			"@type":    "MessageCard",
+			"@context": "https://schema.org/extensions",
+			"summary":  string(e.Kind),
+			"title":    fmt.Sprintf("keepup: %s", e.Kind),
+			"text":     e.Message,
+		})
+	default:
+		return json.Marshal(e)
+	}
+}
+
+func (n *Notifier) claim(t Target, e Event) (bool, error) {
+	if n.Client == nil {
+		return true, nil
+	}
+	key := dedupKeyPrefix + dedupID(t, e)
+	return n.Client.SetNX(n.Context, key, time.Now().Unix(), n.DedupTTL).Result()
+}
+
+// release drops the claim of an event whose delivery failed.
+func (n *Notifier) release(t Target, e Event) {
+	if n.Client == nil {
+		return
+	}
+	if err := n.Client.Del(n.Context, dedupKeyPrefix+dedupID(t, e)).Err(); err != nil {
+		log.Printf("Can't release notification %s for %s: %v", e.Kind, e.EntityID, err)
+	}
+}
+
+func dedupID(t Target, e Event) string {
+	sum := sha1.Sum([]byte(fmt.Sprintf("%s|%s|%s|%s|%s|%s|%s",
+		t.Name, e.Kind, e.EntityID, e.Package, e.Version, e.EOLDate, e.LastSeen)))
+	return fmt.Sprintf("%x", sum)
+}
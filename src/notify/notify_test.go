@@ -0,0 +1,166 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestNotifier(t *testing.T, targets ...Target) *Notifier {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	return &Notifier{
+		Targets:  targets,
+		Client:   redis.NewClient(&redis.Options{Addr: mr.Addr()}),
+		Context:  context.Background(),
+		DedupTTL: time.Minute,
+	}
+}
+
+func TestParseTargets_FillsDefaults(t *testing.T) {
+	targets, err := ParseTargets(`[{"url":"http://example.invalid/hook"}]`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if targets[0].Format != FormatJSON || targets[0].MaxRetries != 3 || targets[0].Name == "" {
+		t.Errorf("expected defaults to be filled, got %+v", targets[0])
+	}
+}
+
+func TestParseTargets_KeepsExplicitZeroRetries(t *testing.T) {
+	targets, err := ParseTargets(`[{"url":"http://example.invalid/hook","max_retries":0}]`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if targets[0].MaxRetries != 0 {
+		t.Errorf("expected retries to stay disabled, got %d", targets[0].MaxRetries)
+	}
+	if _, err := ParseTargets(`[{"url":"http://example.invalid/hook","max_retries":-1}]`); err == nil {
+		t.Error("expected an error for negative retries")
+	}
+}
+
+func TestParseTargets_RejectsUnknownFormat(t *testing.T) {
+	if _, err := ParseTargets(`[{"url":"http://example.invalid","format":"irc"}]`); err == nil {
+		t.Fatal("expected an error for an unknown format")
+	}
+}
+
+func TestTargetMatches_FiltersByTeamAndDataCenter(t *testing.T) {
+	target := Target{Teams: []string{"platform"}, DataCenters: []string{"dc1"}}
+
+	if !target.Matches(Event{Team: "platform", DataCenter: "dc1"}) {
+		t.Error("expected matching team and data center to pass the filter")
+	}
+	if target.Matches(Event{Team: "web", DataCenter: "dc1"}) {
+		t.Error("expected another team to be filtered out")
+	}
+	if target.Matches(Event{Team: "platform", DataCenter: "dc2"}) {
+		t.Error("expected another data center to be filtered out")
+	}
+}
+
+func TestPayload_SlackAndTeamsCarryMessage(t *testing.T) {
+	e := Event{Kind: EventPackageExpired, Message: "redis 6.0 is expired"}
+	for _, format := range []string{FormatSlack, FormatTeams} {
+		body, err := Payload(format, e)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var doc map[string]string
+		if err := json.Unmarshal(body, &doc); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if doc["text"] != e.Message {
+			t.Errorf("expected %s payload text %q, got %q", format, e.Message, doc["text"])
+		}
+	}
+}
+
+func TestSend_RetriesServerErrors(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	n := newTestNotifier(t)
+	if err := n.Send(Target{URL: srv.URL, MaxRetries: 3}, Event{}); err != nil {
+		t.Fatalf("expected delivery to succeed after retries, got %v", err)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("expected 3 attempts, got %d", calls.Load())
+	}
+}
+
+func TestSend_DoesNotRetryClientErrors(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	n := newTestNotifier(t)
+	if err := n.Send(Target{URL: srv.URL, MaxRetries: 3}, Event{}); err == nil {
+		t.Fatal("expected an error for a rejected payload")
+	}
+	if calls.Load() != 1 {
+		t.Errorf("expected a single attempt, got %d", calls.Load())
+	}
+}
+
+func TestNotify_DeduplicatesAcrossNotifiers(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	}))
+	defer srv.Close()
+
+	first := newTestNotifier(t, Target{Name: "hook", URL: srv.URL})
+	// A second replica sharing the same Redis.
+	second := &Notifier{Targets: first.Targets, Client: first.Client, Context: first.Context, DedupTTL: time.Minute}
+
+	e := Event{Kind: EventPackageExpired, EntityID: uuid.New(), Package: "redis", Version: "6.0"}
+	first.Notify(e)
+	second.Notify(e)
+	first.Wait()
+	second.Wait()
+
+	if calls.Load() != 1 {
+		t.Errorf("expected the event to be delivered once, got %d deliveries", calls.Load())
+	}
+}
+
+func TestNotify_ReleasesClaimWhenDeliveryFails(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	n := newTestNotifier(t, Target{Name: "hook", URL: srv.URL})
+	e := Event{Kind: EventEntityMissing, EntityID: uuid.New()}
+	n.Notify(e)
+	n.Wait()
+	n.Notify(e)
+	n.Wait()
+
+	if calls.Load() != 2 {
+		t.Errorf("expected the failed event to be sent again, got %d deliveries", calls.Load())
+	}
+}